@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"rest_api/internal/config"
+	storage "rest_api/internal/db"
+	"rest_api/internal/db/sqlite"
+)
+
+// newConfig returns the config of a sqlite database in a temp dir, search
+// falls back to substrings as the tests run without -tags sqlite_fts5
+func newConfig(t *testing.T) *config.Config {
+	t.Helper()
+	return &config.Config{
+		StoragePath:    filepath.Join(t.TempDir(), "storage.db"),
+		StorageDriver:  "sqlite",
+		AutoMigrate:    true,
+		SearchFallback: true,
+		Policy:         config.DefaultPolicy(),
+		Registration:   config.Registration{Mode: config.RegistrationOpen},
+	}
+}
+
+// runCommand runs the command args like main does and returns its exit code
+// and what it printed to stdout, usage errors on stderr are dropped
+func runCommand(t *testing.T, cfg *config.Config, args ...string) (int, string) {
+	t.Helper()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		t.Fatalf("unknown command %q", args[0])
+	}
+	db, dialect, err := openDatabase(cfg)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	// tasks closes the database with its storage
+	defer db.Close()
+	if cmd.migrate {
+		if err := migrateOnStartup(db, dialect, cfg.AutoMigrate, log); err != nil {
+			t.Fatalf("migrate: %v", err)
+		}
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("pipe: %v", err)
+	}
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatalf("open %s: %v", os.DevNull, err)
+	}
+	defer devNull.Close()
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = w, devNull
+	out := make(chan string)
+	go func() {
+		var buf bytes.Buffer
+		io.Copy(&buf, r)
+		out <- buf.String()
+	}()
+
+	code := cmd.run(&app{cfg: cfg, log: log, db: db, dialect: dialect}, args[1:])
+	os.Stdout, os.Stderr = stdout, stderr
+	w.Close()
+	return code, <-out
+}
+
+// runJSON runs a command that prints JSON, fails the test unless it exits
+// with 0 and decodes its output into v
+func runJSON(t *testing.T, cfg *config.Config, v any, args ...string) {
+	t.Helper()
+
+	code, out := runCommand(t, cfg, args...)
+	if code != 0 {
+		t.Fatalf("%v exited with %d", args, code)
+	}
+	if err := json.Unmarshal([]byte(out), v); err != nil {
+		t.Fatalf("%v printed %q: %v", args, out, err)
+	}
+}
+
+func TestKeysCommand(t *testing.T) {
+	cfg := newConfig(t)
+
+	var created struct {
+		ID     int64  `json:"id"`
+		APIKey string `json:"api_key"`
+	}
+	runJSON(t, cfg, &created, "keys", "create", "--owner", "alice", "--perm", "task.read", "-o", "json")
+	if created.ID == 0 || created.APIKey == "" {
+		t.Fatalf("keys create printed %+v, want the id and the key", created)
+	}
+
+	var keys []map[string]any
+	runJSON(t, cfg, &keys, "keys", "list", "-o", "json")
+	if len(keys) != 1 || keys[0]["owner"] != "alice" || keys[0]["status"] != "active" {
+		t.Fatalf("keys list = %v, want alice", keys)
+	}
+	if _, ok := keys[0]["key"]; ok {
+		t.Errorf("keys list prints the hash: %v", keys[0])
+	}
+
+	id := strconv.FormatInt(created.ID, 10)
+	if code, _ := runCommand(t, cfg, "keys", "revoke", id); code != 0 {
+		t.Errorf("keys revoke exited with %d", code)
+	}
+	runJSON(t, cfg, &keys, "keys", "list", "-o", "json")
+	if keys[0]["revoked"] != true {
+		t.Errorf("keys list after the revoke = %v, want revoked", keys)
+	}
+
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{[]string{"keys", "create"}, 2},
+		{[]string{"keys", "create", "--owner", "bob", "--perm", "report.read"}, 1},
+		{[]string{"keys", "revoke", "abc"}, 2},
+		{[]string{"keys", "revoke", "999"}, 1},
+		{[]string{"keys", "list", "-o", "yaml"}, 2},
+		{[]string{"keys", "rename"}, 2},
+	}
+	for _, tt := range tests {
+		if code, _ := runCommand(t, cfg, tt.args...); code != tt.code {
+			t.Errorf("%v exited with %d, want %d", tt.args, code, tt.code)
+		}
+	}
+}
+
+func TestPermsCommand(t *testing.T) {
+	cfg := newConfig(t)
+
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	runJSON(t, cfg, &created, "keys", "create", "--owner", "alice", "--perm", "task.read", "-o", "json")
+	id := strconv.FormatInt(created.ID, 10)
+
+	if code, _ := runCommand(t, cfg, "perms", "grant", id, "task.create"); code != 0 {
+		t.Fatalf("perms grant exited with %d", code)
+	}
+	var perms []permView
+	runJSON(t, cfg, &perms, "perms", "list", "--key", id, "-o", "json")
+	if len(perms) != 2 || perms[0].Name != "task.create" || perms[1].Name != "task.read" {
+		t.Errorf("perms list --key = %+v, want task.create and task.read", perms)
+	}
+
+	if code, _ := runCommand(t, cfg, "perms", "revoke", id, "task.read"); code != 0 {
+		t.Fatalf("perms revoke exited with %d", code)
+	}
+	runJSON(t, cfg, &perms, "perms", "list", "--key", id, "-o", "json")
+	if len(perms) != 1 || perms[0].Name != "task.create" {
+		t.Errorf("perms list --key after the revoke = %+v, want task.create", perms)
+	}
+
+	// without --key every permission is listed
+	runJSON(t, cfg, &perms, "perms", "list", "-o", "json")
+	if len(perms) < 2 {
+		t.Errorf("perms list = %+v, want the seed permissions", perms)
+	}
+
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{[]string{"perms", "grant", id}, 2},
+		{[]string{"perms", "grant", "abc", "task.read"}, 2},
+		{[]string{"perms", "grant", id, "report.read"}, 1},
+		{[]string{"perms", "grant", "999", "task.read"}, 1},
+		{[]string{"perms", "revoke", id, "task.read"}, 1},
+	}
+	for _, tt := range tests {
+		if code, _ := runCommand(t, cfg, tt.args...); code != tt.code {
+			t.Errorf("%v exited with %d, want %d", tt.args, code, tt.code)
+		}
+	}
+}
+
+func TestTasksExportImport(t *testing.T) {
+	src, dst := newConfig(t), newConfig(t)
+	ctx := context.Background()
+
+	var owner struct {
+		ID int64 `json:"id"`
+	}
+	runJSON(t, src, &owner, "keys", "create", "--owner", "alice", "-o", "json")
+
+	db, err := sqlite.Open(src.StoragePath)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	repo, err := sqlite.New(db, true)
+	if err != nil {
+		t.Fatalf("sqlite.New: %v", err)
+	}
+	for _, title := range []string{"first", "second"} {
+		if _, err := repo.AddTask(ctx, storage.Task{Title: title, Priority: storage.PriorityHigh, OwnerKeyID: &owner.ID}); err != nil {
+			t.Fatalf("add task: %v", err)
+		}
+	}
+	repo.Close()
+
+	file := filepath.Join(t.TempDir(), "tasks.json")
+	if code, _ := runCommand(t, src, "tasks", "export", "--file", file); code != 0 {
+		t.Fatalf("tasks export exited with %d", code)
+	}
+	var exported []storage.Task
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("read export: %v", err)
+	}
+	if err := json.Unmarshal(data, &exported); err != nil || len(exported) != 2 {
+		t.Fatalf("export = %s, %v, want 2 tasks", data, err)
+	}
+
+	// tasks of unknown keys are refused before anything is read
+	if code, _ := runCommand(t, dst, "tasks", "import", "--file", file, "--owner-key", "999"); code != 1 {
+		t.Errorf("tasks import --owner-key 999 exited with %d, want 1", code)
+	}
+
+	var dstOwner struct {
+		ID int64 `json:"id"`
+	}
+	runJSON(t, dst, &dstOwner, "keys", "create", "--owner", "bob", "-o", "json")
+	var imported []struct {
+		OldID int64  `json:"old_id"`
+		ID    int64  `json:"id"`
+		Title string `json:"title"`
+	}
+	runJSON(t, dst, &imported, "tasks", "import", "--file", file, "--owner-key", strconv.FormatInt(dstOwner.ID, 10), "-o", "json")
+	if len(imported) != 2 || imported[0].OldID != exported[0].ID || imported[0].Title != exported[0].Title {
+		t.Fatalf("tasks import = %+v, want the exported tasks", imported)
+	}
+
+	file2 := filepath.Join(t.TempDir(), "tasks.json")
+	if code, _ := runCommand(t, dst, "tasks", "export", "--file", file2, "--owner-key", strconv.FormatInt(dstOwner.ID, 10)); code != 0 {
+		t.Fatalf("tasks export exited with %d", code)
+	}
+	var reimported []storage.Task
+	data, err = os.ReadFile(file2)
+	if err != nil {
+		t.Fatalf("read export: %v", err)
+	}
+	if err := json.Unmarshal(data, &reimported); err != nil || len(reimported) != 2 {
+		t.Fatalf("export of the imported tasks = %s, %v, want 2 tasks of bob", data, err)
+	}
+	if reimported[0].Priority != storage.PriorityHigh {
+		t.Errorf("imported priority = %v, want high", reimported[0].Priority)
+	}
+
+	// owner ids of the file belong to the other database and are dropped,
+	// here the id of alice is the id of bob
+	if owner.ID != dstOwner.ID {
+		t.Fatalf("alice has id %d, bob %d, want the same id in both databases", owner.ID, dstOwner.ID)
+	}
+	if code, _ := runCommand(t, dst, "tasks", "import", "--file", file); code != 0 {
+		t.Fatalf("tasks import without --owner-key exited with %d", code)
+	}
+	var all []storage.Task
+	runJSON(t, dst, &all, "tasks", "export")
+	var unowned int
+	for _, task := range all {
+		if task.OwnerKeyID == nil {
+			unowned++
+		}
+	}
+	if len(all) != 4 || unowned != 2 {
+		t.Errorf("tasks = %d, %d without owner, want 4 and 2", len(all), unowned)
+	}
+
+	// a task without title fails the whole import
+	bad := filepath.Join(t.TempDir(), "bad.json")
+	if err := os.WriteFile(bad, []byte(`[{"title":"ok"},{"title":""}]`), 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if code, _ := runCommand(t, dst, "tasks", "import", "--file", bad); code != 1 {
+		t.Errorf("tasks import of a task without title exited with %d, want 1", code)
+	}
+	runJSON(t, dst, &all, "tasks", "export")
+	if len(all) != 4 {
+		t.Errorf("%d tasks after the failed import, want 4", len(all))
+	}
+}
+
+func TestMigrateCommand(t *testing.T) {
+	cfg := newConfig(t)
+
+	var statuses []migrationView
+	runJSON(t, cfg, &statuses, "migrate", "status", "-o", "json")
+	if len(statuses) == 0 {
+		t.Fatal("migrate status lists no migrations")
+	}
+	for _, st := range statuses {
+		if st.Status != "pending" {
+			t.Errorf("migration %d is %s on a new database, want pending", st.Version, st.Status)
+		}
+	}
+
+	var applied []migrationView
+	runJSON(t, cfg, &applied, "migrate", "up", "-o", "json")
+	if len(applied) != len(statuses) {
+		t.Errorf("migrate up applied %d migrations, want %d", len(applied), len(statuses))
+	}
+
+	var reverted []migrationView
+	runJSON(t, cfg, &reverted, "migrate", "down", "2", "-o", "json")
+	if len(reverted) != 2 || reverted[0].Version != statuses[len(statuses)-1].Version {
+		t.Errorf("migrate down 2 = %+v, want the last 2 migrations", reverted)
+	}
+	runJSON(t, cfg, &statuses, "migrate", "status", "-o", "json")
+	if last := statuses[len(statuses)-1]; last.Status != "pending" || statuses[0].Status != "applied" {
+		t.Errorf("migrate status after down = %+v", statuses)
+	}
+
+	for _, args := range [][]string{{"migrate", "down", "0"}, {"migrate", "up", "1"}, {"migrate", "sideways"}} {
+		if code, _ := runCommand(t, cfg, args...); code != 2 {
+			t.Errorf("%v exited with %d, want 2", args, code)
+		}
+	}
+}
+
+func TestDBBackupCommand(t *testing.T) {
+	cfg := newConfig(t)
+	runJSON(t, cfg, new(map[string]any), "keys", "create", "--owner", "alice", "-o", "json")
+
+	out := filepath.Join(t.TempDir(), "backup.db")
+	if code, _ := runCommand(t, cfg, "db", "backup", "--out", out); code != 0 {
+		t.Fatalf("db backup exited with %d", code)
+	}
+	// the backup is a working database
+	backup := newConfig(t)
+	backup.StoragePath = out
+	var keys []map[string]any
+	runJSON(t, backup, &keys, "keys", "list", "-o", "json")
+	if len(keys) != 1 || keys[0]["owner"] != "alice" {
+		t.Errorf("keys of the backup = %v, want alice", keys)
+	}
+
+	if code, _ := runCommand(t, cfg, "db", "backup", "--out", out); code != 1 {
+		t.Errorf("db backup over an existing file exited with %d, want 1", code)
+	}
+	if code, _ := runCommand(t, cfg, "db", "backup"); code != 2 {
+		t.Errorf("db backup without --out exited with %d, want 2", code)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	storage "rest_api/internal/db"
+	sl "rest_api/internal/lib/logger/slog"
+)
+
+const dbUsage = "usage: api db backup --out PATH"
+
+// runDB implements the db command and returns the exit code
+func runDB(a *app, args []string) int {
+	if len(args) == 0 || args[0] != "backup" {
+		return usageError(nil, dbUsage)
+	}
+
+	fs := flag.NewFlagSet("db backup", flag.ContinueOnError)
+	out := fs.String("out", "", "file the backup is written to, it must not exist")
+	if pos, err := parseArgs(fs, args[1:]); err != nil || len(pos) > 0 {
+		return usageError(err, dbUsage)
+	}
+	if *out == "" {
+		return usageError(errors.New("--out is required"), dbUsage)
+	}
+
+	if a.dialect != storage.DialectSQLite {
+		a.log.Error("db backup only supports sqlite, use pg_dump for postgres")
+		return 1
+	}
+	if _, err := os.Stat(*out); err == nil {
+		a.log.Error("backup file already exists", slog.String("path", *out))
+		return 1
+	}
+
+	// VACUUM INTO writes a consistent, compacted copy while the server keeps running
+	if _, err := a.db.ExecContext(context.Background(), "VACUUM INTO ?", *out); err != nil {
+		a.log.Error("backup failed", sl.Err(err))
+		return 1
+	}
+
+	info, err := os.Stat(*out)
+	if err != nil {
+		a.log.Error("backup failed", sl.Err(err))
+		return 1
+	}
+	fmt.Printf("backup written to %s (%d bytes)\n", *out, info.Size())
+	return 0
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	sl "rest_api/internal/lib/logger/slog"
+)
+
+const keysUsage = `usage: api keys list [-o table|json]
+       api keys create --owner NAME [--perm NAME]... [-o table|json]
+       api keys revoke KEY_ID`
+
+// stringList is a flag that may be repeated, each use adds a value
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// keyView is a key as the keys command prints it, without its hash
+type keyView struct {
+	ID        int64      `json:"id"`
+	Prefix    string     `json:"prefix"`
+	Owner     string     `json:"owner"`
+	Status    string     `json:"status"`
+	Revoked   bool       `json:"revoked"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// runKeys implements the keys command and returns the exit code
+func runKeys(a *app, args []string) int {
+	if len(args) == 0 {
+		return usageError(nil, keysUsage)
+	}
+
+	authStorage, err := setupAuth(a)
+	if err != nil {
+		a.log.Error("failed to set up auth storage", sl.Err(err))
+		return 1
+	}
+
+	fs := flag.NewFlagSet("keys "+args[0], flag.ContinueOnError)
+	format := outputFlag(fs)
+
+	switch args[0] {
+	case "list":
+		if pos, err := parseArgs(fs, args[1:]); err != nil || len(pos) > 0 {
+			return usageError(err, keysUsage)
+		}
+		keys, err := authStorage.ListAPIKeys()
+		if err != nil {
+			a.log.Error("failed to list keys", sl.Err(err))
+			return 1
+		}
+
+		views := make([]keyView, 0, len(keys))
+		rows := make([][]string, 0, len(keys))
+		for _, k := range keys {
+			views = append(views, keyView{ID: k.ID, Prefix: k.Prefix, Owner: k.Owner, Status: k.Status, Revoked: k.Revoked, ExpiresAt: k.ExpiresAt})
+			rows = append(rows, []string{fmt.Sprint(k.ID), k.Prefix, k.Owner, k.Status, strconv.FormatBool(k.Revoked), formatTime(k.ExpiresAt)})
+		}
+		if err := printOutput(*format, views, []string{"ID", "PREFIX", "OWNER", "STATUS", "REVOKED", "EXPIRES AT"}, rows); err != nil {
+			a.log.Error("failed to print keys", sl.Err(err))
+			return 1
+		}
+
+	case "create":
+		owner := fs.String("owner", "", "owner of the new key")
+		var perms stringList
+		fs.Var(&perms, "perm", "permission to grant, may be repeated")
+		if pos, err := parseArgs(fs, args[1:]); err != nil || len(pos) > 0 {
+			return usageError(err, keysUsage)
+		}
+		if strings.TrimSpace(*owner) == "" {
+			return usageError(errors.New("--owner is required"), keysUsage)
+		}
+
+		key, keyID, err := authStorage.CreateKey(*owner, perms)
+		if err != nil {
+			a.log.Error("failed to create key", sl.Err(err))
+			return 1
+		}
+		created := struct {
+			ID          int64    `json:"id"`
+			Owner       string   `json:"owner"`
+			Permissions []string `json:"permissions"`
+			APIKey      string   `json:"api_key"`
+		}{keyID, *owner, append([]string{}, perms...), key}
+		row := []string{fmt.Sprint(keyID), *owner, perms.String(), key}
+		if err := printOutput(*format, created, []string{"ID", "OWNER", "PERMISSIONS", "API KEY"}, [][]string{row}); err != nil {
+			a.log.Error("failed to print key", sl.Err(err))
+			return 1
+		}
+
+	case "revoke":
+		pos, err := parseArgs(fs, args[1:])
+		if err != nil || len(pos) != 1 {
+			return usageError(err, keysUsage)
+		}
+		keyID, err := strconv.ParseInt(pos[0], 10, 64)
+		if err != nil {
+			return usageError(errors.New("key id must be an integer"), keysUsage)
+		}
+		if err := authStorage.RevokeKey(keyID); err != nil {
+			a.log.Error("failed to revoke key", sl.Err(err))
+			return 1
+		}
+		fmt.Printf("api key %d revoked\n", keyID)
+
+	default:
+		return usageError(fmt.Errorf("unknown keys command %q", args[0]), keysUsage)
+	}
+
+	return 0
+}
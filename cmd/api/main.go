@@ -1,113 +1,185 @@
 package main
 
 import (
-	"os"
+	"database/sql"
+	"fmt"
 	"log/slog"
-	_ "net/http"
-
-	"github.com/gin-gonic/gin"
+	"os"
 
-	sl "rest_api/internal/lib/logger/slog"
+	"rest_api/internal/auth"
 	"rest_api/internal/config"
+	storage "rest_api/internal/db"
+	"rest_api/internal/db/memory"
+	"rest_api/internal/db/postgres"
 	"rest_api/internal/db/sqlite"
-	"rest_api/internal/handler"
-	"rest_api/internal/auth"
+	"rest_api/internal/lib/logger/redact"
+	sl "rest_api/internal/lib/logger/slog"
 )
 
 const (
-	envlocal 	= "local"
-	envDev 		= "dev"
-	envProd		= "prod"
+	envlocal = "local"
+	envDev   = "dev"
+	envProd  = "prod"
 )
 
+const usage = `usage: api [command] [flags]
+
+Commands:
+  serve                         run the HTTP server (the default)
+  keys list | create | revoke   manage api keys
+  perms list | grant | revoke   manage the permissions of keys
+  tasks export | import         dump tasks as JSON or load them back
+  db backup                     copy the sqlite database to a file
+  migrate up | down | status    manage the schema
+
+Every command reads the config from CONF_PATH and works on the database
+directly, the server doesn't have to be running. Run "api <command> -h"
+for the flags of a command.`
+
+// app is what every command starts from
+type app struct {
+	cfg     *config.Config
+	log     *slog.Logger
+	db      *sql.DB
+	dialect storage.Dialect
+}
+
+type command struct {
+	run     func(a *app, args []string) int // returns the exit code
+	migrate bool                            // apply pending migrations first, or refuse with auto_migrate off
+}
+
+var commands = map[string]command{
+	"serve":   {run: runServe, migrate: true},
+	"keys":    {run: runKeys, migrate: true},
+	"perms":   {run: runPerms, migrate: true},
+	"tasks":   {run: runTasks, migrate: true},
+	"db":      {run: runDB},
+	"migrate": {run: runMigrate},
+}
+
 func main() {
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" || name == "-h" || name == "--help" {
+		fmt.Println(usage)
+		return
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s\n", name, usage)
+		os.Exit(2)
+	}
+
 	//initialization config
 	cfg := config.MustLoad()
 
-	// initialization logger
-	log := setupLog(cfg.Env)
-	log.Info("Start api", slog.String("env", cfg.Env))
-	log.Debug("Debug message are enabled")
-
-	//initialization storage(sqlite)
-	storage, err := sqlite.New(cfg.StoragePath)
-	if err != nil {
-	    log.Error("failed to init storage", sl.Err(err))
-	    os.Exit(1)
+	// initialization logger, the server logs to stdout. The other commands
+	// keep stdout for their output and only log warnings and errors.
+	log := setupLog(cfg.Env, cfg.LogRedactKeys)
+	if name != "serve" {
+		log = setupCommandLog(cfg.LogRedactKeys)
 	}
 
-	// init auth storage(work with api_keys, permission)
-	authStorage := auth.NewStorage(storage.DB())
-	if err := authStorage.Init(); err != nil {
-		log.Error("failed to init auth tables", sl.Err(err))
+	// open the database the schema and auth tables live in
+	db, dialect, err := openDatabase(cfg)
+	if err != nil {
+		log.Error("failed to open database", sl.Err(err))
 		os.Exit(1)
 	}
-	if err := authStorage.EnsureAdminSetup(log); err != nil {
-		log.Error("failed to ensure admin key", sl.Err(err))
-		os.Exit(1)
+
+	if cmd.migrate {
+		if err := migrateOnStartup(db, dialect, cfg.AutoMigrate, log); err != nil {
+			log.Error("failed to migrate database", sl.Err(err))
+			os.Exit(1)
+		}
 	}
 
-	// create permission
-	permissionsToCreate := []string{"task.create", "task.delete", "task.update"}
-	for _, perm := range permissionsToCreate {
-    if err := authStorage.CreatePermission(perm); err != nil {
-        log.Warn("failed to create permission", slog.String("permission", perm), sl.Err(err))
-    } else {
-        log.Info("created permission", slog.String("permission", perm))
-    }
+	code := cmd.run(&app{cfg: cfg, log: log, db: db, dialect: dialect}, args)
+	if err := db.Close(); err != nil {
+		log.Error("failed to close database", sl.Err(err))
+		code = 1
+	}
+	os.Exit(code)
 }
-	// init services & handlers
-	taskHandler := handler.NewTaskHandler(storage, log)
-	authService := auth.NewService(authStorage)
-	authHandler := handler.NewAuthorization(authService, log)
-
-
-	// init router: gin
-	r := gin.Default()
-
-	//Public routes
-	r.GET("/task/:id", taskHandler.GetTaskByID)
-	r.POST("/register", authHandler.Register)
-
-	// Admin routes
-	admin := r.Group("/admin",
-		auth.AuthMiddleware(authStorage, log),
-		auth.RequirePermission(authStorage, "admin", log),
-	)
-	{
-		admin.GET("/keys", authHandler.ListKeys)
-		admin.GET("/permissions", authHandler.ListPermissions)
-		admin.POST("/permissions", authHandler.CreatePermission)
-		admin.POST("/keys/:id/permissions", authHandler.GrantPermission)
+
+// setupAuth loads the roles of the policy and creates the permissions the
+// routes depend on
+func setupAuth(a *app) (*auth.Storage, error) {
+	authStorage := auth.NewStorage(a.db, a.dialect)
+	if err := authStorage.SyncRoles(a.cfg.Policy.Roles); err != nil {
+		return nil, fmt.Errorf("failed to load roles: %w", err)
 	}
 
-	// Protected routes
-	authorized := r.Group("/", auth.AuthMiddleware(authStorage, log))
-{
-    authorized.POST("/task", auth.RequirePermission(authStorage, "task.create", log), taskHandler.CreateTask)
-    authorized.DELETE("/task/:id", auth.RequirePermission(authStorage, "task.delete", log), taskHandler.DeleteTaskByID)
-    authorized.PATCH("/task/:id/completed", auth.RequirePermission(authStorage, "task.update", log), taskHandler.CompletedTask)
-    authorized.PATCH("/task/:id/uncompleted", auth.RequirePermission(authStorage, "task.update", log), taskHandler.UncompletedTask)
-}
-	if err := r.Run(":8080"); err != nil {
-		log.Error("Failed to run server", sl.Err(err))
-		os.Exit(1)
+	// create permission
+	for _, perm := range auth.SeedPermissions {
+		if err := authStorage.CreatePermission(perm); err != nil {
+			a.log.Warn("failed to create permission", slog.String("permission", perm), sl.Err(err))
+		} else {
+			a.log.Info("created permission", slog.String("permission", perm))
+		}
 	}
+	return authStorage, nil
 }
 
-//  setupLog configures the logger depending on the environment (local/dev/prod).
-func setupLog(env string) *slog.Logger {
-	var log *slog.Logger
+// setupLog configures the logger depending on the environment (local/dev/prod).
+func setupLog(env string, redactKeys []string) *slog.Logger {
+	var h slog.Handler
 
 	switch env {
 	case envlocal:
-		log = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		h = slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug})
 	case envDev:
-		log = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
- 	case envProd:
- 		log = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
- 	default:
- 		log = slog.New (slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
- 	}
- 	return log
-}
\ No newline at end of file
+		h = slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug})
+	case envProd:
+		h = slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo})
+	default:
+		h = slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo})
+	}
+	return slog.New(redact.NewHandler(h, redactKeys...))
+}
+
+// setupCommandLog configures the logger of the commands other than serve:
+// text on stderr, warnings and errors only.
+func setupCommandLog(redactKeys []string) *slog.Logger {
+	h := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})
+	return slog.New(redact.NewHandler(h, redactKeys...))
+}
+
+// openDatabase opens the database selected by the storage_driver config key.
+// The memory backend keeps tasks in memory only, auth tables stay in sqlite.
+func openDatabase(cfg *config.Config) (*sql.DB, storage.Dialect, error) {
+	switch cfg.StorageDriver {
+	case "", "sqlite", "memory":
+		db, err := sqlite.Open(cfg.StoragePath)
+		return db, storage.DialectSQLite, err
+	case "postgres":
+		db, err := postgres.Open(cfg.PostgresDSN)
+		return db, storage.DialectPostgres, err
+	default:
+		return nil, "", fmt.Errorf("unknown storage driver %q", cfg.StorageDriver)
+	}
+}
+
+// setupTaskStorage creates the task backend on top of the migrated database.
+func setupTaskStorage(a *app) (storage.TaskRepository, error) {
+	switch a.cfg.StorageDriver {
+	case "", "sqlite":
+		s, err := sqlite.New(a.db, a.cfg.SearchFallback)
+		if err != nil {
+			return nil, err
+		}
+		if !s.FullTextSearch() {
+			a.log.Warn("sqlite is built without FTS5, task search falls back to substring matching; build with -tags sqlite_fts5")
+		}
+		return s, nil
+	case "memory":
+		return memory.New(), nil
+	case "postgres":
+		return postgres.New(a.db), nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", a.cfg.StorageDriver)
+	}
+}
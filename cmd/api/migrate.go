@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	storage "rest_api/internal/db"
+	"rest_api/internal/db/migrate"
+	sl "rest_api/internal/lib/logger/slog"
+)
+
+const migrateUsage = "usage: api migrate up | down [STEPS] | status [-o table|json]"
+
+// migrateOnStartup applies pending migrations, or refuses to start on an
+// outdated schema when auto_migrate is off.
+func migrateOnStartup(db *sql.DB, dialect storage.Dialect, auto bool, log *slog.Logger) error {
+	ctx := context.Background()
+
+	m, err := migrate.New(db, dialect)
+	if err != nil {
+		return err
+	}
+
+	if !auto {
+		pending, err := m.Pending(ctx)
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			return fmt.Errorf("%d pending migrations and auto_migrate is disabled, run `api migrate up`", len(pending))
+		}
+		return nil
+	}
+
+	applied, err := m.Up(ctx)
+	for _, mg := range applied {
+		log.Info("migration applied", slog.Int("version", mg.Version), slog.String("name", mg.Name))
+	}
+	return err
+}
+
+// runMigrate implements the migrate command and returns the exit code.
+func runMigrate(a *app, args []string) int {
+	ctx := context.Background()
+
+	if len(args) == 0 {
+		return usageError(nil, migrateUsage)
+	}
+
+	fs := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	format := outputFlag(fs)
+	pos, err := parseArgs(fs, args[1:])
+	if err != nil {
+		return usageError(err, migrateUsage)
+	}
+
+	m, err := migrate.New(a.db, a.dialect)
+	if err != nil {
+		a.log.Error("failed to load migrations", sl.Err(err))
+		return 1
+	}
+
+	var (
+		done []migrate.Migration
+		verb string
+		code int
+	)
+	switch args[0] {
+	case "up":
+		if len(pos) > 0 {
+			return usageError(nil, migrateUsage)
+		}
+		verb = "applied"
+		if done, err = m.Up(ctx); err != nil {
+			a.log.Error("migrate up failed", sl.Err(err))
+			code = 1
+		}
+
+	case "down":
+		steps := 1
+		if len(pos) > 1 {
+			return usageError(nil, migrateUsage)
+		}
+		if len(pos) == 1 {
+			steps, err = strconv.Atoi(pos[0])
+			if err != nil || steps < 1 {
+				return usageError(errors.New("steps must be a positive integer"), migrateUsage)
+			}
+		}
+		verb = "reverted"
+		if done, err = m.Down(ctx, steps); err != nil {
+			a.log.Error("migrate down failed", sl.Err(err))
+			code = 1
+		}
+
+	case "status":
+		if len(pos) > 0 {
+			return usageError(nil, migrateUsage)
+		}
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			a.log.Error("migrate status failed", sl.Err(err))
+			return 1
+		}
+		views := make([]migrationView, 0, len(statuses))
+		rows := make([][]string, 0, len(statuses))
+		for _, st := range statuses {
+			status := "pending"
+			var at *time.Time
+			if st.Applied {
+				status, at = "applied", st.AppliedAt
+			}
+			views = append(views, migrationView{Version: st.Version, Name: st.Name, Status: status, AppliedAt: at})
+			rows = append(rows, []string{fmt.Sprintf("%04d", st.Version), st.Name, status, formatTime(at)})
+		}
+		if err := printOutput(*format, views, []string{"VERSION", "NAME", "STATUS", "APPLIED AT"}, rows); err != nil {
+			a.log.Error("failed to print migrations", sl.Err(err))
+			return 1
+		}
+		return 0
+
+	default:
+		return usageError(fmt.Errorf("unknown migrate command %q", args[0]), migrateUsage)
+	}
+
+	// up and down print what they changed, even when they failed halfway
+	views := make([]migrationView, 0, len(done))
+	rows := make([][]string, 0, len(done))
+	for _, mg := range done {
+		views = append(views, migrationView{Version: mg.Version, Name: mg.Name, Status: verb})
+		rows = append(rows, []string{fmt.Sprintf("%04d", mg.Version), mg.Name, verb})
+	}
+	if err := printOutput(*format, views, []string{"VERSION", "NAME", "STATUS"}, rows); err != nil {
+		a.log.Error("failed to print migrations", sl.Err(err))
+		return 1
+	}
+	return code
+}
+
+// migrationView is a migration as the migrate command prints it
+type migrationView struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Status    string     `json:"status"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
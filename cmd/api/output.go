@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Output formats of the commands that print data
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+)
+
+// outputFlag registers -o/-output on fs
+func outputFlag(fs *flag.FlagSet) *string {
+	format := fs.String("output", formatTable, "output format: table or json")
+	fs.StringVar(format, "o", formatTable, "shorthand for -output")
+	return format
+}
+
+// parseArgs parses the flags in args into fs, they may come before, between
+// or after the positional arguments, which are returned. It rejects unknown
+// output formats.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+
+	if f := fs.Lookup("output"); f != nil {
+		if v := f.Value.String(); v != formatTable && v != formatJSON {
+			return nil, fmt.Errorf("unknown output format %q, use table or json", v)
+		}
+	}
+	return positional, nil
+}
+
+// printOutput writes v as indented JSON, or the rows under header as a table
+func printOutput(format string, v any, header []string, rows [][]string) error {
+	if format == formatJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+// formatTime formats t for tables, empty when nil
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+// usageError prints the error of the command line and the usage of the command
+func usageError(err error, usage string) int {
+	if err != nil && err != flag.ErrHelp {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	fmt.Fprintln(os.Stderr, usage)
+	return 2
+}
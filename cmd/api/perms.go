@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"rest_api/internal/auth"
+	sl "rest_api/internal/lib/logger/slog"
+)
+
+const permsUsage = `usage: api perms list [--key KEY_ID] [-o table|json]
+       api perms grant KEY_ID PERMISSION
+       api perms revoke KEY_ID PERMISSION`
+
+// permView is a permission as the perms command prints it
+type permView struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+// runPerms implements the perms command and returns the exit code
+func runPerms(a *app, args []string) int {
+	if len(args) == 0 {
+		return usageError(nil, permsUsage)
+	}
+
+	authStorage, err := setupAuth(a)
+	if err != nil {
+		a.log.Error("failed to set up auth storage", sl.Err(err))
+		return 1
+	}
+	service := auth.NewService(authStorage, a.cfg.Policy.DefaultRole, a.cfg.Registration)
+
+	fs := flag.NewFlagSet("perms "+args[0], flag.ContinueOnError)
+	format := outputFlag(fs)
+
+	switch args[0] {
+	case "list":
+		keyID := fs.Int64("key", 0, "list the permissions granted to this key only")
+		if pos, err := parseArgs(fs, args[1:]); err != nil || len(pos) > 0 {
+			return usageError(err, permsUsage)
+		}
+
+		var perms []auth.Permission
+		if *keyID != 0 {
+			perms, err = authStorage.KeyPermissions(*keyID)
+		} else {
+			perms, err = service.ListPermissions()
+		}
+		if err != nil {
+			a.log.Error("failed to list permissions", sl.Err(err))
+			return 1
+		}
+
+		views := make([]permView, 0, len(perms))
+		rows := make([][]string, 0, len(perms))
+		for _, p := range perms {
+			views = append(views, permView{ID: p.ID, Name: p.Name})
+			rows = append(rows, []string{fmt.Sprint(p.ID), p.Name})
+		}
+		if err := printOutput(*format, views, []string{"ID", "NAME"}, rows); err != nil {
+			a.log.Error("failed to print permissions", sl.Err(err))
+			return 1
+		}
+
+	case "grant", "revoke":
+		pos, err := parseArgs(fs, args[1:])
+		if err != nil || len(pos) != 2 {
+			return usageError(err, permsUsage)
+		}
+		keyID, err := strconv.ParseInt(pos[0], 10, 64)
+		if err != nil {
+			return usageError(errors.New("key id must be an integer"), permsUsage)
+		}
+		perm := pos[1]
+
+		done := "granted to"
+		if args[0] == "grant" {
+			err = authStorage.GrantPermission(pos[0], perm)
+		} else {
+			done = "revoked from"
+			err = authStorage.RevokePermission(keyID, perm)
+		}
+		if err != nil {
+			a.log.Error("failed to "+args[0]+" permission", sl.Err(err))
+			return 1
+		}
+		fmt.Printf("permission %s %s api key %d\n", perm, done, keyID)
+
+	default:
+		return usageError(fmt.Errorf("unknown perms command %q", args[0]), permsUsage)
+	}
+
+	return 0
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+
+	"rest_api/internal/auth"
+	"rest_api/internal/db/migrate"
+	"rest_api/internal/db/traced"
+	"rest_api/internal/handler"
+	"rest_api/internal/lib/buildinfo"
+	sl "rest_api/internal/lib/logger/slog"
+	"rest_api/internal/metrics"
+	"rest_api/internal/middleware"
+	"rest_api/internal/tracing"
+)
+
+// runServe implements the serve command: it runs the HTTP server until
+// SIGINT/SIGTERM and returns the exit code.
+func runServe(a *app, args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: api serve")
+		return 2
+	}
+	cfg, log, db, dialect := a.cfg, a.log, a.db, a.dialect
+
+	build := buildinfo.Get()
+	log.Info("Start api", slog.String("env", cfg.Env), slog.String("version", build.Version), slog.String("commit", build.Commit))
+	log.Debug("Debug message are enabled")
+
+	// initialization tracing, spans are exported only if an exporter is configured
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		log.Error("failed to set up tracing", sl.Err(err))
+		return 1
+	}
+
+	//initialization storage(sqlite, memory or postgres)
+	taskStorage, err := setupTaskStorage(a)
+	if err != nil {
+		log.Error("failed to init storage", sl.Err(err))
+		return 1
+	}
+	log.Info("Storage initialized", slog.String("driver", cfg.StorageDriver))
+
+	// init auth storage(work with api_keys, permission)
+	authStorage, err := setupAuth(a)
+	if err != nil {
+		log.Error("failed to set up auth storage", sl.Err(err))
+		return 1
+	}
+
+	adminKey, err := authStorage.EnsureAdminSetup(log, cfg.AuthToken)
+	if err != nil {
+		log.Error("failed to ensure admin key", sl.Err(err))
+		return 1
+	}
+	if adminKey != "" {
+		// printed outside the log so it never reaches log storage
+		fmt.Fprintf(os.Stderr, "Admin API key: %s\nSave it securely, it will not be shown again.\n", adminKey)
+	}
+
+	// init services & handlers
+	tasks := traced.New(taskStorage, cfg.StorageDriver)
+	taskHandler := handler.NewTaskHandler(tasks, log)
+	authService := auth.NewService(authStorage, cfg.Policy.DefaultRole, cfg.Registration)
+	authHandler := handler.NewAuthorization(authService, log)
+	migrator, err := migrate.New(db, dialect)
+	if err != nil {
+		log.Error("failed to load migrations", sl.Err(err))
+		return 1
+	}
+	healthHandler := handler.NewHealthHandler(db, authStorage, migrator, log)
+	rateLimiter := middleware.NewRateLimiter(cfg.RateLimit, authStorage, log)
+
+	// metrics: task counts and connection pool are read at scrape time
+	if err := metrics.RegisterTasks(taskStorage); err != nil {
+		log.Error("failed to register task metrics", sl.Err(err))
+		return 1
+	}
+	if err := metrics.RegisterDB(db, string(dialect)); err != nil {
+		log.Error("failed to register database metrics", sl.Err(err))
+		return 1
+	}
+
+	// init router: gin
+	// gin's own logger and recovery write plain text, ours log with slog.
+	// ErrorHandler writes the response of failed requests, so it must run
+	// inside the middlewares that look at the status.
+	r := gin.New()
+	r.Use(
+		otelgin.Middleware(cfg.Tracing.ServiceName),
+		middleware.RequestID(),
+		middleware.Logger(log),
+		metrics.Middleware(),
+		handler.ErrorHandler(),
+		middleware.Recovery(log),
+	)
+	r.NoRoute(handler.NoRoute)
+	// c.ClientIP() keys the per-IP rate limit, X-Forwarded-For is only
+	// believed from the configured proxies
+	if err := r.SetTrustedProxies(cfg.HTTPServer.TrustedProxies); err != nil {
+		log.Error("invalid trusted proxies", sl.Err(err))
+		return 1
+	}
+
+	// Probes
+	r.GET("/healthz", healthHandler.Healthz)
+	r.GET("/readyz", healthHandler.Readyz)
+	r.GET("/version", healthHandler.Version)
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+	//Public routes
+	r.POST("/register", rateLimiter.PerIP(), authHandler.Register)
+
+	// Admin routes, the client IP is limited before the key is checked so
+	// guessing keys is limited too
+	admin := r.Group("/admin",
+		rateLimiter.PerClientIP(),
+		auth.AuthMiddleware(authStorage, log),
+		rateLimiter.PerKey(),
+		auth.RequirePermission(authStorage, "admin", log),
+	)
+	{
+		admin.GET("/keys", authHandler.ListKeys)
+		admin.GET("/permissions", authHandler.ListPermissions)
+		admin.POST("/permissions", authHandler.CreatePermission)
+		admin.DELETE("/permissions/:name", authHandler.DeletePermission)
+		admin.GET("/keys/:id/permissions", authHandler.ListKeyPermissions)
+		admin.POST("/keys/:id/permissions", authHandler.GrantPermission)
+		admin.DELETE("/keys/:id/permissions/:name", authHandler.RevokePermission)
+		admin.GET("/keys/:id/explain", authHandler.ExplainPermission)
+		admin.GET("/keys/:id/roles", authHandler.ListKeyRoles)
+		admin.POST("/keys/:id/roles", authHandler.AssignRole)
+		admin.DELETE("/keys/:id/roles/:role", authHandler.UnassignRole)
+		admin.GET("/roles", authHandler.ListRoles)
+		admin.POST("/roles", authHandler.CreateRole)
+		admin.DELETE("/roles/:name", authHandler.DeleteRole)
+		admin.POST("/roles/:name/permissions", authHandler.GrantRolePermission)
+		admin.DELETE("/roles/:name/permissions/:permission", authHandler.RevokeRolePermission)
+		admin.POST("/keys/:id/revoke", authHandler.RevokeKey)
+		admin.POST("/keys/:id/rotate", authHandler.RotateKey)
+		admin.PUT("/keys/:id/expiry", authHandler.SetKeyExpiry)
+		admin.GET("/keys/:id/limits", authHandler.GetKeyLimits)
+		admin.PUT("/keys/:id/limits", authHandler.SetKeyLimits)
+		admin.PUT("/roles/:name/limits", authHandler.SetRoleLimits)
+		admin.POST("/invites", authHandler.CreateInvite)
+		admin.GET("/invites", authHandler.ListInvites)
+		admin.DELETE("/invites/:id", authHandler.DeleteInvite)
+		admin.GET("/registrations", authHandler.ListRegistrations)
+		admin.POST("/registrations/:id/approve", authHandler.ApproveRegistration)
+		admin.POST("/registrations/:id/reject", authHandler.RejectRegistration)
+	}
+
+	// Protected routes
+	authorized := r.Group("/", rateLimiter.PerClientIP(), auth.AuthMiddleware(authStorage, log), rateLimiter.PerKey())
+	{
+		authorized.POST("/keys/rotate", authHandler.RotateOwnKey)
+		authorized.GET("/task", auth.RequirePermission(authStorage, "task.read", log), taskHandler.ListTasks)
+		authorized.GET("/task/search", auth.RequirePermission(authStorage, "task.read", log), taskHandler.SearchTasks)
+		authorized.GET("/task/:id", auth.RequirePermission(authStorage, "task.read", log), taskHandler.GetTaskByID)
+		authorized.POST("/task", auth.RequirePermission(authStorage, "task.create", log), rateLimiter.DailyTaskQuota(), taskHandler.CreateTask)
+		authorized.DELETE("/task/:id", auth.RequirePermission(authStorage, "task.delete", log), taskHandler.DeleteTaskByID)
+		authorized.PUT("/task/:id", auth.RequirePermission(authStorage, "task.update", log), taskHandler.ReplaceTask)
+		authorized.PATCH("/task/:id", auth.RequirePermission(authStorage, "task.update", log), taskHandler.PatchTask)
+		authorized.PATCH("/task/:id/completed", auth.RequirePermission(authStorage, "task.update", log), taskHandler.CompletedTask)
+		authorized.PATCH("/task/:id/uncompleted", auth.RequirePermission(authStorage, "task.update", log), taskHandler.UncompletedTask)
+	}
+	// SIGINT/SIGTERM cancel ctx and start the shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := &http.Server{
+		Addr:         cfg.HTTPServer.Address,
+		Handler:      r,
+		ReadTimeout:  cfg.HTTPServer.Timeout,
+		WriteTimeout: cfg.HTTPServer.Timeout,
+		IdleTimeout:  cfg.HTTPServer.IdleTimeout,
+	}
+
+	code := 0
+	// draining requests, closing the storage and flushing traces share one deadline
+	shutdownCtx, cancel, err := serve(ctx, srv, cfg.HTTPServer.ShutdownTimeout, log)
+	defer cancel()
+	if err != nil {
+		log.Error("Failed to run server", sl.Err(err))
+		code = 1
+	}
+
+	if err := closeWithin(shutdownCtx, taskStorage.Close); err != nil {
+		log.Error("failed to close storage", sl.Err(err))
+		code = 1
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Error("failed to flush traces", sl.Err(err))
+	}
+	log.Info("Stopped")
+	return code
+}
+
+// serve runs srv until ctx is cancelled, then shuts it down gracefully. The
+// returned context ends timeout after the shutdown started: in-flight requests
+// get until then and the caller finishes its cleanup within the same deadline.
+func serve(ctx context.Context, srv *http.Server, timeout time.Duration, log *slog.Logger) (context.Context, context.CancelFunc, error) {
+	errCh := make(chan error, 1)
+	go func() {
+		log.Info("Server started", slog.String("address", srv.Addr))
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		return shutdownCtx, cancel, err
+	case <-ctx.Done():
+	}
+
+	log.Info("Shutting down", slog.Duration("timeout", timeout))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		srv.Close()
+		return shutdownCtx, cancel, fmt.Errorf("graceful shutdown: %w", err)
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return shutdownCtx, cancel, err
+	}
+	return shutdownCtx, cancel, nil
+}
+
+// closeWithin runs close and gives up on it when ctx ends first
+func closeWithin(ctx context.Context, close func() error) error {
+	done := make(chan error, 1)
+	go func() { done <- close() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("not closed before the shutdown deadline: %w", ctx.Err())
+	}
+}
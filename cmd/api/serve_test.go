@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// freeAddr returns a local address nothing listens on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func TestServeShutdownSharesOneDeadline(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	srv := &http.Server{Addr: freeAddr(t), Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release // outlives the shutdown timeout
+	})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	type result struct {
+		ctx context.Context
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		shutdownCtx, cancel, err := serve(ctx, srv, timeout, log)
+		defer cancel()
+		done <- result{shutdownCtx, err}
+	}()
+
+	go func() {
+		for {
+			resp, err := http.Get("http://" + srv.Addr)
+			if err == nil {
+				resp.Body.Close()
+				return
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request never reached the server")
+	}
+
+	begin := time.Now()
+	cancel()
+	res := <-done
+	if res.err == nil {
+		t.Error("serve returned no error although a request outlived the deadline")
+	}
+
+	// the storage close gets what is left of the same deadline, not a new one
+	slowClose := func() error { time.Sleep(5 * time.Second); return nil }
+	if err := closeWithin(res.ctx, slowClose); err == nil {
+		t.Error("closeWithin waited for a close past the deadline")
+	}
+	if elapsed := time.Since(begin); elapsed > timeout+timeout/2 {
+		t.Errorf("shutdown took %v, want about %v", elapsed, timeout)
+	}
+}
+
+func TestCloseWithin(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := closeWithin(ctx, func() error { return nil }); err != nil {
+		t.Errorf("closeWithin of a quick close = %v", err)
+	}
+	if err := closeWithin(ctx, func() error { return io.ErrClosedPipe }); err != io.ErrClosedPipe {
+		t.Errorf("closeWithin = %v, want the error of close", err)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"rest_api/internal/auth"
+	storage "rest_api/internal/db"
+	sl "rest_api/internal/lib/logger/slog"
+)
+
+const tasksUsage = `usage: api tasks export [--owner-key KEY_ID] [--file PATH]
+       api tasks import [--owner-key KEY_ID] [--file PATH] [-o table|json]
+
+export writes the tasks as a JSON array, import reads such an array and adds
+every task with a new id and timestamps, all of them or none. Imported tasks
+belong to --owner-key, without it they have no owner and only admin keys see
+them; owner_key_id in the file is ignored. --file defaults to stdout/stdin.`
+
+// importedTask is a task of an import file, a missing priority is normal
+// like in POST /task
+type importedTask struct {
+	storage.Task
+	Priority *storage.Priority `json:"priority"`
+}
+
+// runTasks implements the tasks command and returns the exit code
+func runTasks(a *app, args []string) int {
+	if len(args) == 0 {
+		return usageError(nil, tasksUsage)
+	}
+	if a.cfg.StorageDriver == "memory" {
+		a.log.Error("the memory storage driver keeps tasks inside the running server, there is nothing to export or import")
+		return 1
+	}
+
+	fs := flag.NewFlagSet("tasks "+args[0], flag.ContinueOnError)
+	ownerKey := fs.Int64("owner-key", 0, "export only the tasks of this key, or give imported tasks to it")
+	file := fs.String("file", "", "file to write or read, stdout/stdin when empty")
+
+	repo, err := setupTaskStorage(a)
+	if err != nil {
+		a.log.Error("failed to init storage", sl.Err(err))
+		return 1
+	}
+	defer repo.Close()
+
+	switch args[0] {
+	case "export":
+		if pos, err := parseArgs(fs, args[1:]); err != nil || len(pos) > 0 {
+			return usageError(err, tasksUsage)
+		}
+		return exportTasks(a, repo, *ownerKey, *file)
+
+	case "import":
+		format := outputFlag(fs)
+		if pos, err := parseArgs(fs, args[1:]); err != nil || len(pos) > 0 {
+			return usageError(err, tasksUsage)
+		}
+		if *ownerKey != 0 {
+			if err := auth.NewStorage(a.db, a.dialect).CheckKeyExists(*ownerKey); err != nil {
+				a.log.Error("nothing imported", sl.Err(err))
+				return 1
+			}
+		}
+		return importTasks(a, repo, *ownerKey, *file, *format)
+
+	default:
+		return usageError(fmt.Errorf("unknown tasks command %q", args[0]), tasksUsage)
+	}
+}
+
+func exportTasks(a *app, repo storage.TaskRepository, ownerKey int64, file string) int {
+	ctx := context.Background()
+
+	scope := storage.AnyOwner()
+	if ownerKey != 0 {
+		scope = storage.OwnedBy(ownerKey)
+	}
+
+	tasks := []storage.Task{}
+	opts := storage.ListOptions{Limit: storage.MaxListLimit}
+	for {
+		page, err := repo.ListTasks(ctx, scope, opts)
+		if err != nil {
+			a.log.Error("failed to list tasks", sl.Err(err))
+			return 1
+		}
+		tasks = append(tasks, page.Tasks...)
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+
+	out := os.Stdout
+	if file != "" {
+		f, err := os.Create(file)
+		if err != nil {
+			a.log.Error("failed to create export file", sl.Err(err))
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(tasks); err != nil {
+		a.log.Error("failed to write tasks", sl.Err(err))
+		return 1
+	}
+	if file != "" {
+		if err := out.Close(); err != nil {
+			a.log.Error("failed to write tasks", sl.Err(err))
+			return 1
+		}
+		fmt.Printf("exported %d tasks to %s\n", len(tasks), file)
+	}
+	return 0
+}
+
+func importTasks(a *app, repo storage.TaskRepository, ownerKey int64, file, format string) int {
+	ctx := context.Background()
+
+	var in io.Reader = os.Stdin
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			a.log.Error("failed to open import file", sl.Err(err))
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+
+	var tasks []importedTask
+	if err := json.NewDecoder(in).Decode(&tasks); err != nil {
+		a.log.Error("failed to read tasks, expected a JSON array of tasks", sl.Err(err))
+		return 1
+	}
+	for i, t := range tasks {
+		if t.Title == "" {
+			a.log.Error("nothing imported", sl.Err(fmt.Errorf("task %d: %w", i, errors.New("title is required"))))
+			return 1
+		}
+	}
+
+	add := make([]storage.Task, 0, len(tasks))
+	for _, it := range tasks {
+		t := it.Task
+		t.Priority = storage.PriorityNormal
+		if it.Priority != nil {
+			t.Priority = *it.Priority
+		}
+		// key ids of another database mean nothing here
+		t.OwnerKeyID = nil
+		if ownerKey != 0 {
+			t.OwnerKeyID = &ownerKey
+		}
+		add = append(add, t)
+	}
+
+	added, err := repo.AddTasks(ctx, add)
+	if err != nil {
+		a.log.Error("nothing imported", sl.Err(err))
+		return 1
+	}
+
+	type imported struct {
+		OldID int64  `json:"old_id"`
+		ID    int64  `json:"id"`
+		Title string `json:"title"`
+	}
+	done := make([]imported, 0, len(added))
+	rows := make([][]string, 0, len(added))
+	for i, t := range added {
+		done = append(done, imported{OldID: tasks[i].ID, ID: t.ID, Title: t.Title})
+		rows = append(rows, []string{fmt.Sprint(tasks[i].ID), fmt.Sprint(t.ID), t.Title})
+	}
+
+	if err := printOutput(format, done, []string{"OLD ID", "ID", "TITLE"}, rows); err != nil {
+		a.log.Error("failed to print imported tasks", sl.Err(err))
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrInvalidPermission = errors.New("invalid permission name")
+
+// Wildcard grants every permission below its prefix: "task.*" covers
+// task.create and task.comment.delete, "*" covers everything.
+const Wildcard = "*"
+
+// ValidPermissionName reports whether name is a dotted permission name.
+// A wildcard is only allowed as the whole last segment ("*", "task.*").
+func ValidPermissionName(name string) bool {
+	if name == "" || strings.ContainsAny(name, " \t\r\n") {
+		return false
+	}
+	segments := strings.Split(name, ".")
+	for i, seg := range segments {
+		if seg == "" {
+			return false
+		}
+		if strings.Contains(seg, Wildcard) && (seg != Wildcard || i != len(segments)-1) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchPermission reports whether the granted permission covers permission
+func MatchPermission(granted, permission string) bool {
+	if granted == permission || granted == Wildcard {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(granted, "."+Wildcard)
+	return ok && strings.HasPrefix(permission, prefix+".")
+}
+
+// Grant is a permission held by a key, directly or through a role
+type Grant struct {
+	Permission string `json:"permission"`
+	Role       string `json:"role,omitempty"` // empty for a direct grant
+}
+
+// Explanation tells why a key does or doesn't hold a permission
+type Explanation struct {
+	KeyID      int64   `json:"key_id"`
+	Permission string  `json:"permission"`
+	Allowed    bool    `json:"allowed"`
+	Reason     string  `json:"reason"`
+	MatchedBy  []Grant `json:"matched_by"` // grants covering the permission
+	Grants     []Grant `json:"grants"`     // everything the key holds
+}
+
+// KeyGrants returns every permission the key keyID holds with where it comes from
+func (s *Storage) KeyGrants(keyID int64) ([]Grant, error) {
+	const op = "auth.storage.KeyGrants"
+
+	rows, err := s.db.Query(s.q(`
+	SELECT p.name, ''
+	FROM api_key_permissions kp
+	JOIN permissions p ON p.id = kp.permission_id
+	WHERE kp.api_key_id = ?
+	UNION ALL
+	SELECT p.name, r.name
+	FROM api_key_roles kr
+	JOIN roles r ON r.id = kr.role_id
+	JOIN role_permissions rp ON rp.role_id = r.id
+	JOIN permissions p ON p.id = rp.permission_id
+	WHERE kr.api_key_id = ?
+	ORDER BY 1, 2`), keyID, keyID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	grants := []Grant{}
+	for rows.Next() {
+		var g Grant
+		if err := rows.Scan(&g.Permission, &g.Role); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		grants = append(grants, g)
+	}
+	return grants, rows.Err()
+}
+
+// ExplainPermission checks whether the key keyID holds permission and says why
+func (s *Storage) ExplainPermission(keyID int64, permission string) (Explanation, error) {
+	const op = "auth.storage.ExplainPermission"
+
+	e := Explanation{KeyID: keyID, Permission: permission, MatchedBy: []Grant{}}
+
+	var revoked bool
+	var expiresAt sql.NullTime
+	var status string
+	err := s.db.QueryRow(s.q("SELECT revoked, expires_at, status FROM api_keys WHERE id = ?"), keyID).Scan(&revoked, &expiresAt, &status)
+	if err == sql.ErrNoRows {
+		return e, fmt.Errorf("%s: id=%d: %w", op, keyID, ErrKeyNotFound)
+	} else if err != nil {
+		return e, fmt.Errorf("%s: %w", op, err)
+	}
+
+	e.Grants, err = s.KeyGrants(keyID)
+	if err != nil {
+		return e, fmt.Errorf("%s: %w", op, err)
+	}
+	for _, g := range e.Grants {
+		if MatchPermission(g.Permission, permission) {
+			e.MatchedBy = append(e.MatchedBy, g)
+		}
+	}
+
+	switch {
+	case revoked:
+		e.Reason = "the key is revoked"
+	case status == KeyPending:
+		e.Reason = "the key is waiting for an admin to approve its registration"
+	case status == KeyRejected:
+		e.Reason = "the registration of the key was rejected"
+	case status != KeyActive:
+		e.Reason = fmt.Sprintf("the key is %s, only active keys authenticate", status)
+	case expiresAt.Valid && !time.Now().Before(expiresAt.Time):
+		e.Reason = "the key expired at " + expiresAt.Time.UTC().Format(time.RFC3339)
+	case len(e.MatchedBy) == 0:
+		e.Reason = fmt.Sprintf("no direct grant or role of the key covers %q", permission)
+	default:
+		e.Allowed = true
+		e.Reason = "granted by " + describeGrant(e.MatchedBy[0])
+	}
+	return e, nil
+}
+
+func describeGrant(g Grant) string {
+	if g.Role == "" {
+		return fmt.Sprintf("direct grant %q", g.Permission)
+	}
+	return fmt.Sprintf("%q of role %q", g.Permission, g.Role)
+}
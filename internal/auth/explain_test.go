@@ -0,0 +1,29 @@
+package auth
+
+import "testing"
+
+func TestMatchPermission(t *testing.T) {
+	tests := []struct {
+		granted    string
+		permission string
+		want       bool
+	}{
+		{"task.read", "task.read", true},
+		{"task.read", "task.create", false},
+		{"*", "task.read", true},
+		{"*", "admin.keys.revoke", true},
+		{"task.*", "task.read", true},
+		{"task.*", "task.comment.create", true},
+		{"task.*", "task", false},
+		{"task.*", "tasks.read", false},
+		{"admin.keys.*", "admin.keys.revoke", true},
+		{"admin.keys.*", "admin.roles.create", false},
+		{"task.read", "task.read.all", false},
+		{"task", "task.read", false},
+	}
+	for _, tt := range tests {
+		if got := MatchPermission(tt.granted, tt.permission); got != tt.want {
+			t.Errorf("MatchPermission(%q, %q) = %v, want %v", tt.granted, tt.permission, got, tt.want)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package auth
 
 import (
-	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
@@ -12,15 +11,36 @@ func HashKey(plainKey string) string {
 	return hex.EncodeToString(sum[:])
 }
 
+// Fingerprint identifies a key in logs without revealing it: the first
+// 8 hex chars of its hash
+func Fingerprint(plainKey string) string {
+	return HashKey(plainKey)[:8]
+}
+
+// MinKeyLength is the length below which a configured key is considered weak
+const MinKeyLength = 32
+
+// KeyWeakness tells why a key chosen by an operator is easy to guess, or ""
+// if it looks fine. Generated keys are always fine.
+func KeyWeakness(key string) string {
+	if len(key) < MinKeyLength {
+		return fmt.Sprintf("shorter than %d characters", MinKeyLength)
+	}
+	distinct := map[rune]bool{}
+	for _, r := range key {
+		distinct[r] = true
+	}
+	if len(distinct) < 10 {
+		return "fewer than 10 distinct characters"
+	}
+	return ""
+}
+
+// GenerateAPIKey returns a new key in the todo_live_ format and its hash
 func GenerateAPIKey() (plainKey, hashedKey string, err error) {
-	bytes := make([]byte, 32)
-	_, err = rand.Read(bytes)
+	plainKey, err = newStructuredKey()
 	if err != nil {
-		return "", "", fmt.Errorf("failed to generate random bytes: %w", err)
+		return "", "", err
 	}
-
-	plainKey = hex.EncodeToString(bytes)
-	sum := sha256.Sum256([]byte(plainKey))
-	hashedKey = hex.EncodeToString(sum[:])
-	return plainKey, hashedKey, nil
-}
\ No newline at end of file
+	return plainKey, HashKey(plainKey), nil
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strings"
+)
+
+// Generated keys look like todo_live_<id>_<secret>_<crc>. The scheme lets
+// secret scanners recognise leaked keys, the id is random and public so logs
+// can name a key by it, and the crc (CRC32 of everything before it) rejects
+// mistyped or truncated keys without a database lookup.
+const KeyScheme = "todo_live_"
+
+const (
+	keyIDLength     = 8
+	keySecretLength = 40 // ~238 bits
+	keyCRCLength    = 6
+)
+
+// ErrMalformedKey is returned for keys with the scheme whose format or
+// checksum is wrong
+var ErrMalformedKey = errors.New("malformed api key")
+
+const base62 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// newStructuredKey returns a new random key in the todo_live_ format
+func newStructuredKey() (string, error) {
+	id, err := randomBase62(keyIDLength)
+	if err != nil {
+		return "", err
+	}
+	secret, err := randomBase62(keySecretLength)
+	if err != nil {
+		return "", err
+	}
+	body := KeyScheme + id + "_" + secret
+	return body + "_" + keyChecksum(body), nil
+}
+
+// CheckKeyFormat returns ErrMalformedKey if key has the todo_live_ scheme but
+// a wrong format or checksum. Keys without the scheme (issued before it, or
+// seeded from the config) are left to the database.
+func CheckKeyFormat(key string) error {
+	if !strings.HasPrefix(key, KeyScheme) {
+		return nil
+	}
+	parts := strings.Split(strings.TrimPrefix(key, KeyScheme), "_")
+	if len(parts) != 3 ||
+		len(parts[0]) != keyIDLength || len(parts[1]) != keySecretLength || len(parts[2]) != keyCRCLength ||
+		!isBase62(parts[0]) || !isBase62(parts[1]) {
+		return ErrMalformedKey
+	}
+	body := KeyScheme + parts[0] + "_" + parts[1]
+	if parts[2] != keyChecksum(body) {
+		return fmt.Errorf("%w: checksum mismatch", ErrMalformedKey)
+	}
+	return nil
+}
+
+// KeyPrefix identifies a key in logs and listings without revealing it:
+// todo_live_<id> for structured keys, the Fingerprint for any other key
+func KeyPrefix(key string) string {
+	if strings.HasPrefix(key, KeyScheme) && CheckKeyFormat(key) == nil {
+		return key[:len(KeyScheme)+keyIDLength]
+	}
+	return Fingerprint(key)
+}
+
+// keyChecksum is the CRC32 of body as 6 base62 digits
+func keyChecksum(body string) string {
+	sum := crc32.ChecksumIEEE([]byte(body))
+	var b [keyCRCLength]byte
+	for i := keyCRCLength - 1; i >= 0; i-- {
+		b[i] = base62[sum%62]
+		sum /= 62
+	}
+	return string(b[:])
+}
+
+// randomBase62 returns n uniformly random base62 characters
+func randomBase62(n int) (string, error) {
+	out := make([]byte, 0, n)
+	buf := make([]byte, n)
+	for len(out) < n {
+		if _, err := rand.Read(buf); err != nil {
+			return "", fmt.Errorf("failed to generate random bytes: %w", err)
+		}
+		for _, b := range buf {
+			// 248 is the largest multiple of 62 below 256, larger bytes would bias the result
+			if b < 248 && len(out) < n {
+				out = append(out, base62[b%62])
+			}
+		}
+	}
+	return string(out), nil
+}
+
+func isBase62(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(base62, s[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
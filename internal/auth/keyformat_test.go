@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestStructuredKeyFormat(t *testing.T) {
+	key, err := newStructuredKey()
+	if err != nil {
+		t.Fatalf("newStructuredKey: %v", err)
+	}
+	if want := len(KeyScheme) + keyIDLength + 1 + keySecretLength + 1 + keyCRCLength; len(key) != want {
+		t.Errorf("len(%q) = %d, want %d", key, len(key), want)
+	}
+	if err := CheckKeyFormat(key); err != nil {
+		t.Errorf("CheckKeyFormat(%q) = %v", key, err)
+	}
+	if prefix := KeyPrefix(key); prefix != key[:len(KeyScheme)+keyIDLength] {
+		t.Errorf("KeyPrefix(%q) = %q", key, prefix)
+	}
+
+	other, err := newStructuredKey()
+	if err != nil {
+		t.Fatalf("newStructuredKey: %v", err)
+	}
+	if other == key {
+		t.Error("newStructuredKey returned the same key twice")
+	}
+}
+
+func TestCheckKeyFormat(t *testing.T) {
+	key, err := newStructuredKey()
+	if err != nil {
+		t.Fatalf("newStructuredKey: %v", err)
+	}
+	body, crc := key[:len(key)-keyCRCLength-1], key[len(key)-keyCRCLength:]
+
+	// a typo in the secret keeps the length but breaks the checksum
+	typo := []byte(key)
+	i := len(KeyScheme) + keyIDLength + 1
+	if typo[i] == 'a' {
+		typo[i] = 'b'
+	} else {
+		typo[i] = 'a'
+	}
+
+	tests := []struct {
+		name      string
+		key       string
+		malformed bool
+	}{
+		{"generated", key, false},
+		{"legacy hex key", strings.Repeat("ab", 32), false},
+		{"seeded from config", "admin-secret", false},
+		{"typo", string(typo), true},
+		{"truncated", key[:len(key)-1], true},
+		{"missing checksum", body, true},
+		{"extra part", body + "_x_" + crc, true},
+		{"not base62", KeyScheme + "abcd-fgh" + key[len(KeyScheme)+keyIDLength:], true},
+		{"scheme only", KeyScheme, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckKeyFormat(tt.key)
+			if got := errors.Is(err, ErrMalformedKey); got != tt.malformed {
+				t.Errorf("CheckKeyFormat(%q) = %v, want malformed %v", tt.key, err, tt.malformed)
+			}
+		})
+	}
+}
+
+func TestKeyPrefixOfOtherKeys(t *testing.T) {
+	for _, key := range []string{"admin-secret", KeyScheme + "broken"} {
+		if got, want := KeyPrefix(key), Fingerprint(key); got != want {
+			t.Errorf("KeyPrefix(%q) = %q, want the fingerprint %q", key, got, want)
+		}
+	}
+}
+
+func TestKeyChecksum(t *testing.T) {
+	sum := keyChecksum("todo_live_abc")
+	if len(sum) != keyCRCLength || !isBase62(sum) {
+		t.Errorf("keyChecksum = %q, want %d base62 digits", sum, keyCRCLength)
+	}
+	if keyChecksum("todo_live_abc") != sum {
+		t.Error("keyChecksum isn't deterministic")
+	}
+	if keyChecksum("todo_live_abd") == sum {
+		t.Error("keyChecksum ignores the last character")
+	}
+}
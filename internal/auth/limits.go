@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidLimits = errors.New("limits must not be negative")
+
+// Limits overrides the rate limit and the daily task quota of a key or a role.
+// A nil field is not set and falls back from the key to its roles and then to
+// the config, 0 means unlimited.
+type Limits struct {
+	RequestsPerMinute *int `json:"requests_per_minute"`
+	Burst             *int `json:"burst"`
+	DailyTasks        *int `json:"daily_tasks"`
+}
+
+func (l Limits) validate() error {
+	for _, v := range []*int{l.RequestsPerMinute, l.Burst, l.DailyTasks} {
+		if v != nil && *v < 0 {
+			return ErrInvalidLimits
+		}
+	}
+	return nil
+}
+
+// KeyLimits returns the limits set on the key keyID itself
+func (s *Storage) KeyLimits(ctx context.Context, keyID int64) (Limits, error) {
+	const op = "auth.storage.KeyLimits"
+
+	var rpm, burst, daily sql.NullInt64
+	err := s.db.QueryRowContext(ctx, s.q("SELECT requests_per_minute, burst, daily_tasks FROM api_keys WHERE id = ?"), keyID).
+		Scan(&rpm, &burst, &daily)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Limits{}, fmt.Errorf("%s: id=%d: %w", op, keyID, ErrKeyNotFound)
+	}
+	if err != nil {
+		return Limits{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return Limits{RequestsPerMinute: nullInt(rpm), Burst: nullInt(burst), DailyTasks: nullInt(daily)}, nil
+}
+
+// EffectiveLimits returns the limits of the key keyID with the fields it doesn't
+// set taken from its roles, where the most generous role wins
+func (s *Storage) EffectiveLimits(ctx context.Context, keyID int64) (Limits, error) {
+	const op = "auth.storage.EffectiveLimits"
+
+	limits, err := s.KeyLimits(ctx, keyID)
+	if err != nil {
+		return Limits{}, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.q(`
+	SELECT r.requests_per_minute, r.burst, r.daily_tasks
+	FROM api_key_roles kr
+	JOIN roles r ON r.id = kr.role_id
+	WHERE kr.api_key_id = ?`), keyID)
+	if err != nil {
+		return Limits{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var roles Limits
+	for rows.Next() {
+		var rpm, burst, daily sql.NullInt64
+		if err := rows.Scan(&rpm, &burst, &daily); err != nil {
+			return Limits{}, fmt.Errorf("%s: %w", op, err)
+		}
+		roles.RequestsPerMinute = moreGenerous(roles.RequestsPerMinute, nullInt(rpm))
+		roles.Burst = moreGenerous(roles.Burst, nullInt(burst))
+		roles.DailyTasks = moreGenerous(roles.DailyTasks, nullInt(daily))
+	}
+	if err := rows.Err(); err != nil {
+		return Limits{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if limits.RequestsPerMinute == nil {
+		limits.RequestsPerMinute = roles.RequestsPerMinute
+	}
+	if limits.Burst == nil {
+		limits.Burst = roles.Burst
+	}
+	if limits.DailyTasks == nil {
+		limits.DailyTasks = roles.DailyTasks
+	}
+	return limits, nil
+}
+
+// SetKeyLimits replaces the limits of the key keyID, nil fields are cleared
+func (s *Storage) SetKeyLimits(keyID int64, limits Limits) error {
+	const op = "auth.storage.SetKeyLimits"
+
+	if err := limits.validate(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	res, err := s.db.Exec(s.q("UPDATE api_keys SET requests_per_minute = ?, burst = ?, daily_tasks = ? WHERE id = ?"),
+		limits.RequestsPerMinute, limits.Burst, limits.DailyTasks, keyID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return checkKeyUpdated(op, res, keyID)
+}
+
+// SetRoleLimits replaces the limits of the role name, nil fields are cleared
+func (s *Storage) SetRoleLimits(name string, limits Limits) error {
+	const op = "auth.storage.SetRoleLimits"
+
+	if err := limits.validate(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	res, err := s.db.Exec(s.q("UPDATE roles SET requests_per_minute = ?, burst = ?, daily_tasks = ? WHERE name = ?"),
+		limits.RequestsPerMinute, limits.Burst, limits.DailyTasks, name)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %s: %w", op, name, ErrRoleNotFound)
+	}
+	return nil
+}
+
+// moreGenerous picks the higher limit, 0 is unlimited and beats every other value
+func moreGenerous(a, b *int) *int {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	case *a == 0 || *b == 0:
+		zero := 0
+		return &zero
+	case *b > *a:
+		return b
+	}
+	return a
+}
+
+func nullInt(v sql.NullInt64) *int {
+	if !v.Valid {
+		return nil
+	}
+	n := int(v.Int64)
+	return &n
+}
+
+// TakeDailyTask counts one more task created by the key keyID on day (UTC,
+// YYYY-MM-DD) unless it already created quota of them, ok tells which. The
+// check and the increment are one statement so concurrent requests can't
+// both take the last slot.
+func (s *Storage) TakeDailyTask(ctx context.Context, keyID int64, day string, quota int) (ok bool, err error) {
+	const op = "auth.storage.TakeDailyTask"
+
+	var created int
+	err = s.db.QueryRowContext(ctx, s.q(`
+	INSERT INTO task_quota_usage(key_id, day, created) VALUES (?, ?, 1)
+	ON CONFLICT (key_id, day) DO UPDATE SET created = task_quota_usage.created + 1
+	WHERE task_quota_usage.created < ?
+	RETURNING created`), keyID, day, quota).Scan(&created)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	return true, nil
+}
+
+// ReturnDailyTask gives back the slot taken by TakeDailyTask for a task that
+// wasn't created after all
+func (s *Storage) ReturnDailyTask(ctx context.Context, keyID int64, day string) error {
+	const op = "auth.storage.ReturnDailyTask"
+
+	_, err := s.db.ExecContext(ctx, s.q(`
+	UPDATE task_quota_usage SET created = created - 1
+	WHERE key_id = ? AND day = ? AND created > 0`), keyID, day)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
@@ -1,43 +1,125 @@
 package auth
 
 import (
-	"net/http"
-	"strings"
+	"errors"
+	"fmt"
 	"log/slog"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+
+	sl "rest_api/internal/lib/logger/slog"
+	"rest_api/internal/metrics"
+)
+
+// Errors the middlewares report with c.Error, handler.ErrorHandler renders them
+var (
+	ErrMissingCredentials = errors.New("missing api key")
+	ErrInvalidKey         = errors.New("invalid api key")
+	ErrForbidden          = errors.New("permission denied")
+	ErrGraceSecret        = errors.New("the old secret of a rotated key can't rotate it")
+)
+
+const (
+	ctxKeyID = "api_key_id"
+	ctxAdmin = "api_key_admin"
+	ctxGrace = "api_key_grace"
 )
 
+// KeyID returns the ID of the API key authenticated by AuthMiddleware
+func KeyID(c *gin.Context) (int64, bool) {
+	id, ok := c.Get(ctxKeyID)
+	if !ok {
+		return 0, false
+	}
+	return id.(int64), true
+}
+
+// IsAdmin reports whether the authenticated API key has the admin permission
+func IsAdmin(c *gin.Context) bool {
+	return c.GetBool(ctxAdmin)
+}
+
+// credentials returns the API key sent with the request as
+// Authorization: Bearer <key>, X-API-Key: <key> or the legacy
+// Authorization: ApiKey.<key>
+func credentials(c *gin.Context) (string, bool) {
+	header := c.GetHeader("Authorization")
+	var key string
+	switch {
+	case len(header) > len("Bearer ") && strings.EqualFold(header[:len("Bearer ")], "Bearer "):
+		key = header[len("Bearer "):]
+	case strings.HasPrefix(header, "ApiKey."):
+		key = strings.TrimPrefix(header, "ApiKey.")
+	default:
+		key = c.GetHeader("X-API-Key")
+	}
+	key = strings.TrimSpace(key)
+	return key, key != ""
+}
+
+// UsedGraceSecret reports whether the request was authenticated with the old
+// secret of a rotated key, which only works until its grace period ends
+func UsedGraceSecret(c *gin.Context) bool {
+	return c.GetBool(ctxGrace)
+}
 
 // AuthMiddleware - checks the API key
 func AuthMiddleware(storage *Storage, log *slog.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		token := c.GetHeader("Authorization")
-		if !strings.HasPrefix(token, "ApiKey.") {
-			log.Warn("missing or invalid Authorization header")
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "missing or invalid Authorization header"})
+		log := sl.FromContext(c.Request.Context(), log)
+		apiKey, ok := credentials(c)
+		if !ok {
+			log.Warn("missing api key")
+			metrics.AuthFailure(metrics.ReasonMissingHeader)
+			c.Error(ErrMissingCredentials)
+			c.Abort()
+			return
+		}
+
+		// mistyped or truncated keys are rejected without a database lookup
+		if err := CheckKeyFormat(apiKey); err != nil {
+			log.Warn("malformed api key", slog.String("err", err.Error()))
+			metrics.AuthFailure(metrics.ReasonMalformedKey)
+			c.Error(ErrInvalidKey)
 			c.Abort()
 			return
 		}
 
-		apiKey := strings.TrimPrefix(token, "ApiKey.")
-		ok, err := storage.ValidateKey(apiKey)
+		key, err := storage.LookupKey(c.Request.Context(), apiKey)
 		if err != nil {
 			log.Error("failed to validate api key", slog.Any("err", err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+			metrics.AuthFailure(metrics.ReasonError)
+			c.Error(err)
 			c.Abort()
 			return
 		}
 
-		if !ok {
-			log.Warn("invalid api key")
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid api key"})
+		if key == nil {
+			log.Warn("invalid api key", slog.String("key_prefix", KeyPrefix(apiKey)))
+			metrics.AuthFailure(metrics.ReasonInvalidKey)
+			c.Error(ErrInvalidKey)
 			c.Abort()
 			return
 		}
 
-		c.Set("api_key", apiKey) // save for handlers
-		log.Debug("api key validate successfully", slog.String("key", apiKey))
+		// admin keys work on tasks of every owner
+		admin, err := storage.HasPermission(c.Request.Context(), key.ID, "admin")
+		if err != nil {
+			log.Error("failed to check permission", slog.String("permission", "admin"), slog.Any("err", err))
+			c.Error(err)
+			c.Abort()
+			return
+		}
+
+		c.Set(ctxKeyID, key.ID)
+		c.Set(ctxAdmin, admin)
+		c.Set(ctxGrace, key.Grace)
+
+		// everything logged for this request from here on carries the key id
+		log = log.With(slog.Int64("key_id", key.ID))
+		c.Request = c.Request.WithContext(sl.NewContext(c.Request.Context(), log))
+		log.Debug("api key validate successfully", slog.String("key_prefix", KeyPrefix(apiKey)))
 		c.Next()
 	}
 }
@@ -45,25 +127,27 @@ func AuthMiddleware(storage *Storage, log *slog.Logger) gin.HandlerFunc {
 // RequirePermission - middleware for checking a specific permissions
 func RequirePermission(storage *Storage, permission string, log *slog.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		apiKey, exists := c.Get("api_key")
-		if !exists {
-			log.Warn("API key not found in context")
-			c.JSON(http.StatusForbidden, gin.H{"error": "API key not found in context"})
+		log := sl.FromContext(c.Request.Context(), log)
+		keyID, ok := KeyID(c)
+		if !ok {
+			log.Error("API key not found in context")
+			c.Error(fmt.Errorf("auth.RequirePermission: %s requires AuthMiddleware", permission))
 			c.Abort()
 			return
 		}
 
-		ok, err := storage.HasPermission(apiKey.(string), permission)
+		ok, err := storage.HasPermission(c.Request.Context(), keyID, permission)
 		if err != nil {
 			log.Error("failed to check permission", slog.String("permission", permission), slog.Any("err", err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+			c.Error(err)
 			c.Abort()
 			return
 		}
 
 		if !ok {
-			log.Warn("permission denied", slog.String("permission", permission), slog.String("api_key", apiKey.(string)))
-			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			metrics.PermissionDenied(permission)
+			log.Warn("permission denied", slog.String("permission", permission))
+			c.Error(ErrForbidden)
 			c.Abort()
 			return
 		}
@@ -71,4 +155,4 @@ func RequirePermission(storage *Storage, permission string, log *slog.Logger) gi
 		log.Debug("permission granted", slog.String("permission", permission))
 		c.Next()
 	}
-}
\ No newline at end of file
+}
@@ -1,13 +1,19 @@
 package auth
 
+import "time"
+
 type APIKey struct {
-	ID		int64
-	Key 	string // hashed key
-	Owner 	string
-	Revoked	bool
+	ID        int64      `json:"id"`
+	Key       string     `json:"-"`      // hashed key
+	Prefix    string     `json:"prefix"` // todo_live_<id> of the key, the hash fingerprint for older keys
+	Owner     string     `json:"owner"`
+	Revoked   bool       `json:"revoked"`
+	ExpiresAt *time.Time `json:"expires_at"` // nil means the key never expires
+	Status    string     `json:"status"`     // active, pending or rejected
+	Grace     bool       `json:"-"`          // authenticated with the old secret of a rotated key, set by LookupKey
 }
 
 type Permission struct {
-	ID int64
-	Name string
-}
\ No newline at end of file
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
@@ -0,0 +1,237 @@
+package auth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	storage "rest_api/internal/db"
+)
+
+var (
+	ErrOwnerReserved  = errors.New("owner name is reserved")
+	ErrInviteRequired = errors.New("an invite code is required")
+	ErrInvalidInvite  = errors.New("invite code is invalid, used or expired")
+	ErrInviteNotFound = errors.New("invite not found")
+	ErrNotPending     = errors.New("registration is not pending")
+)
+
+// Key statuses, only active keys authenticate
+const (
+	KeyActive   = "active"
+	KeyPending  = "pending"
+	KeyRejected = "rejected"
+)
+
+// AdminOwner is the owner of the bootstrap admin key, it can't be registered
+const AdminOwner = "admin"
+
+type Invite struct {
+	ID             int64      `json:"id"`
+	CreatedByKeyID *int64     `json:"created_by_key_id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	UsedAt         *time.Time `json:"used_at"`
+	UsedByKeyID    *int64     `json:"used_by_key_id"`
+}
+
+// CreateInvite issues a single-use invite code, only its hash is stored.
+// expiresAt nil makes a code that never expires.
+func (s *Storage) CreateInvite(createdBy int64, expiresAt *time.Time) (string, Invite, error) {
+	const op = "auth.storage.CreateInvite"
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", Invite{}, fmt.Errorf("%s: %w", op, err)
+	}
+	code := hex.EncodeToString(b)
+
+	inv := Invite{CreatedByKeyID: &createdBy, CreatedAt: storage.Now()}
+	var expires sql.NullTime
+	if expiresAt != nil {
+		t := storage.NormalizeTime(*expiresAt)
+		inv.ExpiresAt = &t
+		expires = sql.NullTime{Time: t, Valid: true}
+	}
+	err := s.db.QueryRow(s.q(`
+	INSERT INTO invites(code_hash, created_by_key_id, created_at, expires_at)
+	VALUES (?, ?, ?, ?) RETURNING id`), HashKey(code), createdBy, inv.CreatedAt, expires).Scan(&inv.ID)
+	if err != nil {
+		return "", Invite{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return code, inv, nil
+}
+
+// ListInvites returns every invite, newest first
+func (s *Storage) ListInvites() ([]Invite, error) {
+	const op = "auth.storage.ListInvites"
+
+	rows, err := s.db.Query(`
+	SELECT id, created_by_key_id, created_at, expires_at, used_at, used_by_key_id
+	FROM invites ORDER BY id DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	invites := []Invite{}
+	for rows.Next() {
+		var inv Invite
+		var createdBy, usedBy sql.NullInt64
+		var expiresAt, usedAt sql.NullTime
+		if err := rows.Scan(&inv.ID, &createdBy, &inv.CreatedAt, &expiresAt, &usedAt, &usedBy); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		inv.CreatedAt = inv.CreatedAt.UTC()
+		inv.CreatedByKeyID = nullInt64(createdBy)
+		inv.UsedByKeyID = nullInt64(usedBy)
+		inv.ExpiresAt = nullTime(expiresAt)
+		inv.UsedAt = nullTime(usedAt)
+		invites = append(invites, inv)
+	}
+	return invites, rows.Err()
+}
+
+// DeleteInvite withdraws an unused invite
+func (s *Storage) DeleteInvite(id int64) error {
+	const op = "auth.storage.DeleteInvite"
+
+	res, err := s.db.Exec(s.q("DELETE FROM invites WHERE id = ? AND used_at IS NULL"), id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: id=%d: %w", op, id, ErrInviteNotFound)
+	}
+	return nil
+}
+
+// AddRegisteredKey stores a new key in status, uses up the invite code
+// unless it's empty and gives the key the role unless it's empty, all in one
+// transaction. It returns ErrInvalidInvite for unknown, used or expired codes
+// and ErrRoleNotFound for a missing role, no key is stored then.
+func (s *Storage) AddRegisteredKey(hashedKey, prefix, owner, status, code, role string) (int64, error) {
+	const op = "auth.storage.AddRegisteredKey"
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var keyID int64
+	err = tx.QueryRow(s.q("INSERT INTO api_keys(key_hash, key_prefix, owner, status) VALUES (?, ?, ?, ?) RETURNING id"),
+		hashedKey, prefix, owner, status).Scan(&keyID)
+	if err != nil {
+		return 0, fmt.Errorf("%s: insert key: %w", op, err)
+	}
+
+	if code != "" {
+		now := storage.Now()
+		res, err := tx.Exec(s.q(`
+		UPDATE invites SET used_at = ?, used_by_key_id = ?
+		WHERE code_hash = ? AND used_at IS NULL AND (expires_at IS NULL OR expires_at > ?)`),
+			now, keyID, HashKey(code), now)
+		if err != nil {
+			return 0, fmt.Errorf("%s: use invite: %w", op, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+		if n == 0 {
+			return 0, fmt.Errorf("%s: %w", op, ErrInvalidInvite)
+		}
+	}
+
+	if role != "" {
+		var roleID int64
+		err := tx.QueryRow(s.q("SELECT id FROM roles WHERE name = ?"), role).Scan(&roleID)
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("%s: %s: %w", op, role, ErrRoleNotFound)
+		} else if err != nil {
+			return 0, fmt.Errorf("%s: lookup role: %w", op, err)
+		}
+		_, err = tx.Exec(s.q("INSERT INTO api_key_roles(api_key_id, role_id) VALUES (?, ?)"), keyID, roleID)
+		if err != nil {
+			return 0, fmt.Errorf("%s: assign role: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return keyID, nil
+}
+
+// PendingKeys returns the keys waiting for approval, oldest first
+func (s *Storage) PendingKeys() ([]APIKey, error) {
+	const op = "auth.storage.PendingKeys"
+
+	rows, err := s.db.Query(s.q("SELECT id, key_prefix, owner, status FROM api_keys WHERE status = ? ORDER BY id"), KeyPending)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		if err := rows.Scan(&k.ID, &k.Prefix, &k.Owner, &k.Status); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// ApproveKey activates a pending key
+func (s *Storage) ApproveKey(keyID int64) error {
+	return s.decidePending("auth.storage.ApproveKey", keyID, KeyActive)
+}
+
+// RejectKey rejects a pending key, it never authenticates
+func (s *Storage) RejectKey(keyID int64) error {
+	return s.decidePending("auth.storage.RejectKey", keyID, KeyRejected)
+}
+
+// decidePending moves a pending key to status, ErrNotPending if it isn't pending
+func (s *Storage) decidePending(op string, keyID int64, status string) error {
+	res, err := s.db.Exec(s.q("UPDATE api_keys SET status = ? WHERE id = ? AND status = ?"), status, keyID, KeyPending)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		if err := s.CheckKeyExists(keyID); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		return fmt.Errorf("%s: id=%d: %w", op, keyID, ErrNotPending)
+	}
+	return nil
+}
+
+func nullInt64(v sql.NullInt64) *int64 {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Int64
+}
+
+func nullTime(v sql.NullTime) *time.Time {
+	if !v.Valid {
+		return nil
+	}
+	t := v.Time.UTC()
+	return &t
+}
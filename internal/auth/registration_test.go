@@ -0,0 +1,161 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"rest_api/internal/auth"
+	"rest_api/internal/config"
+)
+
+func TestRegisterOpen(t *testing.T) {
+	s := newStorage(t)
+	svc := auth.NewService(s, "viewer", config.Registration{Mode: config.RegistrationOpen, ReservedOwners: []string{"root"}})
+
+	plain, status, err := svc.RegisterAPIKey("alice", "")
+	if err != nil {
+		t.Fatalf("RegisterAPIKey: %v", err)
+	}
+	if status != auth.KeyActive {
+		t.Errorf("status = %s, want active", status)
+	}
+	key, err := s.LookupKey(context.Background(), plain)
+	if err != nil || key == nil {
+		t.Fatalf("LookupKey of the registered key = %+v, %v", key, err)
+	}
+	if roles, err := s.KeyRoles(key.ID); err != nil || len(roles) != 1 || roles[0] != "viewer" {
+		t.Errorf("roles = %v, %v, want viewer", roles, err)
+	}
+
+	// admin is always reserved, the comparison ignores case and spaces
+	for _, owner := range []string{"admin", "Admin", " root "} {
+		if _, _, err := svc.RegisterAPIKey(owner, ""); !errors.Is(err, auth.ErrOwnerReserved) {
+			t.Errorf("RegisterAPIKey(%q): err = %v, want ErrOwnerReserved", owner, err)
+		}
+	}
+}
+
+func TestRegisterInvite(t *testing.T) {
+	s := newStorage(t)
+	_, adminID, err := s.CreateKey("admin", []string{"admin"})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	svc := auth.NewService(s, "", config.Registration{Mode: config.RegistrationInvite, InviteTTL: time.Hour})
+
+	if _, _, err := svc.RegisterAPIKey("alice", ""); !errors.Is(err, auth.ErrInviteRequired) {
+		t.Errorf("RegisterAPIKey without a code: err = %v, want ErrInviteRequired", err)
+	}
+
+	// ttl 0 takes the configured lifetime
+	code, inv, err := svc.CreateInvite(adminID, 0)
+	if err != nil {
+		t.Fatalf("CreateInvite: %v", err)
+	}
+	if inv.ExpiresAt == nil || time.Until(*inv.ExpiresAt) > time.Hour || time.Until(*inv.ExpiresAt) < 59*time.Minute {
+		t.Errorf("invite expires at %v, want in an hour", inv.ExpiresAt)
+	}
+	plain, status, err := svc.RegisterAPIKey("alice", code)
+	if err != nil {
+		t.Fatalf("RegisterAPIKey: %v", err)
+	}
+	if status != auth.KeyActive {
+		t.Errorf("status = %s, want active", status)
+	}
+	key, err := s.LookupKey(context.Background(), plain)
+	if err != nil || key == nil {
+		t.Fatalf("LookupKey of the registered key = %+v, %v", key, err)
+	}
+
+	invites, err := svc.ListInvites()
+	if err != nil {
+		t.Fatalf("ListInvites: %v", err)
+	}
+	if len(invites) != 1 || invites[0].UsedAt == nil || invites[0].UsedByKeyID == nil || *invites[0].UsedByKeyID != key.ID {
+		t.Errorf("invites = %+v, want the invite used by key %d", invites, key.ID)
+	}
+	if err := svc.DeleteInvite(inv.ID); !errors.Is(err, auth.ErrInviteNotFound) {
+		t.Errorf("DeleteInvite of a used invite: err = %v, want ErrInviteNotFound", err)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	expired, _, err := s.CreateInvite(adminID, &past)
+	if err != nil {
+		t.Fatalf("CreateInvite: %v", err)
+	}
+	withdrawn, withdrawnInv, err := svc.CreateInvite(adminID, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateInvite: %v", err)
+	}
+	if err := svc.DeleteInvite(withdrawnInv.ID); err != nil {
+		t.Fatalf("DeleteInvite: %v", err)
+	}
+	for name, code := range map[string]string{"used": code, "expired": expired, "withdrawn": withdrawn, "unknown": "0123456789abcdef"} {
+		if _, _, err := svc.RegisterAPIKey("bob", code); !errors.Is(err, auth.ErrInvalidInvite) {
+			t.Errorf("RegisterAPIKey with a %s code: err = %v, want ErrInvalidInvite", name, err)
+		}
+	}
+}
+
+func TestRegisterApproval(t *testing.T) {
+	s := newStorage(t)
+	svc := auth.NewService(s, "viewer", config.Registration{Mode: config.RegistrationApproval})
+	ctx := context.Background()
+
+	register := func(owner string) (string, int64) {
+		t.Helper()
+		plain, status, err := svc.RegisterAPIKey(owner, "")
+		if err != nil {
+			t.Fatalf("RegisterAPIKey: %v", err)
+		}
+		if status != auth.KeyPending {
+			t.Errorf("status = %s, want pending", status)
+		}
+		if key, err := s.LookupKey(ctx, plain); err != nil || key != nil {
+			t.Errorf("LookupKey of a pending key = %+v, %v, want rejected", key, err)
+		}
+		pending, err := svc.PendingRegistrations()
+		if err != nil {
+			t.Fatalf("PendingRegistrations: %v", err)
+		}
+		for _, k := range pending {
+			if k.Owner == owner {
+				return plain, k.ID
+			}
+		}
+		t.Fatalf("pending registrations = %+v, want %s", pending, owner)
+		return "", 0
+	}
+
+	alice, aliceID := register("alice")
+	bob, bobID := register("bob")
+
+	if err := svc.ApproveRegistration(aliceID); err != nil {
+		t.Fatalf("ApproveRegistration: %v", err)
+	}
+	if key, err := s.LookupKey(ctx, alice); err != nil || key == nil {
+		t.Errorf("LookupKey of an approved key = %+v, %v, want the key", key, err)
+	}
+	if err := svc.RejectRegistration(bobID); err != nil {
+		t.Fatalf("RejectRegistration: %v", err)
+	}
+	if key, err := s.LookupKey(ctx, bob); err != nil || key != nil {
+		t.Errorf("LookupKey of a rejected key = %+v, %v, want rejected", key, err)
+	}
+
+	if pending, err := svc.PendingRegistrations(); err != nil || len(pending) != 0 {
+		t.Errorf("PendingRegistrations = %+v, %v, want none", pending, err)
+	}
+	// a decision is final
+	if err := svc.ApproveRegistration(bobID); !errors.Is(err, auth.ErrNotPending) {
+		t.Errorf("ApproveRegistration of a rejected key: err = %v, want ErrNotPending", err)
+	}
+	if err := svc.RejectRegistration(aliceID); !errors.Is(err, auth.ErrNotPending) {
+		t.Errorf("RejectRegistration of an approved key: err = %v, want ErrNotPending", err)
+	}
+	if err := svc.ApproveRegistration(bobID + 100); !errors.Is(err, auth.ErrKeyNotFound) {
+		t.Errorf("ApproveRegistration of a missing key: err = %v, want ErrKeyNotFound", err)
+	}
+}
@@ -0,0 +1,363 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+)
+
+var (
+	ErrRoleNotFound    = errors.New("role not found")
+	ErrRoleExists      = errors.New("role already exists")
+	ErrRoleProtected   = errors.New("role is protected")
+	ErrRoleNotAssigned = errors.New("role not assigned to the key")
+)
+
+// AdminRole is given to the bootstrap admin key and can't be deleted
+const AdminRole = "admin"
+
+type Role struct {
+	ID          int64    `json:"id"`
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+	Limits      Limits   `json:"limits"`
+}
+
+// EffectivePermissions returns the names of the permissions the key keyID
+// holds directly or through its roles, sorted and without duplicates
+func (s *Storage) EffectivePermissions(ctx context.Context, keyID int64) ([]string, error) {
+	const op = "auth.storage.EffectivePermissions"
+
+	rows, err := s.db.QueryContext(ctx, s.q(`
+	SELECT p.name
+	FROM api_key_permissions kp
+	JOIN permissions p ON p.id = kp.permission_id
+	WHERE kp.api_key_id = ?
+	UNION
+	SELECT p.name
+	FROM api_key_roles kr
+	JOIN role_permissions rp ON rp.role_id = kr.role_id
+	JOIN permissions p ON p.id = rp.permission_id
+	WHERE kr.api_key_id = ?
+	ORDER BY 1`), keyID, keyID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	perms := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		perms = append(perms, name)
+	}
+	return perms, rows.Err()
+}
+
+// SyncRoles creates the roles of the policy (role name -> permission names)
+// that don't exist yet, with their permissions. Existing roles are left as
+// they are, so changes made through /admin/roles survive a restart. A deleted
+// role is created again unless it's removed from the policy as well.
+func (s *Storage) SyncRoles(roles map[string][]string) error {
+	const op = "auth.storage.SyncRoles"
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	for name, perms := range roles {
+		var roleID int64
+		err := tx.QueryRow(s.q("INSERT INTO roles(name) VALUES (?) ON CONFLICT DO NOTHING RETURNING id"), name).Scan(&roleID)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: create role %s: %w", op, name, err)
+		}
+
+		for _, perm := range perms {
+			if !ValidPermissionName(perm) {
+				return fmt.Errorf("%s: role %s: %q: %w", op, name, perm, ErrInvalidPermission)
+			}
+			if _, err := tx.Exec(s.q("INSERT INTO permissions(name) VALUES (?) ON CONFLICT DO NOTHING"), perm); err != nil {
+				return fmt.Errorf("%s: create permission %s: %w", op, perm, err)
+			}
+			_, err := tx.Exec(s.q(`
+			INSERT INTO role_permissions(role_id, permission_id)
+			SELECT ?, id FROM permissions WHERE name = ?
+			ON CONFLICT DO NOTHING`), roleID, perm)
+			if err != nil {
+				return fmt.Errorf("%s: role %s: grant %s: %w", op, name, perm, err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// ListRoles returns all roles with their permissions ordered by name
+func (s *Storage) ListRoles() ([]Role, error) {
+	const op = "auth.storage.ListRoles"
+
+	rows, err := s.db.Query(`
+	SELECT r.id, r.name, r.requests_per_minute, r.burst, r.daily_tasks, p.name
+	FROM roles r
+	LEFT JOIN role_permissions rp ON rp.role_id = r.id
+	LEFT JOIN permissions p ON p.id = rp.permission_id
+	ORDER BY r.name, p.name`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	roles := []Role{}
+	for rows.Next() {
+		var id int64
+		var name string
+		var rpm, burst, daily sql.NullInt64
+		var perm sql.NullString
+		if err := rows.Scan(&id, &name, &rpm, &burst, &daily, &perm); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if len(roles) == 0 || roles[len(roles)-1].ID != id {
+			roles = append(roles, Role{ID: id, Name: name, Permissions: []string{},
+				Limits: Limits{RequestsPerMinute: nullInt(rpm), Burst: nullInt(burst), DailyTasks: nullInt(daily)}})
+		}
+		if perm.Valid {
+			last := &roles[len(roles)-1]
+			last.Permissions = append(last.Permissions, perm.String)
+		}
+	}
+	return roles, rows.Err()
+}
+
+// CreateRole creates the role name bundling the existing permissions perms
+func (s *Storage) CreateRole(name string, perms []string) (Role, error) {
+	const op = "auth.storage.CreateRole"
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Role{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow(s.q("SELECT EXISTS(SELECT 1 FROM roles WHERE name = ?)"), name).Scan(&exists); err != nil {
+		return Role{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if exists {
+		return Role{}, fmt.Errorf("%s: %s: %w", op, name, ErrRoleExists)
+	}
+
+	role := Role{Name: name, Permissions: []string{}}
+	if err := tx.QueryRow(s.q("INSERT INTO roles(name) VALUES (?) RETURNING id"), name).Scan(&role.ID); err != nil {
+		return Role{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, perm := range perms {
+		var permID int64
+		err := tx.QueryRow(s.q("SELECT id FROM permissions WHERE name = ?"), perm).Scan(&permID)
+		if err == sql.ErrNoRows {
+			return Role{}, fmt.Errorf("%s: %s: %w", op, perm, ErrPermissionNotFound)
+		} else if err != nil {
+			return Role{}, fmt.Errorf("%s: %w", op, err)
+		}
+		_, err = tx.Exec(s.q("INSERT INTO role_permissions(role_id, permission_id) VALUES (?, ?) ON CONFLICT DO NOTHING"), role.ID, permID)
+		if err != nil {
+			return Role{}, fmt.Errorf("%s: %w", op, err)
+		}
+		if !slices.Contains(role.Permissions, perm) {
+			role.Permissions = append(role.Permissions, perm)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Role{}, fmt.Errorf("%s: %w", op, err)
+	}
+	sort.Strings(role.Permissions)
+	return role, nil
+}
+
+// DeleteRole deletes the role name and unassigns it from every key.
+// It returns the number of keys it was unassigned from.
+func (s *Storage) DeleteRole(name string) (int64, error) {
+	const op = "auth.storage.DeleteRole"
+
+	if name == AdminRole {
+		return 0, fmt.Errorf("%s: %s: %w", op, name, ErrRoleProtected)
+	}
+	roleID, err := s.roleID(name)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(s.q("DELETE FROM api_key_roles WHERE role_id = ?"), roleID)
+	if err != nil {
+		return 0, fmt.Errorf("%s: unassign from keys: %w", op, err)
+	}
+	unassigned, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	if _, err := tx.Exec(s.q("DELETE FROM role_permissions WHERE role_id = ?"), roleID); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	if _, err := tx.Exec(s.q("DELETE FROM roles WHERE id = ?"), roleID); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return unassigned, nil
+}
+
+// GrantRolePermission adds the permission perm to the role name
+func (s *Storage) GrantRolePermission(name, perm string) error {
+	const op = "auth.storage.GrantRolePermission"
+
+	roleID, err := s.roleID(name)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	permID, err := s.permissionID(perm)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = s.db.Exec(s.q("INSERT INTO role_permissions(role_id, permission_id) VALUES (?, ?) ON CONFLICT DO NOTHING"), roleID, permID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// RevokeRolePermission removes the permission perm from the role name
+func (s *Storage) RevokeRolePermission(name, perm string) error {
+	const op = "auth.storage.RevokeRolePermission"
+
+	roleID, err := s.roleID(name)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	permID, err := s.permissionID(perm)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := s.db.Exec(s.q("DELETE FROM role_permissions WHERE role_id = ? AND permission_id = ?"), roleID, permID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %s: %w", op, perm, ErrPermissionNotGranted)
+	}
+	return nil
+}
+
+// KeyRoles returns the names of the roles assigned to the key keyID
+func (s *Storage) KeyRoles(keyID int64) ([]string, error) {
+	const op = "auth.storage.KeyRoles"
+
+	if err := s.CheckKeyExists(keyID); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(s.q(`
+	SELECT r.name
+	FROM roles r
+	JOIN api_key_roles kr ON kr.role_id = r.id
+	WHERE kr.api_key_id = ?
+	ORDER BY r.name`), keyID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	roles := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		roles = append(roles, name)
+	}
+	return roles, rows.Err()
+}
+
+// AssignRole gives the role name to the key keyID
+func (s *Storage) AssignRole(keyID int64, name string) error {
+	const op = "auth.storage.AssignRole"
+
+	if err := s.CheckKeyExists(keyID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	roleID, err := s.roleID(name)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = s.db.Exec(s.q("INSERT INTO api_key_roles(api_key_id, role_id) VALUES (?, ?) ON CONFLICT DO NOTHING"), keyID, roleID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// UnassignRole takes the role name away from the key keyID
+func (s *Storage) UnassignRole(keyID int64, name string) error {
+	const op = "auth.storage.UnassignRole"
+
+	if err := s.CheckKeyExists(keyID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	roleID, err := s.roleID(name)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := s.db.Exec(s.q("DELETE FROM api_key_roles WHERE api_key_id = ? AND role_id = ?"), keyID, roleID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %s: %w", op, name, ErrRoleNotAssigned)
+	}
+	return nil
+}
+
+// roleID returns the id of the role name or ErrRoleNotFound
+func (s *Storage) roleID(name string) (int64, error) {
+	var id int64
+	err := s.db.QueryRow(s.q("SELECT id FROM roles WHERE name = ?"), name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("%s: %w", name, ErrRoleNotFound)
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to lookup role: %w", err)
+	}
+	return id, nil
+}
@@ -0,0 +1,199 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"rest_api/internal/auth"
+)
+
+// rolePermissions returns the permissions of every role by name
+func rolePermissions(t *testing.T, s *auth.Storage) map[string][]string {
+	t.Helper()
+
+	roles, err := s.ListRoles()
+	if err != nil {
+		t.Fatalf("list roles: %v", err)
+	}
+	perms := make(map[string][]string, len(roles))
+	for _, r := range roles {
+		perms[r.Name] = r.Permissions
+	}
+	return perms
+}
+
+func TestSyncRolesKeepsAdminEdits(t *testing.T) {
+	s := newStorage(t)
+	policy := map[string][]string{
+		"viewer": {"task.read"},
+		"editor": {"task.read", "task.update"},
+	}
+	if err := s.SyncRoles(policy); err != nil {
+		t.Fatalf("SyncRoles: %v", err)
+	}
+
+	// edits through /admin/roles
+	if err := s.GrantRolePermission("viewer", "task.create"); err != nil {
+		t.Fatalf("grant: %v", err)
+	}
+	if err := s.RevokeRolePermission("editor", "task.update"); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	rpm := 10
+	if err := s.SetRoleLimits("viewer", auth.Limits{RequestsPerMinute: &rpm}); err != nil {
+		t.Fatalf("set limits: %v", err)
+	}
+
+	// the next start syncs the same policy again, a new role is created
+	policy["maintainer"] = []string{"task.*"}
+	if err := s.SyncRoles(policy); err != nil {
+		t.Fatalf("SyncRoles after restart: %v", err)
+	}
+
+	got := rolePermissions(t, s)
+	want := map[string][]string{
+		"viewer":     {"task.create", "task.read"},
+		"editor":     {"task.read"},
+		"maintainer": {"task.*"},
+	}
+	for role, perms := range want {
+		if !slices.Equal(got[role], perms) {
+			t.Errorf("%s permissions = %v, want %v", role, got[role], perms)
+		}
+	}
+
+	roles, err := s.ListRoles()
+	if err != nil {
+		t.Fatalf("list roles: %v", err)
+	}
+	for _, r := range roles {
+		if r.Name == "viewer" && (r.Limits.RequestsPerMinute == nil || *r.Limits.RequestsPerMinute != rpm) {
+			t.Errorf("viewer limits = %+v, want requests_per_minute %d", r.Limits, rpm)
+		}
+	}
+}
+
+func TestRoles(t *testing.T) {
+	s := newStorage(t)
+	ctx := context.Background()
+
+	role, err := s.CreateRole("reporter", []string{"task.read", "task.create", "task.read"})
+	if err != nil {
+		t.Fatalf("CreateRole: %v", err)
+	}
+	if !slices.Equal(role.Permissions, []string{"task.create", "task.read"}) {
+		t.Errorf("role permissions = %v, want task.create and task.read", role.Permissions)
+	}
+	if _, err := s.CreateRole("reporter", nil); !errors.Is(err, auth.ErrRoleExists) {
+		t.Errorf("CreateRole again: err = %v, want ErrRoleExists", err)
+	}
+	if _, err := s.CreateRole("writer", []string{"report.write"}); !errors.Is(err, auth.ErrPermissionNotFound) {
+		t.Errorf("CreateRole with a missing permission: err = %v, want ErrPermissionNotFound", err)
+	}
+	if _, ok := rolePermissions(t, s)["writer"]; ok {
+		t.Error("a failed CreateRole left the role behind")
+	}
+
+	// the permissions of a key are its own grants plus those of its roles
+	_, keyID, err := s.CreateKey("alice", []string{"task.delete", "task.read"})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	if err := s.AssignRole(keyID, "reporter"); err != nil {
+		t.Fatalf("AssignRole: %v", err)
+	}
+	if err := s.AssignRole(keyID, "reporter"); err != nil {
+		t.Errorf("AssignRole twice: %v", err)
+	}
+	perms, err := s.EffectivePermissions(ctx, keyID)
+	if err != nil {
+		t.Fatalf("EffectivePermissions: %v", err)
+	}
+	if want := []string{"task.create", "task.delete", "task.read"}; !slices.Equal(perms, want) {
+		t.Errorf("EffectivePermissions = %v, want %v", perms, want)
+	}
+	if ok, err := s.HasPermission(ctx, keyID, "task.create"); err != nil || !ok {
+		t.Errorf("HasPermission through the role = %v, %v, want true", ok, err)
+	}
+
+	// role edits apply to the keys holding it
+	if err := s.RevokeRolePermission("reporter", "task.create"); err != nil {
+		t.Fatalf("RevokeRolePermission: %v", err)
+	}
+	if ok, err := s.HasPermission(ctx, keyID, "task.create"); err != nil || ok {
+		t.Errorf("HasPermission after the role lost it = %v, %v, want false", ok, err)
+	}
+	if err := s.RevokeRolePermission("reporter", "task.create"); !errors.Is(err, auth.ErrPermissionNotGranted) {
+		t.Errorf("RevokeRolePermission again: err = %v, want ErrPermissionNotGranted", err)
+	}
+	if err := s.GrantRolePermission("reporter", "task.update"); err != nil {
+		t.Fatalf("GrantRolePermission: %v", err)
+	}
+	if ok, err := s.HasPermission(ctx, keyID, "task.update"); err != nil || !ok {
+		t.Errorf("HasPermission after the role got it = %v, %v, want true", ok, err)
+	}
+
+	if err := s.UnassignRole(keyID, "reporter"); err != nil {
+		t.Fatalf("UnassignRole: %v", err)
+	}
+	if err := s.UnassignRole(keyID, "reporter"); !errors.Is(err, auth.ErrRoleNotAssigned) {
+		t.Errorf("UnassignRole again: err = %v, want ErrRoleNotAssigned", err)
+	}
+	if roles, err := s.KeyRoles(keyID); err != nil || len(roles) != 0 {
+		t.Errorf("KeyRoles after the unassign = %v, %v, want none", roles, err)
+	}
+
+	if err := s.AssignRole(keyID, "reporter"); err != nil {
+		t.Fatalf("AssignRole: %v", err)
+	}
+	unassigned, err := s.DeleteRole("reporter")
+	if err != nil {
+		t.Fatalf("DeleteRole: %v", err)
+	}
+	if unassigned != 1 {
+		t.Errorf("DeleteRole unassigned %d keys, want 1", unassigned)
+	}
+	perms, err = s.EffectivePermissions(ctx, keyID)
+	if err != nil {
+		t.Fatalf("EffectivePermissions: %v", err)
+	}
+	if want := []string{"task.delete", "task.read"}; !slices.Equal(perms, want) {
+		t.Errorf("EffectivePermissions after the delete = %v, want %v", perms, want)
+	}
+
+	// viewer comes with the migrations
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"AssignRole of a missing role", s.AssignRole(keyID, "reporter"), auth.ErrRoleNotFound},
+		{"AssignRole to a missing key", s.AssignRole(keyID+100, "viewer"), auth.ErrKeyNotFound},
+		{"GrantRolePermission to a missing role", s.GrantRolePermission("reporter", "task.read"), auth.ErrRoleNotFound},
+		{"GrantRolePermission of a missing permission", s.GrantRolePermission("viewer", "report.read"), auth.ErrPermissionNotFound},
+	}
+	for _, tt := range tests {
+		if !errors.Is(tt.err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, tt.err, tt.want)
+		}
+	}
+	if _, err := s.DeleteRole("reporter"); !errors.Is(err, auth.ErrRoleNotFound) {
+		t.Errorf("DeleteRole of a missing role: err = %v, want ErrRoleNotFound", err)
+	}
+}
+
+func TestDeleteAdminRole(t *testing.T) {
+	s := newStorage(t)
+	if err := s.SyncRoles(map[string][]string{auth.AdminRole: {"*"}}); err != nil {
+		t.Fatalf("SyncRoles: %v", err)
+	}
+
+	if _, err := s.DeleteRole(auth.AdminRole); !errors.Is(err, auth.ErrRoleProtected) {
+		t.Errorf("DeleteRole(%s): err = %v, want ErrRoleProtected", auth.AdminRole, err)
+	}
+	if _, ok := rolePermissions(t, s)[auth.AdminRole]; !ok {
+		t.Errorf("role %s is gone", auth.AdminRole)
+	}
+}
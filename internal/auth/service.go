@@ -1,36 +1,105 @@
-package auth 
+package auth
 
 import (
+	"context"
 	"fmt"
+	"strings"
+	"time"
+
+	"rest_api/internal/config"
 )
 
 type Service struct {
-	storage *Storage
+	storage      *Storage
+	defaultRole  string // given to registered keys, none when empty
+	registration config.Registration
+	reserved     map[string]bool // lowercased owner names nobody can register
+}
+
+func NewService(storage *Storage, defaultRole string, registration config.Registration) *Service {
+	reserved := map[string]bool{AdminOwner: true}
+	for _, owner := range registration.ReservedOwners {
+		reserved[normalizeOwner(owner)] = true
+	}
+	return &Service{storage: storage, defaultRole: defaultRole, registration: registration, reserved: reserved}
 }
 
-func NewService(storage *Storage) *Service {
-	return &Service{storage: storage}
+func normalizeOwner(owner string) string {
+	return strings.ToLower(strings.TrimSpace(owner))
 }
 
-// RegisterAPIKey ganerates a new key and saves it hashed in db
-func (s *Service) RegisterAPIKey(owner string) (string, error) {
+// RegisterAPIKey ganerates a new key and saves it hashed in db. Depending on the
+// registration mode it needs an invite code or starts out pending, the returned
+// status tells which.
+func (s *Service) RegisterAPIKey(owner, inviteCode string) (string, string, error) {
+	if s.reserved[normalizeOwner(owner)] {
+		return "", "", fmt.Errorf("%q: %w", owner, ErrOwnerReserved)
+	}
+	if s.registration.Mode == config.RegistrationInvite && inviteCode == "" {
+		return "", "", ErrInviteRequired
+	}
+
 	plain, hash, err := GenerateAPIKey()
 	if err != nil {
-		return "", fmt.Errorf("failed to generate api key: %w", err)
+		return "", "", fmt.Errorf("failed to generate api key: %w", err)
 	}
 
+	// the key, the invite it uses up and its default role are stored together,
+	// a failure leaves none of them behind
+	status, code := KeyActive, ""
+	switch s.registration.Mode {
+	case config.RegistrationInvite:
+		code = inviteCode
+	case config.RegistrationApproval:
+		status = KeyPending
+	}
+	if _, err := s.storage.AddRegisteredKey(hash, KeyPrefix(plain), owner, status, code, s.defaultRole); err != nil {
+		return "", "", fmt.Errorf("failed to save key: %w", err)
+	}
+	return plain, status, nil
+}
 
-	_, err = s.storage.AddAPIKey(hash, owner)
-	if err != nil {
-		return "", fmt.Errorf("failed to save key: %w", err)
+// CreateInvite issues an invite code, ttl 0 uses the configured lifetime
+func (s *Service) CreateInvite(createdBy int64, ttl time.Duration) (string, Invite, error) {
+	if ttl == 0 {
+		ttl = s.registration.InviteTTL
+	}
+	var expiresAt *time.Time
+	if ttl > 0 {
+		t := time.Now().Add(ttl)
+		expiresAt = &t
 	}
-	return plain, nil
+	return s.storage.CreateInvite(createdBy, expiresAt)
+}
+
+// ListInvites returns all invites, used ones included
+func (s *Service) ListInvites() ([]Invite, error) {
+	return s.storage.ListInvites()
+}
+
+// DeleteInvite withdraws an unused invite
+func (s *Service) DeleteInvite(id int64) error {
+	return s.storage.DeleteInvite(id)
 }
 
-// CheckPermission verifies if apiKey has given permission
-func (s *Service) CheckPermission(apiKeyPlain string, permission string) (bool, error) {
-	hash := HashKey(apiKeyPlain)
-	return s.storage.HasPermission(hash, permission)
+// PendingRegistrations returns the keys waiting for approval
+func (s *Service) PendingRegistrations() ([]APIKey, error) {
+	return s.storage.PendingKeys()
+}
+
+// ApproveRegistration activates a pending key
+func (s *Service) ApproveRegistration(keyID int64) error {
+	return s.storage.ApproveKey(keyID)
+}
+
+// RejectRegistration rejects a pending key
+func (s *Service) RejectRegistration(keyID int64) error {
+	return s.storage.RejectKey(keyID)
+}
+
+// CheckPermission verifies if the key keyID has given permission
+func (s *Service) CheckPermission(ctx context.Context, keyID int64, permission string) (bool, error) {
+	return s.storage.HasPermission(ctx, keyID, permission)
 }
 
 // ListKeys return all stored keys
@@ -38,41 +107,111 @@ func (s *Service) ListKeys() ([]APIKey, error) {
 	return s.storage.ListAPIKeys()
 }
 
-// CreatePermission creates a new permission
+// RevokeKey revokes the key keyID
+func (s *Service) RevokeKey(keyID int64) error {
+	return s.storage.RevokeKey(keyID)
+}
+
+// RotateKey issues a new secret for keyID, the old one works for grace more
+func (s *Service) RotateKey(keyID int64, grace time.Duration) (string, time.Time, error) {
+	return s.storage.RotateKey(keyID, grace)
+}
+
+// SetKeyExpiry sets or (with nil) clears the expiry of the key keyID
+func (s *Service) SetKeyExpiry(keyID int64, expiresAt *time.Time) error {
+	return s.storage.SetKeyExpiry(keyID, expiresAt)
+}
+
+// CreatePermission creates a new permission, ErrPermissionExists when it's taken
 func (s *Service) CreatePermission(name string) error {
-	_, err := s.storage.db.Exec("INSERT INTO permissions(name) VALUES(?)", name)
-	if err != nil {
-		return fmt.Errorf("failed to create permission: %w", err)
-	}
-	return nil
+	return s.storage.AddPermission(name)
 }
 
 // GrantPermission assings a permission to a key
 func (s *Service) GrantPermission(keyID, permID int64) error {
-	_, err := s.storage.db.Exec(
-		"INSERT OR IGNORE INTO api_key_permissions(api_key_id, permission_id) VALUES (?, ?)",
-		keyID, permID,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to grant permission: %w", err)
+	return s.storage.GrantPermissionByID(keyID, permID)
+}
+
+// KeyPermissions lists the permissions granted to a key
+func (s *Service) KeyPermissions(keyID int64) ([]Permission, error) {
+	return s.storage.KeyPermissions(keyID)
+}
+
+// RevokePermission removes a permission from a key
+func (s *Service) RevokePermission(keyID int64, name string) error {
+	return s.storage.RevokePermission(keyID, name)
+}
+
+// DeletePermission deletes a permission and revokes it from all keys
+func (s *Service) DeletePermission(name string) (int64, error) {
+	return s.storage.DeletePermission(name)
+}
+
+// ListRoles returns all roles with their permissions
+func (s *Service) ListRoles() ([]Role, error) {
+	return s.storage.ListRoles()
+}
+
+// CreateRole creates a role bundling existing permissions
+func (s *Service) CreateRole(name string, perms []string) (Role, error) {
+	return s.storage.CreateRole(name, perms)
+}
+
+// DeleteRole deletes a role and unassigns it from all keys
+func (s *Service) DeleteRole(name string) (int64, error) {
+	return s.storage.DeleteRole(name)
+}
+
+// GrantRolePermission adds a permission to a role
+func (s *Service) GrantRolePermission(role, perm string) error {
+	return s.storage.GrantRolePermission(role, perm)
+}
+
+// RevokeRolePermission removes a permission from a role
+func (s *Service) RevokeRolePermission(role, perm string) error {
+	return s.storage.RevokeRolePermission(role, perm)
+}
+
+// KeyRoles lists the roles assigned to a key
+func (s *Service) KeyRoles(keyID int64) ([]string, error) {
+	return s.storage.KeyRoles(keyID)
+}
+
+// AssignRole gives a role to a key
+func (s *Service) AssignRole(keyID int64, role string) error {
+	return s.storage.AssignRole(keyID, role)
+}
+
+// UnassignRole takes a role away from a key
+func (s *Service) UnassignRole(keyID int64, role string) error {
+	return s.storage.UnassignRole(keyID, role)
+}
+
+// KeyLimits returns the limits set on a key and the ones in effect with its roles
+func (s *Service) KeyLimits(ctx context.Context, keyID int64) (own, effective Limits, err error) {
+	if own, err = s.storage.KeyLimits(ctx, keyID); err != nil {
+		return
 	}
-	return nil
+	effective, err = s.storage.EffectiveLimits(ctx, keyID)
+	return
 }
 
+// SetKeyLimits replaces the limits of a key
+func (s *Service) SetKeyLimits(keyID int64, limits Limits) error {
+	return s.storage.SetKeyLimits(keyID, limits)
+}
+
+// SetRoleLimits replaces the limits of a role
+func (s *Service) SetRoleLimits(role string, limits Limits) error {
+	return s.storage.SetRoleLimits(role, limits)
+}
+
+// ExplainPermission tells whether a key holds a permission and why
+func (s *Service) ExplainPermission(keyID int64, permission string) (Explanation, error) {
+	return s.storage.ExplainPermission(keyID, permission)
+}
+
+// ListPermissions returns all permissions
 func (s *Service) ListPermissions() ([]Permission, error) {
-    rows, err := s.storage.db.Query("SELECT id, name FROM permissions")
-    if err != nil {
-        return nil, fmt.Errorf("failed to list permissions: %w", err)
-    }
-    defer rows.Close()
-
-    var perms []Permission
-    for rows.Next() {
-        var p Permission
-        if err := rows.Scan(&p.ID, &p.Name); err != nil {
-            return nil, err
-        }
-        perms = append(perms, p)
-    }
-    return perms, nil
-}
\ No newline at end of file
+	return s.storage.ListPermissions()
+}
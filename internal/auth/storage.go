@@ -1,50 +1,85 @@
 package auth
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strconv"
+	"time"
+
+	storage "rest_api/internal/db"
+	"rest_api/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	ErrKeyNotFound          = errors.New("api key not found")
+	ErrPermissionNotFound   = errors.New("permission not found")
+	ErrPermissionExists     = errors.New("permission already exists")
+	ErrPermissionNotGranted = errors.New("permission not granted to the key")
+	ErrPermissionProtected  = errors.New("permission is protected")
 )
 
+// SeedPermissions are created at startup, the routes depend on them so they
+// can't be deleted
+var SeedPermissions = []string{Wildcard, "admin", "task.*", "task.read", "task.create", "task.delete", "task.update"}
+
+// IsSeedPermission reports whether name is one of SeedPermissions
+func IsSeedPermission(name string) bool {
+	return slices.Contains(SeedPermissions, name)
+}
+
+// DefaultRotationGrace is how long the old secret keeps working after a rotation
+const DefaultRotationGrace = 24 * time.Hour
+
+// MaxSelfRotationGrace caps the grace period a non-admin key can give its
+// old secret when it rotates itself
+const MaxSelfRotationGrace = DefaultRotationGrace
+
 type Storage struct {
-	db *sql.DB
-}
-
-func NewStorage(db *sql.DB) *Storage {
-	return &Storage{db: db}
-}
-
-// Setup tables
-func (s *Storage) Init() error {
-	_, err := s.db.Exec(`
-		CREATE TABLE IF NOT EXISTS api_keys(
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			key_hash TEXT NOT NULL UNIQUE,
-			owner TEXT NOT NULL,
-			revoked BOOLEAN DEFAULT 0
-		);
-
-		CREATE TABLE IF NOT EXISTS permissions(
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			name TEXT NOT NULL UNIQUE
-		);
-
-		CREATE TABLE IF NOT EXISTS api_key_permissions(
-			api_key_id INTEGER,
-			permission_id INTEGER,
-			PRIMARY KEY (api_key_id, permission_id),
-			FOREIGN KEY (api_key_id) REFERENCES api_keys(id),
-			FOREIGN KEY (permission_id) REFERENCES permissions(id)
-		);
-		`)
+	db      *sql.DB
+	dialect storage.Dialect
+}
+
+func NewStorage(db *sql.DB, dialect storage.Dialect) *Storage {
+	return &Storage{db: db, dialect: dialect}
+}
+
+// tables are the tables the auth storage needs
+var tables = []string{"api_keys", "permissions", "api_key_permissions", "roles", "role_permissions", "api_key_roles"}
+
+// CheckTables returns an error if one of the auth tables is missing or unreadable
+func (s *Storage) CheckTables(ctx context.Context) error {
+	for _, table := range tables {
+		// sqlite may only notice a dropped table when the statement is stepped
+		rows, err := s.db.QueryContext(ctx, "SELECT 1 FROM "+table+" LIMIT 1")
+		if err != nil {
+			return fmt.Errorf("table %s: %w", table, err)
+		}
+		for rows.Next() {
+		}
+		err = rows.Err()
+		rows.Close()
 		if err != nil {
-			return fmt.Errorf("failed to init auth tables: %w", err)
+			return fmt.Errorf("table %s: %w", table, err)
 		}
-		return nil
+	}
+	return nil
+}
+
+// q rewrites placeholders of a query for the storage dialect
+func (s *Storage) q(query string) string {
+	return s.dialect.Rebind(query)
 }
 
 func (s *Storage) ListAPIKeys() ([]APIKey, error) {
-	rows, err := s.db.Query("SELECT id, key_hash, owner, revoked FROM api_keys")
+	rows, err := s.db.Query("SELECT id, key_hash, key_prefix, owner, revoked, expires_at, status FROM api_keys")
 	if err != nil {
 		return nil, fmt.Errorf("failed to list keys: %w", err)
 	}
@@ -53,85 +88,210 @@ func (s *Storage) ListAPIKeys() ([]APIKey, error) {
 	var keys []APIKey
 	for rows.Next() {
 		var k APIKey
-		if err := rows.Scan(&k.ID, &k.Key, &k.Owner, &k.Revoked); err != nil {
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&k.ID, &k.Key, &k.Prefix, &k.Owner, &k.Revoked, &expiresAt, &k.Status); err != nil {
 			return nil, err
 		}
+		if expiresAt.Valid {
+			t := expiresAt.Time.UTC()
+			k.ExpiresAt = &t
+		}
 		keys = append(keys, k)
 	}
 	return keys, nil
 }
 
-// permissions
-func (s *Storage) HasPermission(providedKey, permission string) (bool, error) {
-	hashed := HashKey(providedKey)
-
-	const query = `
-	SELECT COUNT(*)
-	FROM api_keys k
-	JOIN api_key_permissions kp ON k.id = kp.api_key_id
-	JOIN permissions p ON kp.permission_id = p.id
-	WHERE k.key_hash = ? AND p.name = ? AND k.revoked = 0
-	`
+// HasPermission reports whether the key keyID holds permission, granted
+// directly or through one of its roles, exactly or by a wildcard like task.*
+func (s *Storage) HasPermission(ctx context.Context, keyID int64, permission string) (bool, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "auth.HasPermission", trace.WithAttributes(
+		attribute.Int64("key.id", keyID),
+		attribute.String("permission", permission),
+	))
+	defer span.End()
 
-	var count int
-	err := s.db.QueryRow(query, hashed, permission).Scan(&count)
+	perms, err := s.EffectivePermissions(ctx, keyID)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return false, fmt.Errorf("failed to check permission: %w", err)
 	}
-
-	return count > 0, nil
+	ok := slices.ContainsFunc(perms, func(granted string) bool {
+		return MatchPermission(granted, permission)
+	})
+	span.SetAttributes(attribute.Bool("permission.granted", ok))
+	return ok, nil
 }
 
 // EnsureAdminSetup checks for the presence of the admin key and rights
-// and creates them only if they are missing.
-func (s *Storage) EnsureAdminSetup(log *slog.Logger) error {
+// and creates them only if they are missing. seed, when set, becomes the
+// admin key and only its hash is stored. Otherwise a random key is created
+// and returned so the caller can show it once, it is never logged.
+func (s *Storage) EnsureAdminSetup(log *slog.Logger, seed string) (string, error) {
 	const op = "auth.storage.EnsureAdminSetup"
 
-	var count int
-	err := s.db.QueryRow("SELECT COUNT(*) FROM api_keys WHERE owner = 'admin'").Scan(&count)
+	if seed != "" {
+		if err := CheckKeyFormat(seed); err != nil {
+			return "", fmt.Errorf("%s: configured admin key starts with %s but is not a generated key: %w", op, KeyScheme, err)
+		}
+		if reason := KeyWeakness(seed); reason != "" {
+			log.Warn("Configured admin key is weak, use a long random value", slog.String("reason", reason))
+		}
+	}
+
+	// an admin is a key holding the admin permission or role, whatever its owner
+	var exists bool
+	err := s.db.QueryRow(s.q(`
+	SELECT EXISTS(
+		SELECT 1 FROM api_key_permissions kp
+		JOIN permissions p ON p.id = kp.permission_id
+		WHERE p.name = 'admin'
+		UNION ALL
+		SELECT 1 FROM api_key_roles kr
+		JOIN roles r ON r.id = kr.role_id
+		WHERE r.name = ?
+	)`), AdminRole).Scan(&exists)
 	if err != nil {
-		return fmt.Errorf("%s: failed to check admin key: %w", op, err)
+		return "", fmt.Errorf("%s: failed to check admin key: %w", op, err)
 	}
 
-	if count > 0 {
+	if exists {
 		log.Info("Admin key already exists")
-		return nil
+		if seed != "" {
+			var matches bool
+			err := s.db.QueryRow(s.q("SELECT EXISTS(SELECT 1 FROM api_keys WHERE key_hash = ?)"), HashKey(seed)).Scan(&matches)
+			if err != nil {
+				return "", fmt.Errorf("%s: failed to check admin key: %w", op, err)
+			}
+			if !matches {
+				log.Warn("Configured admin key differs from the stored one and is ignored, it only seeds an empty database")
+			}
+		}
+		return "", nil
 	}
 
-	plain, hash, err := GenerateAPIKey()
+	plain, hash := seed, HashKey(seed)
+	if seed == "" {
+		plain, hash, err = GenerateAPIKey()
+		if err != nil {
+			return "", fmt.Errorf("%s: failed to generate admin key: %w", op, err)
+		}
+	}
+
+	keyID, err := s.addAdminKey(hash, KeyPrefix(plain))
 	if err != nil {
-		return fmt.Errorf("%s: failed to generate admin key: %w", op, err)
+		return "", fmt.Errorf("%s: %w", op, err)
 	}
 
-	res, err := s.db.Exec("INSERT INTO api_keys(key_hash, owner) VALUES (?, 'admin')", hash)
+	if seed != "" {
+		log.Info("Admin API key seeded from configuration", slog.Int64("key_id", keyID), slog.String("key_prefix", KeyPrefix(plain)))
+		return "", nil
+	}
+	log.Warn("Admin API key generated", slog.Int64("key_id", keyID), slog.String("key_prefix", KeyPrefix(plain)))
+
+	return plain, nil
+}
+
+// addAdminKey stores the admin key with the admin permission and role in one
+// transaction, a failure leaves no key without rights behind. A seeded key
+// left over by an older, interrupted setup is taken over.
+func (s *Storage) addAdminKey(hash, prefix string) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var keyID int64
+	err = tx.QueryRow(s.q(`
+	INSERT INTO api_keys(key_hash, key_prefix, owner, status) VALUES (?, ?, 'admin', ?)
+	ON CONFLICT (key_hash) DO UPDATE SET owner = excluded.owner, status = excluded.status
+	RETURNING id`), hash, prefix, KeyActive).Scan(&keyID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert admin key: %w", err)
+	}
+
+	if _, err := tx.Exec(s.q("INSERT INTO permissions(name) VALUES ('admin') ON CONFLICT DO NOTHING")); err != nil {
+		return 0, fmt.Errorf("failed to create admin permission: %w", err)
+	}
+	_, err = tx.Exec(s.q(`
+	INSERT INTO api_key_permissions(api_key_id, permission_id)
+	SELECT ?, id FROM permissions WHERE name = 'admin'
+	ON CONFLICT DO NOTHING`), keyID)
 	if err != nil {
-		return fmt.Errorf("%s: failed to insert admin key: %w", op, err)
+		return 0, fmt.Errorf("failed to grant admin permission: %w", err)
 	}
 
-	// create a permission admin
-	if err := s.CreatePermission("admin"); err != nil {
-		return fmt.Errorf("%s: failed to create admin permission: %w", op, err)
+	var roleID int64
+	err = tx.QueryRow(s.q("SELECT id FROM roles WHERE name = ?"), AdminRole).Scan(&roleID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("failed to assign admin role: %s: %w", AdminRole, ErrRoleNotFound)
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to assign admin role: %w", err)
+	}
+	if _, err := tx.Exec(s.q("INSERT INTO api_key_roles(api_key_id, role_id) VALUES (?, ?) ON CONFLICT DO NOTHING"), keyID, roleID); err != nil {
+		return 0, fmt.Errorf("failed to assign admin role: %w", err)
 	}
 
-	keyID, _ := res.LastInsertId()
-	if err := s.GrantPermission(fmt.Sprint(keyID), "admin"); err != nil {
-		return fmt.Errorf("%s: failed to grant admin permission: %w", op, err)
+	if err := tx.Commit(); err != nil {
+		return 0, err
 	}
+	return keyID, nil
+}
 
-	log.Warn("Admin API key generated - save it securely, it will not be sown again ",
-		slog.String("key", plain))
+// CreateKey creates an active key for owner holding the permissions perms,
+// either all of them are granted or no key is created
+func (s *Storage) CreateKey(owner string, perms []string) (string, int64, error) {
+	const op = "auth.storage.CreateKey"
 
-	return nil
+	plain, hash, err := GenerateAPIKey()
+	if err != nil {
+		return "", 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var keyID int64
+	err = tx.QueryRow(s.q("INSERT INTO api_keys(key_hash, key_prefix, owner, status) VALUES (?, ?, ?, ?) RETURNING id"),
+		hash, KeyPrefix(plain), owner, KeyActive).Scan(&keyID)
+	if err != nil {
+		return "", 0, fmt.Errorf("%s: insert key: %w", op, err)
+	}
+
+	for _, perm := range perms {
+		var permID int64
+		err := tx.QueryRow(s.q("SELECT id FROM permissions WHERE name = ?"), perm).Scan(&permID)
+		if err == sql.ErrNoRows {
+			return "", 0, fmt.Errorf("%s: %q: %w", op, perm, ErrPermissionNotFound)
+		} else if err != nil {
+			return "", 0, fmt.Errorf("%s: %w", op, err)
+		}
+		_, err = tx.Exec(s.q(`
+		INSERT INTO api_key_permissions(api_key_id, permission_id)
+		VALUES (?, ?) ON CONFLICT DO NOTHING`), keyID, permID)
+		if err != nil {
+			return "", 0, fmt.Errorf("%s: grant %s: %w", op, perm, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return plain, keyID, nil
 }
 
-//RegisterKey create new api-key and save it to db
-func(s *Storage) RegisterKey(owner string) (string, error) {
+// RegisterKey create new api-key and save it to db
+func (s *Storage) RegisterKey(owner string) (string, error) {
 	plainKey, hashedKey, err := GenerateAPIKey()
 	if err != nil {
 		return "", fmt.Errorf("failed to generate key: %w", err)
 	}
 
-	_, err = s.db.Exec("INSERT INTO api_keys (key_hash, owner) VALUES (?, ?)", hashedKey, owner)
+	_, err = s.db.Exec(s.q("INSERT INTO api_keys (key_hash, key_prefix, owner) VALUES (?, ?, ?)"), hashedKey, KeyPrefix(plainKey), owner)
 	if err != nil {
 		return "", fmt.Errorf("failed to save api key: %w", err)
 	}
@@ -140,58 +300,368 @@ func(s *Storage) RegisterKey(owner string) (string, error) {
 }
 
 // ValidateKey checks if the key is in the database and has not been revoked
-func (s *Storage) ValidateKey(providedKey string) (bool, error) {
+func (s *Storage) ValidateKey(ctx context.Context, providedKey string) (bool, error) {
+	key, err := s.LookupKey(ctx, providedKey)
+	if err != nil {
+		return false, err
+	}
+	return key != nil, nil
+}
+
+// LookupKey returns the usable key matching providedKey, or nil if there is none.
+// A key is usable when it is active, not revoked and not expired. The secret replaced
+// by the last rotation is still accepted until its grace period ends.
+func (s *Storage) LookupKey(ctx context.Context, providedKey string) (*APIKey, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "auth.ValidateKey")
+	defer span.End()
+
+	key, result, err := s.lookupKey(ctx, providedKey)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(attribute.String("key.result", result))
+	if key != nil {
+		span.SetAttributes(attribute.Int64("key.id", key.ID))
+	}
+	return key, nil
+}
+
+// lookupKey implements LookupKey, result tells why a key was accepted or
+// rejected: valid, grace (old secret of a rotated key), unknown, revoked,
+// expired, pending or rejected. Malformed keys are turned away by
+// AuthMiddleware before they get here.
+func (s *Storage) lookupKey(ctx context.Context, providedKey string) (*APIKey, string, error) {
 	hashed := HashKey(providedKey)
 
-	var exists bool
-	err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM api_keys WHERE key_hash = ? AND revoked = 0)", hashed).Scan(&exists)
+	var k APIKey
+	var expiresAt, previousExpiresAt sql.NullTime
+	err := s.db.QueryRowContext(ctx, s.q(`
+	SELECT id, key_hash, key_prefix, owner, revoked, expires_at, previous_key_expires_at, status
+	FROM api_keys
+	WHERE key_hash = ? OR previous_key_hash = ?`), hashed, hashed).
+		Scan(&k.ID, &k.Key, &k.Prefix, &k.Owner, &k.Revoked, &expiresAt, &previousExpiresAt, &k.Status)
+	if err == sql.ErrNoRows {
+		return nil, "unknown", nil
+	} else if err != nil {
+		return nil, "", fmt.Errorf("failed to check api key: %w", err)
+	}
+
+	now := time.Now()
+	if k.Revoked {
+		return nil, "revoked", nil
+	}
+	if k.Status != KeyActive {
+		return nil, k.Status, nil
+	}
+	if expiresAt.Valid {
+		if !now.Before(expiresAt.Time) {
+			return nil, "expired", nil
+		}
+		t := expiresAt.Time.UTC()
+		k.ExpiresAt = &t
+	}
+	if k.Key != hashed {
+		if !previousExpiresAt.Valid || !now.Before(previousExpiresAt.Time) {
+			return nil, "expired", nil
+		}
+		k.Grace = true
+		return &k, "grace", nil
+	}
+
+	return &k, "valid", nil
+}
+
+// RevokeKey marks the key as revoked, it stops working immediately
+func (s *Storage) RevokeKey(keyID int64) error {
+	const op = "auth.storage.RevokeKey"
+
+	res, err := s.db.Exec(s.q("UPDATE api_keys SET revoked = TRUE WHERE id = ?"), keyID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return checkKeyUpdated(op, res, keyID)
+}
+
+// RotateKey replaces the secret of an active key with a new one and returns it.
+// The old secret keeps working for grace, which may be zero.
+func (s *Storage) RotateKey(keyID int64, grace time.Duration) (plain string, graceUntil time.Time, err error) {
+	const op = "auth.storage.RotateKey"
+
+	plain, hash, err := GenerateAPIKey()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("%s: failed to generate key: %w", op, err)
+	}
+
+	graceUntil = storage.Now().Add(grace)
+	res, err := s.db.Exec(s.q(`
+	UPDATE api_keys SET previous_key_hash = key_hash, previous_key_expires_at = ?, key_hash = ?, key_prefix = ?
+	WHERE id = ? AND revoked = FALSE`), graceUntil, hash, KeyPrefix(plain), keyID)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := checkKeyUpdated(op, res, keyID); err != nil {
+		return "", time.Time{}, err
+	}
+	return plain, graceUntil, nil
+}
+
+// SetKeyExpiry sets the time the key stops working, nil removes the expiry
+func (s *Storage) SetKeyExpiry(keyID int64, expiresAt *time.Time) error {
+	const op = "auth.storage.SetKeyExpiry"
+
+	var value sql.NullTime
+	if expiresAt != nil {
+		value = sql.NullTime{Time: storage.NormalizeTime(*expiresAt), Valid: true}
+	}
+	res, err := s.db.Exec(s.q("UPDATE api_keys SET expires_at = ? WHERE id = ?"), value, keyID)
 	if err != nil {
-		return false, fmt.Errorf("failed to check api key: %w", err)
+		return fmt.Errorf("%s: %w", op, err)
 	}
+	return checkKeyUpdated(op, res, keyID)
+}
 
-	return exists, nil
+// checkKeyUpdated returns ErrKeyNotFound if the update matched no key
+func checkKeyUpdated(op string, res sql.Result, keyID int64) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: id=%d: %w", op, keyID, ErrKeyNotFound)
+	}
+	return nil
 }
 
 // GrantPermission adds a right to the API key
 func (s *Storage) GrantPermission(apiKeyID string, permissionName string) error {
-	var permID int
-	err := s.db.QueryRow("SELECT id FROM permissions WHERE name = ?", permissionName).Scan(&permID)
+	keyID, err := strconv.ParseInt(apiKeyID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid api key id %q", apiKeyID)
+	}
+
+	var permID int64
+	err = s.db.QueryRow(s.q("SELECT id FROM permissions WHERE name = ?"), permissionName).Scan(&permID)
 	if err == sql.ErrNoRows {
-		return fmt.Errorf("permission not found")
+		return ErrPermissionNotFound
 	} else if err != nil {
 		return fmt.Errorf("failed to lookup permission: %w", err)
 	}
 
-	_, err = s.db.Exec(`
-		INSERT OR IGNORE INTO api_key_permissions(api_key_id, permission_id)
-		VALUES (?, ?)`, apiKeyID, permID)
+	return s.GrantPermissionByID(keyID, permID)
+}
+
+// GrantPermissionByID grants the permission permID to the key keyID, it returns
+// ErrKeyNotFound or ErrPermissionNotFound instead of leaving an orphan grant
+func (s *Storage) GrantPermissionByID(keyID, permID int64) error {
+	const op = "auth.storage.GrantPermissionByID"
+
+	if err := s.CheckKeyExists(keyID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	var exists bool
+	err := s.db.QueryRow(s.q("SELECT EXISTS(SELECT 1 FROM permissions WHERE id = ?)"), permID).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if !exists {
+		return fmt.Errorf("%s: id=%d: %w", op, permID, ErrPermissionNotFound)
+	}
+
+	_, err = s.db.Exec(s.q(`
+		INSERT INTO api_key_permissions(api_key_id, permission_id)
+		VALUES (?, ?) ON CONFLICT DO NOTHING`), keyID, permID)
+	if err != nil {
+		return fmt.Errorf("%s: failed to grant permission: %w", op, err)
+	}
+	return nil
+}
+
+// KeyPermissions returns the permissions granted to the key keyID ordered by name
+func (s *Storage) KeyPermissions(keyID int64) ([]Permission, error) {
+	const op = "auth.storage.KeyPermissions"
+
+	if err := s.CheckKeyExists(keyID); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(s.q(`
+	SELECT p.id, p.name
+	FROM permissions p
+	JOIN api_key_permissions kp ON kp.permission_id = p.id
+	WHERE kp.api_key_id = ?
+	ORDER BY p.name`), keyID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	perms := []Permission{}
+	for rows.Next() {
+		var p Permission
+		if err := rows.Scan(&p.ID, &p.Name); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		perms = append(perms, p)
+	}
+	return perms, rows.Err()
+}
+
+// RevokePermission removes the permission name from the key keyID
+func (s *Storage) RevokePermission(keyID int64, name string) error {
+	const op = "auth.storage.RevokePermission"
+
+	if err := s.CheckKeyExists(keyID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	permID, err := s.permissionID(name)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := s.db.Exec(s.q("DELETE FROM api_key_permissions WHERE api_key_id = ? AND permission_id = ?"), keyID, permID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %s: %w", op, name, ErrPermissionNotGranted)
+	}
+	return nil
+}
+
+// DeletePermission deletes the permission name, revokes it from every key
+// holding it and removes it from all roles. It returns the number of keys it was revoked from.
+// Seed permissions can't be deleted.
+func (s *Storage) DeletePermission(name string) (int64, error) {
+	const op = "auth.storage.DeletePermission"
+
+	if IsSeedPermission(name) {
+		return 0, fmt.Errorf("%s: %s: %w", op, name, ErrPermissionProtected)
+	}
+	permID, err := s.permissionID(name)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(s.q("DELETE FROM api_key_permissions WHERE permission_id = ?"), permID)
+	if err != nil {
+		return 0, fmt.Errorf("%s: revoke from keys: %w", op, err)
+	}
+	revoked, err := res.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("failed to grant permission: %w", err)
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	if _, err := tx.Exec(s.q("DELETE FROM role_permissions WHERE permission_id = ?"), permID); err != nil {
+		return 0, fmt.Errorf("%s: remove from roles: %w", op, err)
+	}
+	if _, err := tx.Exec(s.q("DELETE FROM permissions WHERE id = ?"), permID); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return revoked, nil
+}
+
+// permissionID returns the id of the permission name or ErrPermissionNotFound
+func (s *Storage) permissionID(name string) (int64, error) {
+	var id int64
+	err := s.db.QueryRow(s.q("SELECT id FROM permissions WHERE name = ?"), name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("%s: %w", name, ErrPermissionNotFound)
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to lookup permission: %w", err)
+	}
+	return id, nil
+}
+
+// CheckKeyExists returns ErrKeyNotFound if there is no key keyID
+func (s *Storage) CheckKeyExists(keyID int64) error {
+	var exists bool
+	err := s.db.QueryRow(s.q("SELECT EXISTS(SELECT 1 FROM api_keys WHERE id = ?)"), keyID).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check api key: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("id=%d: %w", keyID, ErrKeyNotFound)
 	}
 	return nil
 }
 
 // CreatePermission create a new permission, if they not exist
 func (s *Storage) CreatePermission(name string) error {
-	_, err := s.db.Exec("INSERT OR IGNORE INTO permissions(name) VALUES (?)", name)
+	if !ValidPermissionName(name) {
+		return fmt.Errorf("%q: %w", name, ErrInvalidPermission)
+	}
+	_, err := s.db.Exec(s.q("INSERT INTO permissions(name) VALUES (?) ON CONFLICT DO NOTHING"), name)
 	if err != nil {
 		return fmt.Errorf("failed to create permission: %w", err)
 	}
 	return nil
 }
 
-// AddAPIKey inserts hashedKey into api_keys and returns inserted id
-func (s *Storage) AddAPIKey(hashedKey, owner string) (int64, error) {
-	const op = "auth.storage.AddAPIKey"
+// AddPermission creates the permission name, ErrPermissionExists when it's
+// already there
+func (s *Storage) AddPermission(name string) error {
+	const op = "auth.storage.AddPermission"
 
-	res, err := s.db.Exec("INSERT INTO api_keys(key_hash, owner) VALUES (?, ?)", hashedKey, owner)
+	if !ValidPermissionName(name) {
+		return fmt.Errorf("%s: %q: %w", op, name, ErrInvalidPermission)
+	}
+	var id int64
+	err := s.db.QueryRow(s.q("INSERT INTO permissions(name) VALUES (?) ON CONFLICT DO NOTHING RETURNING id"), name).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%s: %s: %w", op, name, ErrPermissionExists)
+	}
 	if err != nil {
-		return 0, fmt.Errorf("%s: insert failed: %w", op, err)
+		return fmt.Errorf("%s: %w", op, err)
 	}
+	return nil
+}
+
+// ListPermissions returns all permissions ordered by name
+func (s *Storage) ListPermissions() ([]Permission, error) {
+	const op = "auth.storage.ListPermissions"
 
-	id, err := res.LastInsertId()
+	rows, err := s.db.Query(s.q("SELECT id, name FROM permissions ORDER BY name"))
 	if err != nil {
-		return 0, fmt.Errorf("%s: get lastInsertId: %w", op, err)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	perms := []Permission{}
+	for rows.Next() {
+		var p Permission
+		if err := rows.Scan(&p.ID, &p.Name); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		perms = append(perms, p)
+	}
+	return perms, rows.Err()
+}
+
+// AddAPIKey inserts hashedKey into api_keys and returns inserted id
+func (s *Storage) AddAPIKey(hashedKey, prefix, owner, status string) (int64, error) {
+	const op = "auth.storage.AddAPIKey"
+
+	var id int64
+	err := s.db.QueryRow(s.q("INSERT INTO api_keys(key_hash, key_prefix, owner, status) VALUES (?, ?, ?, ?) RETURNING id"),
+		hashedKey, prefix, owner, status).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("%s: insert failed: %w", op, err)
 	}
 	return id, nil
 }
@@ -0,0 +1,537 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"rest_api/internal/auth"
+	"rest_api/internal/config"
+	storage "rest_api/internal/db"
+	"rest_api/internal/db/dbtest"
+	"rest_api/internal/db/sqlite"
+)
+
+// newStorage returns auth storage on a migrated sqlite database holding the
+// seed permissions
+func newStorage(t *testing.T) *auth.Storage {
+	t.Helper()
+
+	db, err := sqlite.Open(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	dbtest.Migrate(t, db, storage.DialectSQLite)
+
+	s := auth.NewStorage(db, storage.DialectSQLite)
+	for _, perm := range auth.SeedPermissions {
+		if err := s.CreatePermission(perm); err != nil {
+			t.Fatalf("create permission %s: %v", perm, err)
+		}
+	}
+	return s
+}
+
+func TestExplainPermissionKeyState(t *testing.T) {
+	s := newStorage(t)
+
+	// addKey adds a key holding task.read in status
+	addKey := func(owner, status string) int64 {
+		t.Helper()
+		id, err := s.AddAPIKey("hash-"+owner, owner, owner, status)
+		if err != nil {
+			t.Fatalf("add key: %v", err)
+		}
+		if err := s.GrantPermission(strconv.FormatInt(id, 10), "task.read"); err != nil {
+			t.Fatalf("grant: %v", err)
+		}
+		return id
+	}
+
+	active := addKey("active", auth.KeyActive)
+	pending := addKey("pending", auth.KeyPending)
+	rejected := addKey("rejected", auth.KeyRejected)
+	revoked := addKey("revoked", auth.KeyActive)
+	if err := s.RevokeKey(revoked); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	expired := addKey("expired", auth.KeyActive)
+	past := time.Now().Add(-time.Hour)
+	if err := s.SetKeyExpiry(expired, &past); err != nil {
+		t.Fatalf("expire: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		keyID   int64
+		allowed bool
+		reason  string
+	}{
+		{"active", active, true, "granted by"},
+		{"pending", pending, false, "approve"},
+		{"rejected", rejected, false, "rejected"},
+		{"revoked", revoked, false, "revoked"},
+		{"expired", expired, false, "expired"},
+	}
+	for _, tt := range tests {
+		e, err := s.ExplainPermission(tt.keyID, "task.read")
+		if err != nil {
+			t.Fatalf("%s: ExplainPermission: %v", tt.name, err)
+		}
+		if e.Allowed != tt.allowed || !strings.Contains(e.Reason, tt.reason) {
+			t.Errorf("%s: allowed %v, reason %q, want %v and a reason mentioning %q", tt.name, e.Allowed, e.Reason, tt.allowed, tt.reason)
+		}
+		// the grants are reported whatever the state of the key
+		if len(e.MatchedBy) != 1 {
+			t.Errorf("%s: matched by %v, want the task.read grant", tt.name, e.MatchedBy)
+		}
+	}
+}
+
+func TestRegisterAPIKeyIsAtomic(t *testing.T) {
+	s := newStorage(t)
+	if err := s.SyncRoles(map[string][]string{"viewer": {"task.read"}}); err != nil {
+		t.Fatalf("sync roles: %v", err)
+	}
+	_, adminID, err := s.CreateKey("admin", []string{"admin"})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	code, _, err := s.CreateInvite(adminID, nil)
+	if err != nil {
+		t.Fatalf("create invite: %v", err)
+	}
+	invite := config.Registration{Mode: config.RegistrationInvite}
+
+	keys := func() int {
+		t.Helper()
+		list, err := s.ListAPIKeys()
+		if err != nil {
+			t.Fatalf("list keys: %v", err)
+		}
+		return len(list)
+	}
+	before := keys()
+
+	// a missing default role fails the registration after the key and the
+	// invite were written, neither may stay
+	if _, _, err := auth.NewService(s, "missing", invite).RegisterAPIKey("alice", code); !errors.Is(err, auth.ErrRoleNotFound) {
+		t.Fatalf("RegisterAPIKey with a missing role: err = %v, want ErrRoleNotFound", err)
+	}
+	if _, _, err := auth.NewService(s, "viewer", invite).RegisterAPIKey("alice", "wrong code"); !errors.Is(err, auth.ErrInvalidInvite) {
+		t.Fatalf("RegisterAPIKey with a wrong invite: err = %v, want ErrInvalidInvite", err)
+	}
+	if n := keys(); n != before {
+		t.Errorf("%d keys stored by failed registrations", n-before)
+	}
+
+	plain, status, err := auth.NewService(s, "viewer", invite).RegisterAPIKey("alice", code)
+	if err != nil {
+		t.Fatalf("RegisterAPIKey: %v", err)
+	}
+	if status != auth.KeyActive {
+		t.Errorf("status = %s, want active", status)
+	}
+	key, err := s.LookupKey(context.Background(), plain)
+	if err != nil || key == nil {
+		t.Fatalf("LookupKey of the registered key: %v, %v", key, err)
+	}
+	if roles, err := s.KeyRoles(key.ID); err != nil || len(roles) != 1 || roles[0] != "viewer" {
+		t.Errorf("roles = %v, %v, want viewer", roles, err)
+	}
+
+	if _, _, err := auth.NewService(s, "viewer", invite).RegisterAPIKey("bob", code); !errors.Is(err, auth.ErrInvalidInvite) {
+		t.Errorf("second use of an invite: err = %v, want ErrInvalidInvite", err)
+	}
+}
+
+func TestEnsureAdminSetupRepairsInterruptedSetup(t *testing.T) {
+	s := newStorage(t)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := context.Background()
+
+	seed, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	// an older setup stopped after storing the key
+	if _, err := s.AddAPIKey(hash, auth.KeyPrefix(seed), "admin", auth.KeyActive); err != nil {
+		t.Fatalf("add key: %v", err)
+	}
+
+	// without the admin role the setup fails and writes nothing
+	if _, err := s.EnsureAdminSetup(log, ""); !errors.Is(err, auth.ErrRoleNotFound) {
+		t.Fatalf("EnsureAdminSetup without the admin role: err = %v, want ErrRoleNotFound", err)
+	}
+	if keys, err := s.ListAPIKeys(); err != nil || len(keys) != 1 {
+		t.Fatalf("keys after a failed setup = %d, %v, want only the old one", len(keys), err)
+	}
+
+	if err := s.SyncRoles(config.DefaultPolicy().Roles); err != nil {
+		t.Fatalf("sync roles: %v", err)
+	}
+	if plain, err := s.EnsureAdminSetup(log, seed); err != nil || plain != "" {
+		t.Fatalf("EnsureAdminSetup = %q, %v, want the seed taken over", plain, err)
+	}
+	key, err := s.LookupKey(ctx, seed)
+	if err != nil || key == nil {
+		t.Fatalf("LookupKey of the seed: %v, %v", key, err)
+	}
+	if ok, err := s.HasPermission(ctx, key.ID, "admin"); err != nil || !ok {
+		t.Errorf("seeded key holds admin = %v, %v, want true", ok, err)
+	}
+	if roles, err := s.KeyRoles(key.ID); err != nil || !slices.Contains(roles, auth.AdminRole) {
+		t.Errorf("seeded key roles = %v, %v, want %s", roles, err, auth.AdminRole)
+	}
+
+	// the next start finds the admin and leaves it alone
+	if plain, err := s.EnsureAdminSetup(log, ""); err != nil || plain != "" {
+		t.Errorf("EnsureAdminSetup with an admin = %q, %v, want nothing created", plain, err)
+	}
+	if keys, err := s.ListAPIKeys(); err != nil || len(keys) != 1 {
+		t.Errorf("keys = %d, %v, want 1", len(keys), err)
+	}
+}
+
+func TestAddPermission(t *testing.T) {
+	s := newStorage(t)
+
+	if err := s.AddPermission("report.read"); err != nil {
+		t.Fatalf("AddPermission: %v", err)
+	}
+	for _, name := range []string{"report.read", "task.read"} {
+		if err := s.AddPermission(name); !errors.Is(err, auth.ErrPermissionExists) {
+			t.Errorf("AddPermission(%s) again: err = %v, want ErrPermissionExists", name, err)
+		}
+	}
+	if err := s.AddPermission("report."); !errors.Is(err, auth.ErrInvalidPermission) {
+		t.Errorf("AddPermission of an invalid name: err = %v, want ErrInvalidPermission", err)
+	}
+
+	perms, err := s.ListPermissions()
+	if err != nil {
+		t.Fatalf("ListPermissions: %v", err)
+	}
+	if len(perms) != len(auth.SeedPermissions)+1 {
+		t.Errorf("ListPermissions = %d permissions, want %d", len(perms), len(auth.SeedPermissions)+1)
+	}
+}
+
+func TestRotateKeyGrace(t *testing.T) {
+	s := newStorage(t)
+	ctx := context.Background()
+
+	lookup := func(plain string) *auth.APIKey {
+		t.Helper()
+		key, err := s.LookupKey(ctx, plain)
+		if err != nil {
+			t.Fatalf("LookupKey: %v", err)
+		}
+		return key
+	}
+
+	first, id, err := s.CreateKey("alice", []string{"task.read"})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	second, graceUntil, err := s.RotateKey(id, time.Hour)
+	if err != nil {
+		t.Fatalf("RotateKey: %v", err)
+	}
+	if d := time.Until(graceUntil); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("grace until %v, want in an hour", graceUntil)
+	}
+	if key := lookup(second); key == nil || key.ID != id || key.Grace {
+		t.Errorf("new secret = %+v, want key %d outside the grace period", key, id)
+	}
+	if key := lookup(first); key == nil || key.ID != id || !key.Grace {
+		t.Errorf("old secret during the grace period = %+v, want key %d with Grace", key, id)
+	}
+
+	// only the secret replaced last keeps a grace period
+	third, _, err := s.RotateKey(id, 0)
+	if err != nil {
+		t.Fatalf("RotateKey without grace: %v", err)
+	}
+	if key := lookup(third); key == nil || key.ID != id {
+		t.Errorf("new secret = %+v, want key %d", key, id)
+	}
+	for name, plain := range map[string]string{"first": first, "second": second} {
+		if key := lookup(plain); key != nil {
+			t.Errorf("%s secret after a rotation without grace = %+v, want rejected", name, key)
+		}
+	}
+
+	if _, _, err := s.RotateKey(id+100, time.Hour); !errors.Is(err, auth.ErrKeyNotFound) {
+		t.Errorf("RotateKey of a missing key: err = %v, want ErrKeyNotFound", err)
+	}
+}
+
+func TestRevokeKey(t *testing.T) {
+	s := newStorage(t)
+	ctx := context.Background()
+
+	first, id, err := s.CreateKey("alice", []string{"task.read"})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	second, _, err := s.RotateKey(id, time.Hour)
+	if err != nil {
+		t.Fatalf("RotateKey: %v", err)
+	}
+	if err := s.RevokeKey(id); err != nil {
+		t.Fatalf("RevokeKey: %v", err)
+	}
+
+	// revoking ends the grace period of the old secret too
+	for name, plain := range map[string]string{"old": first, "new": second} {
+		if key, err := s.LookupKey(ctx, plain); err != nil || key != nil {
+			t.Errorf("%s secret of a revoked key = %+v, %v, want rejected", name, key, err)
+		}
+	}
+	if _, _, err := s.RotateKey(id, time.Hour); !errors.Is(err, auth.ErrKeyNotFound) {
+		t.Errorf("RotateKey of a revoked key: err = %v, want ErrKeyNotFound", err)
+	}
+	if err := s.RevokeKey(id + 100); !errors.Is(err, auth.ErrKeyNotFound) {
+		t.Errorf("RevokeKey of a missing key: err = %v, want ErrKeyNotFound", err)
+	}
+}
+
+func TestSetKeyExpiry(t *testing.T) {
+	s := newStorage(t)
+	ctx := context.Background()
+
+	plain, id, err := s.CreateKey("alice", []string{"task.read"})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+
+	future := time.Now().Add(time.Hour)
+	if err := s.SetKeyExpiry(id, &future); err != nil {
+		t.Fatalf("SetKeyExpiry: %v", err)
+	}
+	key, err := s.LookupKey(ctx, plain)
+	if err != nil || key == nil {
+		t.Fatalf("LookupKey before the expiry = %+v, %v, want the key", key, err)
+	}
+	if key.ExpiresAt == nil || !key.ExpiresAt.Equal(storage.NormalizeTime(future)) {
+		t.Errorf("ExpiresAt = %v, want %v", key.ExpiresAt, storage.NormalizeTime(future))
+	}
+
+	past := time.Now().Add(-time.Second)
+	if err := s.SetKeyExpiry(id, &past); err != nil {
+		t.Fatalf("SetKeyExpiry: %v", err)
+	}
+	if key, err := s.LookupKey(ctx, plain); err != nil || key != nil {
+		t.Errorf("LookupKey after the expiry = %+v, %v, want rejected", key, err)
+	}
+
+	// nil removes the expiry
+	if err := s.SetKeyExpiry(id, nil); err != nil {
+		t.Fatalf("SetKeyExpiry(nil): %v", err)
+	}
+	if key, err := s.LookupKey(ctx, plain); err != nil || key == nil || key.ExpiresAt != nil {
+		t.Errorf("LookupKey without expiry = %+v, %v, want the key without ExpiresAt", key, err)
+	}
+
+	if err := s.SetKeyExpiry(id+100, nil); !errors.Is(err, auth.ErrKeyNotFound) {
+		t.Errorf("SetKeyExpiry of a missing key: err = %v, want ErrKeyNotFound", err)
+	}
+}
+
+func TestDeletePermission(t *testing.T) {
+	s := newStorage(t)
+	if err := s.CreatePermission("report.read"); err != nil {
+		t.Fatalf("create permission: %v", err)
+	}
+	if _, err := s.CreateRole("reporter", []string{"report.read", "task.read"}); err != nil {
+		t.Fatalf("create role: %v", err)
+	}
+	var keys []int64
+	for _, owner := range []string{"alice", "bob"} {
+		_, id, err := s.CreateKey(owner, []string{"report.read", "task.read"})
+		if err != nil {
+			t.Fatalf("create key: %v", err)
+		}
+		keys = append(keys, id)
+	}
+
+	revoked, err := s.DeletePermission("report.read")
+	if err != nil {
+		t.Fatalf("DeletePermission: %v", err)
+	}
+	if revoked != 2 {
+		t.Errorf("revoked from %d keys, want 2", revoked)
+	}
+	for _, id := range keys {
+		perms, err := s.KeyPermissions(id)
+		if err != nil {
+			t.Fatalf("KeyPermissions: %v", err)
+		}
+		if len(perms) != 1 || perms[0].Name != "task.read" {
+			t.Errorf("key %d permissions = %v, want task.read only", id, perms)
+		}
+	}
+	if got := rolePermissions(t, s)["reporter"]; !slices.Equal(got, []string{"task.read"}) {
+		t.Errorf("reporter permissions = %v, want task.read only", got)
+	}
+	if err := s.AddPermission("report.read"); err != nil {
+		t.Errorf("AddPermission after the delete: %v", err)
+	}
+
+	if _, err := s.DeletePermission("missing"); !errors.Is(err, auth.ErrPermissionNotFound) {
+		t.Errorf("DeletePermission of a missing permission: err = %v, want ErrPermissionNotFound", err)
+	}
+	for _, seed := range auth.SeedPermissions {
+		if _, err := s.DeletePermission(seed); !errors.Is(err, auth.ErrPermissionProtected) {
+			t.Errorf("DeletePermission(%s): err = %v, want ErrPermissionProtected", seed, err)
+		}
+	}
+}
+
+func TestRevokePermission(t *testing.T) {
+	s := newStorage(t)
+	_, id, err := s.CreateKey("alice", []string{"task.read", "task.create"})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+
+	if err := s.RevokePermission(id, "task.create"); err != nil {
+		t.Fatalf("RevokePermission: %v", err)
+	}
+	if ok, err := s.HasPermission(context.Background(), id, "task.create"); err != nil || ok {
+		t.Errorf("HasPermission after the revoke = %v, %v, want false", ok, err)
+	}
+
+	tests := []struct {
+		name  string
+		keyID int64
+		perm  string
+		want  error
+	}{
+		{"not granted", id, "task.create", auth.ErrPermissionNotGranted},
+		{"missing permission", id, "report.read", auth.ErrPermissionNotFound},
+		{"missing key", id + 100, "task.read", auth.ErrKeyNotFound},
+	}
+	for _, tt := range tests {
+		if err := s.RevokePermission(tt.keyID, tt.perm); !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestEnsureAdminSetupSeed(t *testing.T) {
+	ctx := context.Background()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// newAdminStorage returns storage with the admin role of the default policy
+	newAdminStorage := func(t *testing.T) *auth.Storage {
+		t.Helper()
+		s := newStorage(t)
+		if err := s.SyncRoles(config.DefaultPolicy().Roles); err != nil {
+			t.Fatalf("sync roles: %v", err)
+		}
+		return s
+	}
+	isAdmin := func(t *testing.T, s *auth.Storage, plain string) bool {
+		t.Helper()
+		key, err := s.LookupKey(ctx, plain)
+		if err != nil {
+			t.Fatalf("LookupKey: %v", err)
+		}
+		if key == nil {
+			return false
+		}
+		ok, err := s.HasPermission(ctx, key.ID, "admin")
+		if err != nil {
+			t.Fatalf("HasPermission: %v", err)
+		}
+		return ok
+	}
+
+	t.Run("seeded", func(t *testing.T) {
+		s := newAdminStorage(t)
+		seed, _, err := auth.GenerateAPIKey()
+		if err != nil {
+			t.Fatalf("generate key: %v", err)
+		}
+		if plain, err := s.EnsureAdminSetup(log, seed); err != nil || plain != "" {
+			t.Fatalf("EnsureAdminSetup = %q, %v, want the seed stored and nothing to show", plain, err)
+		}
+		if !isAdmin(t, s, seed) {
+			t.Error("the seed is not an admin key")
+		}
+		keys, err := s.ListAPIKeys()
+		if err != nil {
+			t.Fatalf("list keys: %v", err)
+		}
+		if len(keys) != 1 || keys[0].Key != auth.HashKey(seed) || keys[0].Prefix != auth.KeyPrefix(seed) {
+			t.Errorf("keys = %+v, want the seed stored hashed", keys)
+		}
+
+		// a different seed later doesn't replace the admin
+		other, _, err := auth.GenerateAPIKey()
+		if err != nil {
+			t.Fatalf("generate key: %v", err)
+		}
+		if plain, err := s.EnsureAdminSetup(log, other); err != nil || plain != "" {
+			t.Fatalf("EnsureAdminSetup with another seed = %q, %v", plain, err)
+		}
+		if isAdmin(t, s, other) {
+			t.Error("a seed for a database with an admin became an admin key")
+		}
+		if !isAdmin(t, s, seed) {
+			t.Error("the first seed lost admin")
+		}
+	})
+
+	t.Run("legacy seed", func(t *testing.T) {
+		// values without the key scheme predate it and are accepted
+		s := newAdminStorage(t)
+		if _, err := s.EnsureAdminSetup(log, "a-long-legacy-admin-secret-0123456789"); err != nil {
+			t.Fatalf("EnsureAdminSetup: %v", err)
+		}
+		if !isAdmin(t, s, "a-long-legacy-admin-secret-0123456789") {
+			t.Error("the legacy seed is not an admin key")
+		}
+	})
+
+	t.Run("malformed seed", func(t *testing.T) {
+		s := newAdminStorage(t)
+		seed, _, err := auth.GenerateAPIKey()
+		if err != nil {
+			t.Fatalf("generate key: %v", err)
+		}
+		if _, err := s.EnsureAdminSetup(log, seed[:len(seed)-1]+"x"); !errors.Is(err, auth.ErrMalformedKey) {
+			t.Errorf("EnsureAdminSetup with a bad checksum: err = %v, want ErrMalformedKey", err)
+		}
+		if keys, err := s.ListAPIKeys(); err != nil || len(keys) != 0 {
+			t.Errorf("keys = %d, %v, want none", len(keys), err)
+		}
+	})
+
+	t.Run("generated", func(t *testing.T) {
+		s := newAdminStorage(t)
+		plain, err := s.EnsureAdminSetup(log, "")
+		if err != nil {
+			t.Fatalf("EnsureAdminSetup: %v", err)
+		}
+		if err := auth.CheckKeyFormat(plain); err != nil || !strings.HasPrefix(plain, auth.KeyScheme) {
+			t.Errorf("generated key %q is not a %s key: %v", auth.KeyPrefix(plain), auth.KeyScheme, err)
+		}
+		if !isAdmin(t, s, plain) {
+			t.Error("the generated key is not an admin key")
+		}
+		if again, err := s.EnsureAdminSetup(log, ""); err != nil || again != "" {
+			t.Errorf("EnsureAdminSetup again = %q, %v, want no new key", auth.KeyPrefix(again), err)
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadPolicy(t *testing.T) {
+	// the shipped policy file matches the built-in policy
+	policy, err := loadPolicy(filepath.Join("..", "..", "config", "policy.yaml"))
+	if err != nil {
+		t.Fatalf("loadPolicy of config/policy.yaml: %v", err)
+	}
+	if !reflect.DeepEqual(policy, DefaultPolicy()) {
+		t.Errorf("config/policy.yaml = %+v, want DefaultPolicy %+v", policy, DefaultPolicy())
+	}
+
+	tests := []struct {
+		name string
+		yaml string
+		err  string // "" when the policy is valid
+	}{
+		{"valid", "default_role: reader\nroles:\n  reader: [task.read]\n  admin: [\"*\"]\n", ""},
+		{"no default role", "roles:\n  admin: [\"*\"]\n", ""},
+		{"no admin role", "roles:\n  reader: [task.read]\n", `role "admin" is not defined`},
+		{"unknown default role", "default_role: writer\nroles:\n  admin: [\"*\"]\n", `default_role "writer" is not defined`},
+		{"empty permission", "roles:\n  admin: [\"*\"]\n  reader: [\"\"]\n", "empty permission name"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "policy.yaml")
+		if err := os.WriteFile(path, []byte(tt.yaml), 0o600); err != nil {
+			t.Fatalf("write policy: %v", err)
+		}
+		_, err := loadPolicy(path)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: loadPolicy: %v", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+// unsetenv clears key for the test, an empty variable would still override
+// the config file
+func unsetenv(t *testing.T, key string) {
+	t.Helper()
+	t.Setenv(key, "")
+	os.Unsetenv(key)
+}
+
+func TestMustLoadAdminKey(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "admin_key")
+	if err := os.WriteFile(tokenFile, []byte("  from-file\n"), 0o600); err != nil {
+		t.Fatalf("write token file: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		yaml string
+		env  map[string]string
+		want string
+	}{
+		{"none", "", nil, ""},
+		{"config", "auth_token: from-config\n", nil, "from-config"},
+		{"env", "auth_token: from-config\n", map[string]string{"AUTH_TOKEN": "from-env"}, "from-env"},
+		{"file", "auth_token: from-config\nauth_token_file: " + tokenFile + "\n", nil, "from-file"},
+		{"file from env", "auth_token: from-config\n", map[string]string{"AUTH_TOKEN_FILE": tokenFile}, "from-file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetenv(t, "AUTH_TOKEN")
+			unsetenv(t, "AUTH_TOKEN_FILE")
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte("env: local\nstorage_path: ./storage.db\n"+tt.yaml), 0o600); err != nil {
+				t.Fatalf("write config: %v", err)
+			}
+			t.Setenv("CONF_PATH", path)
+
+			if got := MustLoad().AuthToken; got != tt.want {
+				t.Errorf("AuthToken = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
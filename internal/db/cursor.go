@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+)
+
+// Cursor points at the last task of a page: its sort key and id (the tie-breaker).
+// Value has the Go type SortValue returns for the sort field.
+type Cursor struct {
+	ID    int64 `json:"id"`
+	Value any   `json:"v"`
+}
+
+// NextCursor returns the encoded cursor pointing right after t.
+func NextCursor(t Task, f SortField) string {
+	c := Cursor{ID: t.ID}
+	if f != SortByID {
+		c.Value = t.SortValue(f)
+	}
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// DecodeCursor parses a cursor produced by NextCursor for the same sort field.
+func DecodeCursor(s string, f SortField) (Cursor, error) {
+	var raw struct {
+		ID    int64           `json:"id"`
+		Value json.RawMessage `json:"v"`
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	c := Cursor{ID: raw.ID}
+	if f == SortByID {
+		return c, nil
+	}
+
+	// give the value the type of the sort field so it can be compared
+	var target any
+	switch f {
+	case SortByTitle:
+		target = new(string)
+	case SortByCompleted:
+		target = new(bool)
+	case SortByPriority:
+		target = new(Priority)
+	case SortByDueAt, SortByCreatedAt, SortByUpdatedAt:
+		target = new(time.Time)
+	default:
+		return Cursor{}, ErrInvalidCursor
+	}
+	if len(raw.Value) == 0 || json.Unmarshal(raw.Value, target) != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	switch v := target.(type) {
+	case *string:
+		c.Value = *v
+	case *bool:
+		c.Value = *v
+	case *Priority:
+		c.Value = *v
+	case *time.Time:
+		c.Value = v.UTC()
+	}
+	return c, nil
+}
+
+// CompareToCursor orders t against the task c points at, by f and then by id.
+// The cursor must have been decoded for the same sort field.
+func CompareToCursor(t Task, f SortField, c Cursor) int {
+	other := Task{ID: c.ID}
+	switch f {
+	case SortByTitle:
+		other.Title = c.Value.(string)
+	case SortByCompleted:
+		other.Completed = c.Value.(bool)
+	case SortByPriority:
+		other.Priority = c.Value.(Priority)
+	case SortByDueAt:
+		due := c.Value.(time.Time)
+		other.DueAt = &due
+	case SortByCreatedAt:
+		other.CreatedAt = c.Value.(time.Time)
+	case SortByUpdatedAt:
+		other.UpdatedAt = c.Value.(time.Time)
+	}
+	return CompareTasks(t, other, f)
+}
+
+// CompareTasks orders tasks by f and then by id.
+func CompareTasks(a, b Task, f SortField) int {
+	var r int
+	switch f {
+	case SortByTitle:
+		r = cmp.Compare(a.Title, b.Title)
+	case SortByCompleted:
+		r = compareBool(a.Completed, b.Completed)
+	case SortByPriority:
+		r = cmp.Compare(a.Priority, b.Priority)
+	case SortByDueAt:
+		r = a.DueOrMax().Compare(b.DueOrMax())
+	case SortByCreatedAt:
+		r = a.CreatedAt.Compare(b.CreatedAt)
+	case SortByUpdatedAt:
+		r = a.UpdatedAt.Compare(b.UpdatedAt)
+	}
+	return cmp.Or(r, cmp.Compare(a.ID, b.ID))
+}
+
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case !a:
+		return -1
+	default:
+		return 1
+	}
+}
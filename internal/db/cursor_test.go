@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	due := time.Date(2030, 5, 6, 7, 8, 9, 123_000_000, time.UTC)
+	task := Task{
+		ID:        42,
+		Title:     "write tests",
+		Completed: true,
+		Priority:  PriorityHigh,
+		DueAt:     &due,
+		CreatedAt: due.Add(-time.Hour),
+		UpdatedAt: due.Add(-time.Minute),
+	}
+
+	fields := []SortField{SortByID, SortByTitle, SortByCompleted, SortByPriority, SortByDueAt, SortByCreatedAt, SortByUpdatedAt}
+	for _, f := range fields {
+		c, err := DecodeCursor(NextCursor(task, f), f)
+		if err != nil {
+			t.Errorf("DecodeCursor(%s): %v", f, err)
+			continue
+		}
+		if c.ID != task.ID {
+			t.Errorf("%s cursor id = %d, want %d", f, c.ID, task.ID)
+		}
+		if r := CompareToCursor(task, f, c); r != 0 {
+			t.Errorf("CompareToCursor(%s) of the task itself = %d, want 0", f, r)
+		}
+	}
+}
+
+func TestCursorMissingDueDate(t *testing.T) {
+	task := Task{ID: 7}
+	c, err := DecodeCursor(NextCursor(task, SortByDueAt), SortByDueAt)
+	if err != nil {
+		t.Fatalf("DecodeCursor: %v", err)
+	}
+	if !c.Value.(time.Time).Equal(MaxTime) {
+		t.Errorf("cursor value = %v, want MaxTime", c.Value)
+	}
+
+	due := time.Now()
+	if r := CompareToCursor(Task{ID: 8, DueAt: &due}, SortByDueAt, c); r >= 0 {
+		t.Errorf("a task with a due date compares %d to one without, want it first", r)
+	}
+}
+
+func TestCompareToCursor(t *testing.T) {
+	c, err := DecodeCursor(NextCursor(Task{ID: 5, Title: "m"}, SortByTitle), SortByTitle)
+	if err != nil {
+		t.Fatalf("DecodeCursor: %v", err)
+	}
+
+	tests := []struct {
+		task Task
+		want int
+	}{
+		{Task{ID: 1, Title: "a"}, -1},
+		{Task{ID: 9, Title: "a"}, -1},
+		{Task{ID: 4, Title: "m"}, -1}, // same title, the id breaks the tie
+		{Task{ID: 5, Title: "m"}, 0},
+		{Task{ID: 6, Title: "m"}, 1},
+		{Task{ID: 1, Title: "z"}, 1},
+	}
+	for _, tt := range tests {
+		if got := CompareToCursor(tt.task, SortByTitle, c); got != tt.want {
+			t.Errorf("CompareToCursor(%d %q) = %d, want %d", tt.task.ID, tt.task.Title, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor string
+		field  SortField
+	}{
+		{"not base64", "%%%", SortByID},
+		{"not json", "bm90IGpzb24", SortByID},
+		{"value of another type", NextCursor(Task{ID: 1, Completed: true}, SortByCompleted), SortByTitle},
+		{"missing value", NextCursor(Task{ID: 1}, SortByID), SortByPriority},
+		{"unknown sort field", NextCursor(Task{ID: 1, Title: "a"}, SortByTitle), SortField("owner")},
+	}
+	for _, tt := range tests {
+		if _, err := DecodeCursor(tt.cursor, tt.field); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("%s: DecodeCursor = %v, want ErrInvalidCursor", tt.name, err)
+		}
+	}
+}
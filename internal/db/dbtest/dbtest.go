@@ -0,0 +1,557 @@
+// Package dbtest holds the behaviour every storage.TaskRepository must have,
+// so each backend can run the same tests against its own storage.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"rest_api/internal/auth"
+	storage "rest_api/internal/db"
+	"rest_api/internal/db/migrate"
+)
+
+// Backend is a fresh, empty repository and two API key ids owning tasks in it.
+type Backend struct {
+	Repo  storage.TaskRepository
+	Alice int64
+	Bob   int64
+	// ForeignKeys is set when tasks can only belong to stored keys, the
+	// repository then rejects other owners
+	ForeignKeys bool
+}
+
+// NewBackend is called by every contract test for a repository of its own.
+type NewBackend func(t *testing.T) Backend
+
+// Migrate applies every migration of dialect to db.
+func Migrate(t *testing.T, db *sql.DB, dialect storage.Dialect) {
+	t.Helper()
+
+	m, err := migrate.New(db, dialect)
+	if err != nil {
+		t.Fatalf("migrate.New: %v", err)
+	}
+	if _, err := m.Up(context.Background()); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+}
+
+// AddKeys inserts two API keys into a migrated database, task owners must
+// reference one.
+func AddKeys(t *testing.T, db *sql.DB, dialect storage.Dialect) (alice, bob int64) {
+	t.Helper()
+
+	keys := auth.NewStorage(db, dialect)
+	ids := make([]int64, 2)
+	for i, owner := range []string{"alice", "bob"} {
+		hash := fmt.Sprintf("%s-%d", owner, time.Now().UnixNano())
+		id, err := keys.AddAPIKey(hash, hash[:8], owner, auth.KeyActive)
+		if err != nil {
+			t.Fatalf("add key: %v", err)
+		}
+		ids[i] = id
+	}
+	return ids[0], ids[1]
+}
+
+// RunRepositoryContract runs the shared repository tests, each on a backend
+// returned by newBackend.
+func RunRepositoryContract(t *testing.T, newBackend NewBackend) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, b Backend)
+	}{
+		{"AddAndGet", testAddAndGet},
+		{"AddTasks", testAddTasks},
+		{"Scope", testScope},
+		{"Update", testUpdate},
+		{"MarkCompleted", testMarkCompleted},
+		{"Delete", testDelete},
+		{"ListPages", testListPages},
+		{"ListPagesUpdatedDueAt", testListPagesUpdatedDueAt},
+		{"ListFilters", testListFilters},
+		{"ListInvalidCursor", testListInvalidCursor},
+		{"Search", testSearch},
+		{"SearchEscapesMarkup", testSearchEscapesMarkup},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBackend(t)
+			t.Cleanup(func() { b.Repo.Close() })
+			tt.run(t, b)
+		})
+	}
+}
+
+func addTask(t *testing.T, b Backend, owner int64, title string, p storage.Priority) storage.Task {
+	t.Helper()
+
+	task, err := b.Repo.AddTask(context.Background(), storage.Task{Title: title, Priority: p, OwnerKeyID: &owner})
+	if err != nil {
+		t.Fatalf("AddTask(%q): %v", title, err)
+	}
+	return task
+}
+
+func testAddAndGet(t *testing.T, b Backend) {
+	ctx := context.Background()
+	due := time.Date(2030, 1, 2, 3, 4, 5, 678_901_234, time.FixedZone("", 3600))
+
+	added, err := b.Repo.AddTask(ctx, storage.Task{
+		Title:       "write tests",
+		Description: "for every backend",
+		Priority:    storage.PriorityHigh,
+		DueAt:       &due,
+		OwnerKeyID:  &b.Alice,
+	})
+	if err != nil {
+		t.Fatalf("AddTask: %v", err)
+	}
+	if added.ID == 0 {
+		t.Error("AddTask returned no id")
+	}
+	if added.CreatedAt.IsZero() || !added.UpdatedAt.Equal(added.CreatedAt) {
+		t.Errorf("timestamps = %v, %v, want equal and set", added.CreatedAt, added.UpdatedAt)
+	}
+	if added.DueAt == nil || !added.DueAt.Equal(storage.NormalizeTime(due)) {
+		t.Errorf("due_at = %v, want %v", added.DueAt, storage.NormalizeTime(due))
+	}
+
+	got, err := b.Repo.GetTaskByID(ctx, storage.OwnedBy(b.Alice), added.ID)
+	if err != nil {
+		t.Fatalf("GetTaskByID: %v", err)
+	}
+	assertSameTask(t, got, added)
+}
+
+func testAddTasks(t *testing.T, b Backend) {
+	ctx := context.Background()
+	due := time.Date(2030, 1, 2, 3, 4, 5, 678_901_234, time.UTC)
+
+	added, err := b.Repo.AddTasks(ctx, []storage.Task{
+		{Title: "first", Priority: storage.PriorityLow, DueAt: &due, OwnerKeyID: &b.Alice},
+		{Title: "second", Priority: storage.PriorityHigh, OwnerKeyID: &b.Bob},
+	})
+	if err != nil {
+		t.Fatalf("AddTasks: %v", err)
+	}
+	if len(added) != 2 || added[0].Title != "first" || added[1].Title != "second" || added[0].ID == added[1].ID {
+		t.Fatalf("AddTasks = %+v, want both tasks in order with their own ids", added)
+	}
+	if added[0].DueAt == nil || !added[0].DueAt.Equal(storage.NormalizeTime(due)) || added[1].CreatedAt.IsZero() {
+		t.Errorf("AddTasks = %+v, want due date and timestamps set like AddTask", added)
+	}
+	for _, want := range added {
+		got, err := b.Repo.GetTaskByID(ctx, storage.AnyOwner(), want.ID)
+		if err != nil {
+			t.Fatalf("GetTaskByID: %v", err)
+		}
+		assertSameTask(t, got, want)
+	}
+
+	if !b.ForeignKeys {
+		return
+	}
+	// the second task has an owner that doesn't exist, the first one is not kept
+	missing := b.Alice + b.Bob + 1000
+	if _, err := b.Repo.AddTasks(ctx, []storage.Task{
+		{Title: "kept?", OwnerKeyID: &b.Alice},
+		{Title: "orphan", OwnerKeyID: &missing},
+	}); err == nil {
+		t.Fatal("AddTasks with an unknown owner succeeded")
+	}
+	page, err := b.Repo.ListTasks(ctx, storage.AnyOwner(), storage.ListOptions{})
+	if err != nil {
+		t.Fatalf("ListTasks: %v", err)
+	}
+	if page.Total != len(added) {
+		t.Errorf("%d tasks stored after a failed AddTasks, want %d", page.Total, len(added))
+	}
+}
+
+func testScope(t *testing.T, b Backend) {
+	ctx := context.Background()
+	task := addTask(t, b, b.Alice, "alice's", storage.PriorityNormal)
+	bob := storage.OwnedBy(b.Bob)
+
+	if _, err := b.Repo.GetTaskByID(ctx, bob, task.ID); !errors.Is(err, storage.ErrTaskNotFound) {
+		t.Errorf("GetTaskByID by another key: err = %v, want ErrTaskNotFound", err)
+	}
+	if _, err := b.Repo.UpdateTask(ctx, bob, storage.Task{ID: task.ID, Title: "bob's"}); !errors.Is(err, storage.ErrTaskNotFound) {
+		t.Errorf("UpdateTask by another key: err = %v, want ErrTaskNotFound", err)
+	}
+	if err := b.Repo.MarkTaskTrue(ctx, bob, task.ID); !errors.Is(err, storage.ErrTaskNotFound) {
+		t.Errorf("MarkTaskTrue by another key: err = %v, want ErrTaskNotFound", err)
+	}
+	if err := b.Repo.DeleteTaskByID(ctx, bob, task.ID); !errors.Is(err, storage.ErrTaskNotFound) {
+		t.Errorf("DeleteTaskByID by another key: err = %v, want ErrTaskNotFound", err)
+	}
+
+	page, err := b.Repo.ListTasks(ctx, bob, storage.ListOptions{})
+	if err != nil {
+		t.Fatalf("ListTasks: %v", err)
+	}
+	if page.Total != 0 || len(page.Tasks) != 0 {
+		t.Errorf("ListTasks by another key = %d tasks, total %d, want none", len(page.Tasks), page.Total)
+	}
+
+	got, err := b.Repo.GetTaskByID(ctx, storage.AnyOwner(), task.ID)
+	if err != nil {
+		t.Fatalf("GetTaskByID with AnyOwner: %v", err)
+	}
+	assertSameTask(t, got, task)
+}
+
+func testUpdate(t *testing.T, b Backend) {
+	ctx := context.Background()
+	scope := storage.OwnedBy(b.Alice)
+	task := addTask(t, b, b.Alice, "draft", storage.PriorityLow)
+	// sub-millisecond digits are dropped like on insert
+	due := time.Date(2031, 2, 3, 4, 5, 6, 123_456_789, time.FixedZone("", -7200))
+
+	task.Title = "final"
+	task.Description = "reviewed"
+	task.Priority = storage.PriorityUrgent
+	task.Completed = true
+	task.DueAt = &due
+	updated, err := b.Repo.UpdateTask(ctx, scope, task)
+	if err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+	if updated.Title != "final" || updated.Description != "reviewed" || updated.Priority != storage.PriorityUrgent || !updated.Completed {
+		t.Errorf("UpdateTask = %+v, want the new fields", updated)
+	}
+	if updated.DueAt == nil || !updated.DueAt.Equal(storage.NormalizeTime(due)) {
+		t.Errorf("due_at = %v, want %v", updated.DueAt, storage.NormalizeTime(due))
+	}
+	if !updated.CreatedAt.Equal(task.CreatedAt) || updated.UpdatedAt.Before(task.UpdatedAt) {
+		t.Errorf("timestamps = %v, %v after update of %v, %v", updated.CreatedAt, updated.UpdatedAt, task.CreatedAt, task.UpdatedAt)
+	}
+
+	got, err := b.Repo.GetTaskByID(ctx, scope, task.ID)
+	if err != nil {
+		t.Fatalf("GetTaskByID: %v", err)
+	}
+	assertSameTask(t, got, updated)
+
+	updated.DueAt = nil
+	cleared, err := b.Repo.UpdateTask(ctx, scope, updated)
+	if err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+	if cleared.DueAt != nil {
+		t.Errorf("due_at = %v after clearing it", cleared.DueAt)
+	}
+
+	if _, err := b.Repo.UpdateTask(ctx, scope, storage.Task{ID: task.ID + 1000, Title: "x"}); !errors.Is(err, storage.ErrTaskNotFound) {
+		t.Errorf("UpdateTask of a missing task: err = %v, want ErrTaskNotFound", err)
+	}
+}
+
+func testMarkCompleted(t *testing.T, b Backend) {
+	ctx := context.Background()
+	scope := storage.OwnedBy(b.Alice)
+	task := addTask(t, b, b.Alice, "toggle", storage.PriorityNormal)
+
+	for _, completed := range []bool{true, false} {
+		mark := b.Repo.MarkTaskFalse
+		if completed {
+			mark = b.Repo.MarkTaskTrue
+		}
+		if err := mark(ctx, scope, task.ID); err != nil {
+			t.Fatalf("mark completed=%v: %v", completed, err)
+		}
+		got, err := b.Repo.GetTaskByID(ctx, scope, task.ID)
+		if err != nil {
+			t.Fatalf("GetTaskByID: %v", err)
+		}
+		if got.Completed != completed {
+			t.Errorf("completed = %v, want %v", got.Completed, completed)
+		}
+	}
+
+	if err := b.Repo.MarkTaskTrue(ctx, scope, task.ID+1000); !errors.Is(err, storage.ErrTaskNotFound) {
+		t.Errorf("MarkTaskTrue of a missing task: err = %v, want ErrTaskNotFound", err)
+	}
+}
+
+func testDelete(t *testing.T, b Backend) {
+	ctx := context.Background()
+	scope := storage.OwnedBy(b.Alice)
+	task := addTask(t, b, b.Alice, "short lived", storage.PriorityNormal)
+
+	if err := b.Repo.DeleteTaskByID(ctx, scope, task.ID); err != nil {
+		t.Fatalf("DeleteTaskByID: %v", err)
+	}
+	if _, err := b.Repo.GetTaskByID(ctx, scope, task.ID); !errors.Is(err, storage.ErrTaskNotFound) {
+		t.Errorf("GetTaskByID after delete: err = %v, want ErrTaskNotFound", err)
+	}
+	if err := b.Repo.DeleteTaskByID(ctx, scope, task.ID); !errors.Is(err, storage.ErrTaskNotFound) {
+		t.Errorf("second DeleteTaskByID: err = %v, want ErrTaskNotFound", err)
+	}
+}
+
+// testListPages walks every sort order one task at a time and expects the
+// same tasks in the same order as a single page.
+func testListPages(t *testing.T, b Backend) {
+	ctx := context.Background()
+	scope := storage.OwnedBy(b.Alice)
+	priorities := []storage.Priority{storage.PriorityHigh, storage.PriorityLow, storage.PriorityHigh, storage.PriorityUrgent, storage.PriorityLow}
+	for i, p := range priorities {
+		addTask(t, b, b.Alice, fmt.Sprintf("task %c", 'e'-i), p)
+	}
+	addTask(t, b, b.Bob, "not alice's", storage.PriorityNormal)
+
+	fields := []storage.SortField{storage.SortByID, storage.SortByTitle, storage.SortByPriority, storage.SortByDueAt, storage.SortByCreatedAt}
+	for _, field := range fields {
+		for _, desc := range []bool{false, true} {
+			opts := storage.ListOptions{Sort: field, Desc: desc, Limit: storage.MaxListLimit}
+			all, err := b.Repo.ListTasks(ctx, scope, opts)
+			if err != nil {
+				t.Fatalf("ListTasks(%s, desc=%v): %v", field, desc, err)
+			}
+			if all.Total != len(priorities) || len(all.Tasks) != len(priorities) || all.NextCursor != "" {
+				t.Fatalf("ListTasks(%s, desc=%v) = %d tasks, total %d, cursor %q", field, desc, len(all.Tasks), all.Total, all.NextCursor)
+			}
+
+			opts.Limit = 2
+			var paged []storage.Task
+			for pages := 0; ; pages++ {
+				if pages > len(priorities) {
+					t.Fatalf("ListTasks(%s, desc=%v) doesn't stop paging", field, desc)
+				}
+				page, err := b.Repo.ListTasks(ctx, scope, opts)
+				if err != nil {
+					t.Fatalf("ListTasks(%s, desc=%v) page %d: %v", field, desc, pages, err)
+				}
+				if page.Total != len(priorities) {
+					t.Errorf("page %d total = %d, want %d", pages, page.Total, len(priorities))
+				}
+				paged = append(paged, page.Tasks...)
+				if page.NextCursor == "" {
+					break
+				}
+				opts.Cursor = page.NextCursor
+			}
+
+			if got, want := taskIDs(paged), taskIDs(all.Tasks); !slices.Equal(got, want) {
+				t.Errorf("ListTasks(%s, desc=%v) pages = %v, single page = %v", field, desc, got, want)
+			}
+		}
+	}
+
+	page, err := b.Repo.ListTasks(ctx, scope, storage.ListOptions{Sort: storage.SortByPriority, Desc: true, Limit: storage.MaxListLimit})
+	if err != nil {
+		t.Fatalf("ListTasks: %v", err)
+	}
+	for i := 1; i < len(page.Tasks); i++ {
+		if page.Tasks[i].Priority > page.Tasks[i-1].Priority {
+			t.Errorf("priorities = %v, want descending", taskPriorities(page.Tasks))
+			break
+		}
+	}
+}
+
+// testListPagesUpdatedDueAt pages by due dates set through UpdateTask that
+// only differ below a millisecond, the cursor keeps milliseconds only
+func testListPagesUpdatedDueAt(t *testing.T, b Backend) {
+	ctx := context.Background()
+	scope := storage.OwnedBy(b.Alice)
+	base := time.Date(2031, 2, 3, 4, 5, 6, 7_000_000, time.UTC)
+
+	var want []int64
+	for i := range 3 {
+		task := addTask(t, b, b.Alice, fmt.Sprintf("due %d", i), storage.PriorityNormal)
+		due := base.Add(time.Duration(300-100*i) * time.Microsecond)
+		task.DueAt = &due
+		if _, err := b.Repo.UpdateTask(ctx, scope, task); err != nil {
+			t.Fatalf("UpdateTask: %v", err)
+		}
+		want = append(want, task.ID)
+	}
+
+	opts := storage.ListOptions{Sort: storage.SortByDueAt, Limit: 1}
+	var got []int64
+	for pages := 0; ; pages++ {
+		if pages > len(want) {
+			t.Fatalf("ListTasks(due_at) doesn't stop paging, got %v", got)
+		}
+		page, err := b.Repo.ListTasks(ctx, scope, opts)
+		if err != nil {
+			t.Fatalf("ListTasks(due_at) page %d: %v", pages, err)
+		}
+		for _, task := range page.Tasks {
+			if !task.DueAt.Equal(base) {
+				t.Errorf("task %d due_at = %v, want %v", task.ID, task.DueAt, base)
+			}
+		}
+		got = append(got, taskIDs(page.Tasks)...)
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+	// the same due date leaves the order to the ids
+	if !slices.Equal(got, want) {
+		t.Errorf("ListTasks(due_at) pages = %v, want %v", got, want)
+	}
+}
+
+func testListFilters(t *testing.T, b Backend) {
+	ctx := context.Background()
+	scope := storage.OwnedBy(b.Alice)
+	now := storage.Now()
+	soon, later := now.Add(time.Hour), now.Add(48*time.Hour)
+
+	urgent := addTask(t, b, b.Alice, "urgent", storage.PriorityUrgent)
+	low := addTask(t, b, b.Alice, "low", storage.PriorityLow)
+	done := addTask(t, b, b.Alice, "done", storage.PriorityNormal)
+	if err := b.Repo.MarkTaskTrue(ctx, scope, done.ID); err != nil {
+		t.Fatalf("MarkTaskTrue: %v", err)
+	}
+	urgent.DueAt = &soon
+	if _, err := b.Repo.UpdateTask(ctx, scope, urgent); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+	low.DueAt = &later
+	if _, err := b.Repo.UpdateTask(ctx, scope, low); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+
+	completed, open := true, false
+	dueBefore := now.Add(24 * time.Hour)
+	tests := []struct {
+		name string
+		opts storage.ListOptions
+		want []int64
+	}{
+		{"completed", storage.ListOptions{Completed: &completed}, []int64{done.ID}},
+		{"open", storage.ListOptions{Completed: &open}, []int64{urgent.ID, low.ID}},
+		{"priorities", storage.ListOptions{Priorities: []storage.Priority{storage.PriorityLow, storage.PriorityNormal}}, []int64{low.ID, done.ID}},
+		{"due before", storage.ListOptions{DueBefore: &dueBefore}, []int64{urgent.ID}},
+		{"due after", storage.ListOptions{DueAfter: &dueBefore}, []int64{low.ID}},
+	}
+	for _, tt := range tests {
+		page, err := b.Repo.ListTasks(ctx, scope, tt.opts)
+		if err != nil {
+			t.Fatalf("ListTasks(%s): %v", tt.name, err)
+		}
+		if got := taskIDs(page.Tasks); !slices.Equal(got, tt.want) || page.Total != len(tt.want) {
+			t.Errorf("ListTasks(%s) = %v, total %d, want %v", tt.name, got, page.Total, tt.want)
+		}
+	}
+}
+
+func testListInvalidCursor(t *testing.T, b Backend) {
+	addTask(t, b, b.Alice, "any", storage.PriorityNormal)
+
+	// not base64, not JSON, and a title cursor holding a bool
+	for _, cursor := range []string{"not base64!", "bm90IGpzb24", "eyJpZCI6MSwidiI6dHJ1ZX0"} {
+		_, err := b.Repo.ListTasks(context.Background(), storage.OwnedBy(b.Alice), storage.ListOptions{Cursor: cursor, Sort: storage.SortByTitle})
+		if !errors.Is(err, storage.ErrInvalidCursor) {
+			t.Errorf("ListTasks(cursor %q): err = %v, want ErrInvalidCursor", cursor, err)
+		}
+	}
+}
+
+func testSearch(t *testing.T, b Backend) {
+	ctx := context.Background()
+	addTask(t, b, b.Alice, "buy milk", storage.PriorityNormal)
+	addTask(t, b, b.Alice, "milk the cow", storage.PriorityNormal)
+	addTask(t, b, b.Alice, "bake bread", storage.PriorityNormal)
+	addTask(t, b, b.Bob, "spilt milk", storage.PriorityNormal)
+
+	results, err := b.Repo.SearchTasks(ctx, storage.OwnedBy(b.Alice), "milk", 0)
+	if err != nil {
+		t.Fatalf("SearchTasks: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("SearchTasks = %d results, want 2", len(results))
+	}
+	for _, r := range results {
+		if !strings.Contains(r.Title, "milk") || *r.OwnerKeyID != b.Alice {
+			t.Errorf("SearchTasks returned %q of key %d", r.Title, *r.OwnerKeyID)
+		}
+		if !strings.Contains(r.Snippet, storage.HighlightStart+"milk"+storage.HighlightEnd) {
+			t.Errorf("snippet = %q, want milk highlighted", r.Snippet)
+		}
+	}
+
+	results, err = b.Repo.SearchTasks(ctx, storage.AnyOwner(), "milk", 0)
+	if err != nil {
+		t.Fatalf("SearchTasks: %v", err)
+	}
+	if len(results) != 3 {
+		t.Errorf("SearchTasks with AnyOwner = %d results, want 3", len(results))
+	}
+
+	results, err = b.Repo.SearchTasks(ctx, storage.AnyOwner(), "milk", 1)
+	if err != nil {
+		t.Fatalf("SearchTasks: %v", err)
+	}
+	if len(results) != 1 {
+		t.Errorf("SearchTasks with limit 1 = %d results", len(results))
+	}
+
+	results, err = b.Repo.SearchTasks(ctx, storage.AnyOwner(), "cheese", 0)
+	if err != nil {
+		t.Fatalf("SearchTasks: %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("SearchTasks(cheese) = %d results, want none", len(results))
+	}
+}
+
+func testSearchEscapesMarkup(t *testing.T, b Backend) {
+	addTask(t, b, b.Alice, `<img src=x onerror="alert(1)"> milk & honey`, storage.PriorityNormal)
+
+	results, err := b.Repo.SearchTasks(context.Background(), storage.AnyOwner(), "milk", 0)
+	if err != nil {
+		t.Fatalf("SearchTasks: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("SearchTasks = %d results, want 1", len(results))
+	}
+	snippet := results[0].Snippet
+	if strings.Contains(snippet, "<img") || strings.Contains(snippet, `"`) || strings.Contains(snippet, "& ") {
+		t.Errorf("snippet = %q, want the title escaped", snippet)
+	}
+	if !strings.Contains(snippet, "&lt;img") || !strings.Contains(snippet, storage.HighlightStart+"milk"+storage.HighlightEnd) {
+		t.Errorf("snippet = %q, want the escaped title with milk highlighted", snippet)
+	}
+}
+
+func assertSameTask(t *testing.T, got, want storage.Task) {
+	t.Helper()
+
+	sameDue := (got.DueAt == nil) == (want.DueAt == nil) && (got.DueAt == nil || got.DueAt.Equal(*want.DueAt))
+	sameOwner := (got.OwnerKeyID == nil) == (want.OwnerKeyID == nil) && (got.OwnerKeyID == nil || *got.OwnerKeyID == *want.OwnerKeyID)
+	if got.ID != want.ID || got.Title != want.Title || got.Description != want.Description ||
+		got.Completed != want.Completed || got.Priority != want.Priority || !sameDue || !sameOwner ||
+		!got.CreatedAt.Equal(want.CreatedAt) || !got.UpdatedAt.Equal(want.UpdatedAt) {
+		t.Errorf("task = %+v, want %+v", got, want)
+	}
+}
+
+func taskIDs(tasks []storage.Task) []int64 {
+	ids := make([]int64, len(tasks))
+	for i, t := range tasks {
+		ids[i] = t.ID
+	}
+	return ids
+}
+
+func taskPriorities(tasks []storage.Task) []storage.Priority {
+	ps := make([]storage.Priority, len(tasks))
+	for i, t := range tasks {
+		ps[i] = t.Priority
+	}
+	return ps
+}
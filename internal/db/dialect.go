@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Dialect is the SQL flavour of a database shared by the storages.
+type Dialect string
+
+const (
+	DialectSQLite   Dialect = "sqlite"
+	DialectPostgres Dialect = "postgres"
+)
+
+// Rebind rewrites ? placeholders into the form the dialect expects.
+// Queries are written with ? and must not contain a literal question mark.
+func (d Dialect) Rebind(query string) string {
+	if d != DialectPostgres {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	for _, r := range query {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+		n++
+		b.WriteByte('$')
+		b.WriteString(strconv.Itoa(n))
+	}
+	return b.String()
+}
@@ -0,0 +1,244 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	storage "rest_api/internal/db"
+)
+
+var _ storage.TaskRepository = (*Storage)(nil)
+
+// Storage keeps tasks in process memory. Everything is lost on restart,
+// it's meant for tests and local experiments.
+type Storage struct {
+	mu     sync.RWMutex
+	tasks  map[int64]storage.Task
+	nextID int64
+}
+
+// New creates an empty in-memory storage.
+func New() *Storage {
+	return &Storage{
+		tasks:  make(map[int64]storage.Task),
+		nextID: 1,
+	}
+}
+
+// Close is a no-op, it's there to satisfy storage.TaskRepository
+func (s *Storage) Close() error {
+	return nil
+}
+
+// AddTask stores a new task and returns it with ID and timestamps set
+func (s *Storage) AddTask(_ context.Context, t storage.Task) (storage.Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addTask(t), nil
+}
+
+// AddTasks stores tasks and returns them with IDs and timestamps set, it
+// can't fail halfway
+func (s *Storage) AddTasks(_ context.Context, tasks []storage.Task) ([]storage.Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	added := make([]storage.Task, 0, len(tasks))
+	for _, t := range tasks {
+		added = append(added, s.addTask(t))
+	}
+	return added, nil
+}
+
+// addTask stores t, the caller holds the write lock
+func (s *Storage) addTask(t storage.Task) storage.Task {
+	t.ID = s.nextID
+	s.nextID++
+	t.CreatedAt = storage.Now()
+	t.UpdatedAt = t.CreatedAt
+	if t.DueAt != nil {
+		due := storage.NormalizeTime(*t.DueAt)
+		t.DueAt = &due
+	}
+	s.tasks[t.ID] = t
+	return t
+}
+
+// GetTaskByID returns the task by ID or storage.ErrTaskNotFound
+func (s *Storage) GetTaskByID(_ context.Context, scope storage.Scope, id int64) (storage.Task, error) {
+	const op = "storage.memory.GetTaskByID"
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.tasks[id]
+	if !ok || !scope.Allows(t) {
+		return storage.Task{}, fmt.Errorf("%s: id=%d: %w", op, id, storage.ErrTaskNotFound)
+	}
+	return t, nil
+}
+
+// UpdateTask replaces the editable fields of the task t.ID and returns the stored task
+func (s *Storage) UpdateTask(_ context.Context, scope storage.Scope, t storage.Task) (storage.Task, error) {
+	const op = "storage.memory.UpdateTask"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.tasks[t.ID]
+	if !ok || !scope.Allows(current) {
+		return storage.Task{}, fmt.Errorf("%s: id=%d: %w", op, t.ID, storage.ErrTaskNotFound)
+	}
+
+	current.Title = t.Title
+	current.Description = t.Description
+	current.Completed = t.Completed
+	current.Priority = t.Priority
+	current.DueAt = nil
+	if t.DueAt != nil {
+		due := storage.NormalizeTime(*t.DueAt)
+		current.DueAt = &due
+	}
+	current.UpdatedAt = storage.Now()
+	s.tasks[t.ID] = current
+	return current, nil
+}
+
+func (s *Storage) DeleteTaskByID(_ context.Context, scope storage.Scope, id int64) error {
+	const op = "storage.memory.DeleteTaskByID"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tasks[id]
+	if !ok || !scope.Allows(t) {
+		return fmt.Errorf("%s: id=%d: %w", op, id, storage.ErrTaskNotFound)
+	}
+	delete(s.tasks, id)
+	return nil
+}
+
+func (s *Storage) MarkTaskTrue(_ context.Context, scope storage.Scope, id int64) error {
+	return s.setCompleted("storage.memory.MarkTaskTrue", scope, id, true)
+}
+
+func (s *Storage) MarkTaskFalse(_ context.Context, scope storage.Scope, id int64) error {
+	return s.setCompleted("storage.memory.MarkTaskFalse", scope, id, false)
+}
+
+func (s *Storage) setCompleted(op string, scope storage.Scope, id int64, completed bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tasks[id]
+	if !ok || !scope.Allows(t) {
+		return fmt.Errorf("%s: id=%d: %w", op, id, storage.ErrTaskNotFound)
+	}
+	t.Completed = completed
+	t.UpdatedAt = storage.Now()
+	s.tasks[id] = t
+	return nil
+}
+
+// ListTasks returns one page of tasks matching opts, ordered the same way
+// the SQL backends order them.
+func (s *Storage) ListTasks(_ context.Context, scope storage.Scope, opts storage.ListOptions) (storage.TaskPage, error) {
+	const op = "storage.memory.ListTasks"
+
+	opts = opts.Normalize()
+
+	var page storage.TaskPage
+	var after *storage.Cursor
+	if opts.Cursor != "" {
+		c, err := storage.DecodeCursor(opts.Cursor, opts.Sort)
+		if err != nil {
+			return page, fmt.Errorf("%s: %w", op, err)
+		}
+		after = &c
+	}
+
+	s.mu.RLock()
+	matched := make([]storage.Task, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		if scope.Allows(t) && opts.Match(t) {
+			matched = append(matched, t)
+		}
+	}
+	s.mu.RUnlock()
+
+	page.Total = len(matched)
+
+	slices.SortFunc(matched, func(a, b storage.Task) int {
+		r := storage.CompareTasks(a, b, opts.Sort)
+		if opts.Desc {
+			r = -r
+		}
+		return r
+	})
+
+	page.Tasks = make([]storage.Task, 0, opts.Limit)
+	for _, t := range matched {
+		if after != nil {
+			r := storage.CompareToCursor(t, opts.Sort, *after)
+			if (!opts.Desc && r <= 0) || (opts.Desc && r >= 0) {
+				continue
+			}
+		}
+		if len(page.Tasks) == opts.Limit {
+			page.NextCursor = storage.NextCursor(page.Tasks[opts.Limit-1], opts.Sort)
+			break
+		}
+		page.Tasks = append(page.Tasks, t)
+	}
+
+	return page, nil
+}
+
+// SearchTasks returns tasks whose title contains query (case-insensitive),
+// earliest matches first.
+func (s *Storage) SearchTasks(_ context.Context, scope storage.Scope, query string, limit int) ([]storage.SearchResult, error) {
+	if limit <= 0 {
+		limit = storage.DefaultSearchLimit
+	}
+	if limit > storage.MaxSearchLimit {
+		limit = storage.MaxSearchLimit
+	}
+
+	needle := strings.ToLower(query)
+
+	s.mu.RLock()
+	results := []storage.SearchResult{}
+	for _, t := range s.tasks {
+		if !scope.Allows(t) {
+			continue
+		}
+		i := strings.Index(strings.ToLower(t.Title), needle)
+		if i < 0 {
+			continue
+		}
+		results = append(results, storage.SearchResult{
+			Task:    t,
+			Snippet: storage.Highlight(t.Title, query),
+			Rank:    float64(i + 1),
+		})
+	}
+	s.mu.RUnlock()
+
+	slices.SortFunc(results, func(a, b storage.SearchResult) int {
+		return cmp.Or(
+			cmp.Compare(a.Rank, b.Rank),
+			cmp.Compare(len(a.Title), len(b.Title)),
+			cmp.Compare(a.ID, b.ID),
+		)
+	})
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
@@ -0,0 +1,14 @@
+package memory_test
+
+import (
+	"testing"
+
+	"rest_api/internal/db/dbtest"
+	"rest_api/internal/db/memory"
+)
+
+func TestRepositoryContract(t *testing.T) {
+	dbtest.RunRepositoryContract(t, func(t *testing.T) dbtest.Backend {
+		return dbtest.Backend{Repo: memory.New(), Alice: 1, Bob: 2}
+	})
+}
@@ -0,0 +1,386 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	storage "rest_api/internal/db"
+)
+
+//go:embed sql
+var files embed.FS
+
+// Migration is one numbered schema change with its up and down scripts.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status describes whether a migration has been applied.
+type Status struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+type Migrator struct {
+	db         *sql.DB
+	dialect    storage.Dialect
+	migrations []Migration // sorted by version
+}
+
+// New loads the migrations embedded for the dialect.
+// Files are named <version>_<name>.up.sql and <version>_<name>.down.sql.
+func New(db *sql.DB, dialect storage.Dialect) (*Migrator, error) {
+	const op = "migrate.New"
+
+	migrations, err := load(dialect)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return &Migrator{db: db, dialect: dialect, migrations: migrations}, nil
+}
+
+func load(dialect storage.Dialect) ([]Migration, error) {
+	dir := path.Join("sql", string(dialect))
+	entries, err := fs.ReadDir(files, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for dialect %q: %w", dialect, err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		name := e.Name()
+		base, direction, ok := strings.Cut(strings.TrimSuffix(name, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("unexpected migration file %s", name)
+		}
+		num, title, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(num)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("bad version in migration file %s", name)
+		}
+
+		body, err := fs.ReadFile(files, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: title}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d must have both up and down files", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// querier is a *sql.DB or the *sql.Conn of a locked run
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func ensureTable(ctx context.Context, q querier) error {
+	_, err := q.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations(
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	return nil
+}
+
+func applied(ctx context.Context, q querier) (map[int]time.Time, error) {
+	if err := ensureTable(ctx, q); err != nil {
+		return nil, err
+	}
+
+	rows, err := q.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// Status lists every known migration and whether it has been applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	const op = "migrate.Status"
+
+	applied, err := applied(ctx, m.db)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, mg := range m.migrations {
+		st := Status{Version: mg.Version, Name: mg.Name}
+		if at, ok := applied[mg.Version]; ok {
+			st.Applied = true
+			st.AppliedAt = &at
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses, nil
+}
+
+// Version returns the highest applied migration, 0 for an empty database.
+// Unlike the other methods it never creates schema_migrations, probes can
+// call it with a read-only database role.
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	const op = "migrate.Version"
+
+	exists := "SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations')"
+	if m.dialect == storage.DialectPostgres {
+		exists = "SELECT to_regclass('schema_migrations') IS NOT NULL"
+	}
+	var ok bool
+	if err := m.db.QueryRowContext(ctx, exists).Scan(&ok); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	if !ok {
+		return 0, nil
+	}
+
+	var version int
+	if err := m.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return version, nil
+}
+
+// Latest returns the highest migration known to this binary.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Pending returns migrations that haven't been applied yet.
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	const op = "migrate.Pending"
+
+	pending, err := m.pending(ctx, m.db)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return pending, nil
+}
+
+func (m *Migrator) pending(ctx context.Context, q querier) ([]Migration, error) {
+	applied, err := applied(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, mg := range m.migrations {
+		if _, ok := applied[mg.Version]; !ok {
+			pending = append(pending, mg)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies all pending migrations in order, each in its own transaction,
+// and returns the ones it applied. Concurrent runs (several replicas starting
+// at once) are serialized, a migration is applied only once.
+func (m *Migrator) Up(ctx context.Context) (done []Migration, err error) {
+	const op = "migrate.Up"
+
+	conn, err := m.lock(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() {
+		if uerr := m.unlock(conn); uerr != nil && err == nil {
+			err = fmt.Errorf("%s: %w", op, uerr)
+		}
+	}()
+
+	// read after taking the lock, a run that held it before may have applied some
+	pending, err := m.pending(ctx, conn)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, mg := range pending {
+		changed, err := m.step(ctx, conn, mg, true)
+		if err != nil {
+			return done, fmt.Errorf("%s: migration %d_%s: %w", op, mg.Version, mg.Name, err)
+		}
+		if changed {
+			done = append(done, mg)
+		}
+	}
+	return done, nil
+}
+
+// Down reverts the last steps applied migrations, newest first,
+// and returns the ones it reverted.
+func (m *Migrator) Down(ctx context.Context, steps int) (done []Migration, err error) {
+	const op = "migrate.Down"
+
+	if steps <= 0 {
+		return nil, fmt.Errorf("%s: steps must be positive", op)
+	}
+
+	conn, err := m.lock(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() {
+		if uerr := m.unlock(conn); uerr != nil && err == nil {
+			err = fmt.Errorf("%s: %w", op, uerr)
+		}
+	}()
+
+	applied, err := applied(ctx, conn)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		mg := m.migrations[i]
+		if _, ok := applied[mg.Version]; !ok {
+			continue
+		}
+		changed, err := m.step(ctx, conn, mg, false)
+		if err != nil {
+			return done, fmt.Errorf("%s: migration %d_%s: %w", op, mg.Version, mg.Name, err)
+		}
+		if changed {
+			done = append(done, mg)
+		}
+	}
+	if len(done) == 0 && len(applied) > 0 {
+		return nil, fmt.Errorf("%s: applied migrations are unknown to this build", op)
+	}
+	return done, nil
+}
+
+// lockID names the PostgreSQL advisory lock held by a run, the application
+// takes no other advisory locks
+const lockID = 0x746f646f // "todo"
+
+// lock returns a connection of its own for a run. On PostgreSQL it holds an
+// advisory lock until unlock, so concurrent runs wait for each other. SQLite
+// has no such lock, every step takes the write lock of the database instead.
+func (m *Migrator) lock(ctx context.Context) (*sql.Conn, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if m.dialect == storage.DialectPostgres {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockID); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to lock migrations: %w", err)
+		}
+	}
+	if err := ensureTable(ctx, conn); err != nil {
+		m.unlock(conn)
+		return nil, err
+	}
+	return conn, nil
+}
+
+// unlock releases the lock taken by lock and returns conn to the pool
+func (m *Migrator) unlock(conn *sql.Conn) error {
+	defer conn.Close()
+
+	if m.dialect != storage.DialectPostgres {
+		return nil
+	}
+	if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockID); err != nil {
+		// the lock lives as long as the session, drop the connection rather
+		// than hand it back to the pool still locked
+		conn.Raw(func(any) error { return driver.ErrBadConn })
+		return fmt.Errorf("failed to unlock migrations: %w", err)
+	}
+	return nil
+}
+
+// step applies (up) or reverts one migration and its bookkeeping atomically
+// on conn. It reports false if another run did it in the meantime.
+func (m *Migrator) step(ctx context.Context, conn *sql.Conn, mg Migration, up bool) (changed bool, err error) {
+	// BEGIN IMMEDIATE takes the SQLite write lock before the check below,
+	// two processes can't both find the migration pending
+	begin := "BEGIN"
+	if m.dialect == storage.DialectSQLite {
+		begin = "BEGIN IMMEDIATE"
+	}
+	if _, err := conn.ExecContext(ctx, begin); err != nil {
+		return false, err
+	}
+	defer func() {
+		if err != nil {
+			conn.ExecContext(context.WithoutCancel(ctx), "ROLLBACK")
+		}
+	}()
+
+	var n int
+	err = conn.QueryRowContext(ctx, m.dialect.Rebind("SELECT COUNT(*) FROM schema_migrations WHERE version = ?"), mg.Version).Scan(&n)
+	if err != nil {
+		return false, err
+	}
+	if (n > 0) == up {
+		_, err = conn.ExecContext(ctx, "COMMIT")
+		return false, err
+	}
+
+	script, bookkeeping, args := mg.Up, "INSERT INTO schema_migrations(version, name) VALUES (?, ?)", []any{mg.Version, mg.Name}
+	if !up {
+		script, bookkeeping, args = mg.Down, "DELETE FROM schema_migrations WHERE version = ?", []any{mg.Version}
+	}
+	if _, err = conn.ExecContext(ctx, script); err != nil {
+		return false, err
+	}
+	if _, err = conn.ExecContext(ctx, m.dialect.Rebind(bookkeeping), args...); err != nil {
+		return false, err
+	}
+	if _, err = conn.ExecContext(ctx, "COMMIT"); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,143 @@
+package migrate_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	storage "rest_api/internal/db"
+	"rest_api/internal/db/migrate"
+	"rest_api/internal/db/postgres"
+	"rest_api/internal/db/sqlite"
+)
+
+// runs is how many processes migrate the same database at once
+const runs = 4
+
+func TestConcurrentUpSQLite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.db")
+	testConcurrentUp(t, storage.DialectSQLite, func() (*sql.DB, error) { return sqlite.Open(path) })
+}
+
+// TestConcurrentUpPostgres drops every table of the database, see
+// TestRepositoryContract in internal/db/postgres
+func TestConcurrentUpPostgres(t *testing.T) {
+	dsn := os.Getenv("TODO_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TODO_TEST_POSTGRES_DSN is not set")
+	}
+	db, err := postgres.Open(dsn)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	_, err = db.Exec("DROP SCHEMA public CASCADE; CREATE SCHEMA public")
+	db.Close()
+	if err != nil {
+		t.Fatalf("reset schema: %v", err)
+	}
+
+	testConcurrentUp(t, storage.DialectPostgres, func() (*sql.DB, error) { return postgres.Open(dsn) })
+}
+
+// testConcurrentUp migrates an empty database from several connection pools
+// at once, every migration must be applied exactly once
+func testConcurrentUp(t *testing.T, dialect storage.Dialect, open func() (*sql.DB, error)) {
+	ctx := context.Background()
+
+	migrators := make([]*migrate.Migrator, runs)
+	for i := range migrators {
+		db, err := open()
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		if migrators[i], err = migrate.New(db, dialect); err != nil {
+			t.Fatalf("migrate.New: %v", err)
+		}
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		applied = map[int]int{}
+	)
+	for _, m := range migrators {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			done, err := m.Up(ctx)
+			if err != nil {
+				t.Errorf("Up: %v", err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, mg := range done {
+				applied[mg.Version]++
+			}
+		}()
+	}
+	wg.Wait()
+
+	m := migrators[0]
+	for v := 1; v <= m.Latest(); v++ {
+		if applied[v] != 1 {
+			t.Errorf("migration %d applied %d times", v, applied[v])
+		}
+	}
+	if version, err := m.Version(ctx); err != nil || version != m.Latest() {
+		t.Errorf("Version = %d, %v, want %d", version, err, m.Latest())
+	}
+
+	// every migration reverts and applies again
+	done, err := m.Down(ctx, m.Latest())
+	if err != nil || len(done) != m.Latest() {
+		t.Fatalf("Down = %d migrations, %v, want %d", len(done), err, m.Latest())
+	}
+	if done, err = m.Up(ctx); err != nil || len(done) != m.Latest() {
+		t.Fatalf("Up = %d migrations, %v, want %d", len(done), err, m.Latest())
+	}
+	if pending, err := m.Pending(ctx); err != nil || len(pending) != 0 {
+		t.Errorf("Pending = %d, %v after Up", len(pending), err)
+	}
+}
+
+func TestVersionIsReadOnly(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.db")
+	db, err := sqlite.Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	m, err := migrate.New(db, storage.DialectSQLite)
+	if err != nil {
+		t.Fatalf("migrate.New: %v", err)
+	}
+
+	if v, err := m.Version(ctx); err != nil || v != 0 {
+		t.Fatalf("Version of an empty database = %d, %v, want 0", v, err)
+	}
+	var tables int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'schema_migrations'").Scan(&tables); err != nil || tables != 0 {
+		t.Fatalf("schema_migrations tables after Version = %d, %v, want none", tables, err)
+	}
+
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	ro, err := sqlite.Open("file:" + path + "?mode=ro")
+	if err != nil {
+		t.Fatalf("open read-only: %v", err)
+	}
+	defer ro.Close()
+	mro, err := migrate.New(ro, storage.DialectSQLite)
+	if err != nil {
+		t.Fatalf("migrate.New: %v", err)
+	}
+	if v, err := mro.Version(ctx); err != nil || v != m.Latest() {
+		t.Errorf("Version on a read-only connection = %d, %v, want %d", v, err, m.Latest())
+	}
+}
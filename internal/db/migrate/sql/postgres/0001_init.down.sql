@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS api_key_permissions;
+DROP TABLE IF EXISTS permissions;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS todo;
@@ -0,0 +1,29 @@
+-- IF NOT EXISTS lets databases created before migrations adopt this baseline
+-- search is a generated column, so the full-text index can't go out of sync
+CREATE TABLE IF NOT EXISTS todo(
+	id BIGSERIAL PRIMARY KEY,
+	task TEXT NOT NULL,
+	completed BOOLEAN NOT NULL DEFAULT FALSE,
+	search TSVECTOR GENERATED ALWAYS AS (to_tsvector('simple', task)) STORED
+);
+
+CREATE INDEX IF NOT EXISTS idx_task ON todo(task);
+CREATE INDEX IF NOT EXISTS idx_task_search ON todo USING GIN(search);
+
+CREATE TABLE IF NOT EXISTS api_keys(
+	id BIGSERIAL PRIMARY KEY,
+	key_hash TEXT NOT NULL UNIQUE,
+	owner TEXT NOT NULL,
+	revoked BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE IF NOT EXISTS permissions(
+	id BIGSERIAL PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS api_key_permissions(
+	api_key_id BIGINT REFERENCES api_keys(id),
+	permission_id BIGINT REFERENCES permissions(id),
+	PRIMARY KEY (api_key_id, permission_id)
+);
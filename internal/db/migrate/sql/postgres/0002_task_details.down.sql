@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_todo_created_at;
+DROP INDEX IF EXISTS idx_todo_priority;
+DROP INDEX IF EXISTS idx_todo_due_at;
+
+ALTER TABLE todo DROP COLUMN updated_at;
+ALTER TABLE todo DROP COLUMN created_at;
+ALTER TABLE todo DROP COLUMN priority;
+ALTER TABLE todo DROP COLUMN due_at;
+ALTER TABLE todo DROP COLUMN description;
@@ -0,0 +1,10 @@
+-- priority: 0 low, 1 normal, 2 high, 3 urgent
+ALTER TABLE todo ADD COLUMN description TEXT NOT NULL DEFAULT '';
+ALTER TABLE todo ADD COLUMN due_at TIMESTAMPTZ;
+ALTER TABLE todo ADD COLUMN priority SMALLINT NOT NULL DEFAULT 1;
+ALTER TABLE todo ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE todo ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE INDEX IF NOT EXISTS idx_todo_due_at ON todo(due_at);
+CREATE INDEX IF NOT EXISTS idx_todo_priority ON todo(priority);
+CREATE INDEX IF NOT EXISTS idx_todo_created_at ON todo(created_at);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_api_keys_previous_key_hash;
+
+ALTER TABLE api_keys DROP COLUMN previous_key_expires_at;
+ALTER TABLE api_keys DROP COLUMN previous_key_hash;
+ALTER TABLE api_keys DROP COLUMN expires_at;
@@ -0,0 +1,7 @@
+-- previous_key_hash is the secret replaced by the last rotation, it stays
+-- valid until previous_key_expires_at
+ALTER TABLE api_keys ADD COLUMN expires_at TIMESTAMPTZ;
+ALTER TABLE api_keys ADD COLUMN previous_key_hash TEXT;
+ALTER TABLE api_keys ADD COLUMN previous_key_expires_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_previous_key_hash ON api_keys(previous_key_hash);
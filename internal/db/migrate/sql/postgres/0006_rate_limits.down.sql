@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_todo_owner_created_at;
+
+ALTER TABLE roles DROP COLUMN daily_tasks;
+ALTER TABLE roles DROP COLUMN burst;
+ALTER TABLE roles DROP COLUMN requests_per_minute;
+
+ALTER TABLE api_keys DROP COLUMN daily_tasks;
+ALTER TABLE api_keys DROP COLUMN burst;
+ALTER TABLE api_keys DROP COLUMN requests_per_minute;
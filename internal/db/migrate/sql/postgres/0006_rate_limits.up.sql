@@ -0,0 +1,11 @@
+-- per key and per role overrides of the configured rate limit and daily
+-- task quota: NULL falls back to the roles, then to the config, 0 is unlimited
+ALTER TABLE api_keys ADD COLUMN requests_per_minute INTEGER;
+ALTER TABLE api_keys ADD COLUMN burst INTEGER;
+ALTER TABLE api_keys ADD COLUMN daily_tasks INTEGER;
+
+ALTER TABLE roles ADD COLUMN requests_per_minute INTEGER;
+ALTER TABLE roles ADD COLUMN burst INTEGER;
+ALTER TABLE roles ADD COLUMN daily_tasks INTEGER;
+
+CREATE INDEX IF NOT EXISTS idx_todo_owner_created_at ON todo(owner_key_id, created_at);
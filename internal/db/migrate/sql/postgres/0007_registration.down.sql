@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS invites;
+
+ALTER TABLE api_keys DROP COLUMN status;
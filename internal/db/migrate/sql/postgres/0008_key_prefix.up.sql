@@ -0,0 +1,5 @@
+-- public part of a key shown in logs and listings: todo_live_<id> for
+-- structured keys, the first 8 hex chars of the hash for older keys
+ALTER TABLE api_keys ADD COLUMN key_prefix TEXT NOT NULL DEFAULT '';
+
+UPDATE api_keys SET key_prefix = substr(key_hash, 1, 8);
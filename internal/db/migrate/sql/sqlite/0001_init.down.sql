@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS api_key_permissions;
+DROP TABLE IF EXISTS permissions;
+DROP TABLE IF EXISTS api_keys;
+DROP INDEX IF EXISTS idx_task;
+DROP TABLE IF EXISTS todo;
@@ -0,0 +1,28 @@
+-- IF NOT EXISTS lets databases created before migrations adopt this baseline
+CREATE TABLE IF NOT EXISTS todo(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	task TEXT NOT NULL,
+	completed BOOLEAN DEFAULT FALSE
+);
+
+CREATE INDEX IF NOT EXISTS idx_task ON todo(task);
+
+CREATE TABLE IF NOT EXISTS api_keys(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	key_hash TEXT NOT NULL UNIQUE,
+	owner TEXT NOT NULL,
+	revoked BOOLEAN DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS permissions(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS api_key_permissions(
+	api_key_id INTEGER,
+	permission_id INTEGER,
+	PRIMARY KEY (api_key_id, permission_id),
+	FOREIGN KEY (api_key_id) REFERENCES api_keys(id),
+	FOREIGN KEY (permission_id) REFERENCES permissions(id)
+);
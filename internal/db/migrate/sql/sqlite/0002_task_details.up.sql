@@ -0,0 +1,16 @@
+-- timestamps are RFC 3339 strings in UTC with millisecond precision,
+-- so they sort correctly as text
+-- priority: 0 low, 1 normal, 2 high, 3 urgent
+ALTER TABLE todo ADD COLUMN description TEXT NOT NULL DEFAULT '';
+ALTER TABLE todo ADD COLUMN due_at TEXT;
+ALTER TABLE todo ADD COLUMN priority INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE todo ADD COLUMN created_at TEXT NOT NULL DEFAULT '';
+ALTER TABLE todo ADD COLUMN updated_at TEXT NOT NULL DEFAULT '';
+
+UPDATE todo SET
+	created_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now'),
+	updated_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now');
+
+CREATE INDEX IF NOT EXISTS idx_todo_due_at ON todo(due_at);
+CREATE INDEX IF NOT EXISTS idx_todo_priority ON todo(priority);
+CREATE INDEX IF NOT EXISTS idx_todo_created_at ON todo(created_at);
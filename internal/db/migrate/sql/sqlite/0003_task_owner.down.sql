@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_todo_owner_key_id;
+
+ALTER TABLE todo DROP COLUMN owner_key_id;
@@ -0,0 +1,4 @@
+-- tasks created before ownership have no owner and are visible to admin keys only
+ALTER TABLE todo ADD COLUMN owner_key_id INTEGER REFERENCES api_keys(id);
+
+CREATE INDEX IF NOT EXISTS idx_todo_owner_key_id ON todo(owner_key_id);
@@ -0,0 +1,9 @@
+-- declared as TIMESTAMP so the driver reads and writes time.Time values,
+-- the auth storage shares its queries with postgres
+-- previous_key_hash is the secret replaced by the last rotation, it stays
+-- valid until previous_key_expires_at
+ALTER TABLE api_keys ADD COLUMN expires_at TIMESTAMP;
+ALTER TABLE api_keys ADD COLUMN previous_key_hash TEXT;
+ALTER TABLE api_keys ADD COLUMN previous_key_expires_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_previous_key_hash ON api_keys(previous_key_hash);
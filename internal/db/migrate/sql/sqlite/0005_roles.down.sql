@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS api_key_roles;
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS roles;
+
+DELETE FROM api_key_permissions WHERE permission_id IN (SELECT id FROM permissions WHERE name = 'task.read');
+DELETE FROM permissions WHERE name = 'task.read';
@@ -0,0 +1,32 @@
+-- roles bundle permissions, a key holds the permissions granted to it
+-- directly plus those of its roles
+CREATE TABLE IF NOT EXISTS roles(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions(
+	role_id INTEGER NOT NULL REFERENCES roles(id),
+	permission_id INTEGER NOT NULL REFERENCES permissions(id),
+	PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS api_key_roles(
+	api_key_id INTEGER NOT NULL REFERENCES api_keys(id),
+	role_id INTEGER NOT NULL REFERENCES roles(id),
+	PRIMARY KEY (api_key_id, role_id)
+);
+
+-- reading tasks now needs task.read, existing keys keep it through the viewer role
+INSERT INTO permissions(name) VALUES ('task.read') ON CONFLICT DO NOTHING;
+INSERT INTO roles(name) VALUES ('viewer') ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions(role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name = 'viewer' AND p.name = 'task.read'
+ON CONFLICT DO NOTHING;
+
+INSERT INTO api_key_roles(api_key_id, role_id)
+SELECT k.id, r.id FROM api_keys k, roles r
+WHERE r.name = 'viewer'
+ON CONFLICT DO NOTHING;
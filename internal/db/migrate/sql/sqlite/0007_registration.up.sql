@@ -0,0 +1,14 @@
+-- status: active, pending (waits for an admin in approval mode) or rejected,
+-- only active keys authenticate
+ALTER TABLE api_keys ADD COLUMN status TEXT NOT NULL DEFAULT 'active';
+
+-- single-use invite codes for the invite registration mode, stored hashed
+CREATE TABLE IF NOT EXISTS invites(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	code_hash TEXT NOT NULL UNIQUE,
+	created_by_key_id INTEGER REFERENCES api_keys(id),
+	created_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP,
+	used_at TIMESTAMP,
+	used_by_key_id INTEGER REFERENCES api_keys(id)
+);
@@ -0,0 +1,8 @@
+-- tasks each key created per UTC day, the daily task quota compares against
+-- it. Deleting a task doesn't give the slot back.
+CREATE TABLE IF NOT EXISTS task_quota_usage(
+	key_id INTEGER NOT NULL REFERENCES api_keys(id),
+	day TEXT NOT NULL, -- YYYY-MM-DD
+	created INTEGER NOT NULL,
+	PRIMARY KEY (key_id, day)
+);
@@ -0,0 +1,416 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib" // PostgreSQL driver
+
+	storage "rest_api/internal/db"
+)
+
+var _ storage.TaskRepository = (*Storage)(nil)
+
+// sortColumns maps sort fields to SQL expressions, a missing due date sorts as storage.MaxTime
+var sortColumns = map[storage.SortField]string{
+	storage.SortByID:        "id",
+	storage.SortByTitle:     "task",
+	storage.SortByCompleted: "completed",
+	storage.SortByPriority:  "priority",
+	storage.SortByDueAt:     "COALESCE(due_at, '" + storage.MaxTime.Format(time.RFC3339Nano) + "'::timestamptz)",
+	storage.SortByCreatedAt: "created_at",
+	storage.SortByUpdatedAt: "updated_at",
+}
+
+const taskColumns = "id, task, description, completed, priority, due_at, created_at, updated_at, owner_key_id"
+
+type Storage struct {
+	db *sql.DB
+}
+
+// Open connects to PostgreSQL by dsn. Tables are created by migrations (see internal/db/migrate).
+func Open(dsn string) (*sql.DB, error) {
+	const op = "storage.postgres.Open"
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: ping: %w", op, err)
+	}
+	return db, nil
+}
+
+// New creates a new PostgreSQL storage on a migrated database.
+func New(db *sql.DB) *Storage {
+	return &Storage{db: db}
+}
+
+// DB exposes raw *sql.DB for other packages (e.g. auth)
+func (s *Storage) DB() *sql.DB {
+	return s.db
+}
+
+// Close closes the connection pool
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+// AddTask stores a new task and returns it with ID and timestamps set
+func (s *Storage) AddTask(ctx context.Context, t storage.Task) (storage.Task, error) {
+	const op = "storage.postgres.AddTask"
+
+	t, err := addTask(ctx, s.db, t)
+	if err != nil {
+		return storage.Task{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return t, nil
+}
+
+// AddTasks stores tasks in one transaction and returns them with IDs and timestamps set
+func (s *Storage) AddTasks(ctx context.Context, tasks []storage.Task) ([]storage.Task, error) {
+	const op = "storage.postgres.AddTasks"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	added := make([]storage.Task, 0, len(tasks))
+	for i, t := range tasks {
+		t, err := addTask(ctx, tx, t)
+		if err != nil {
+			return nil, fmt.Errorf("%s: task %d: %w", op, i, err)
+		}
+		added = append(added, t)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return added, nil
+}
+
+// queryRower is a *sql.DB or a *sql.Tx
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func addTask(ctx context.Context, db queryRower, t storage.Task) (storage.Task, error) {
+	t.CreatedAt = storage.Now()
+	t.UpdatedAt = t.CreatedAt
+	if t.DueAt != nil {
+		due := storage.NormalizeTime(*t.DueAt)
+		t.DueAt = &due
+	}
+
+	err := db.QueryRowContext(ctx, `
+	INSERT INTO todo(task, description, completed, priority, due_at, created_at, updated_at, owner_key_id)
+	VALUES($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		t.Title, t.Description, t.Completed, t.Priority, t.DueAt, t.CreatedAt, t.UpdatedAt, t.OwnerKeyID).Scan(&t.ID)
+	if err != nil {
+		return storage.Task{}, err
+	}
+	return t, nil
+}
+
+// GetTaskByID returns the task by ID or storage.ErrTaskNotFound
+func (s *Storage) GetTaskByID(ctx context.Context, scope storage.Scope, id int64) (storage.Task, error) {
+	const op = "storage.postgres.GetTaskByID"
+
+	owner, args := ownerFilter(scope, id)
+	row := s.db.QueryRowContext(ctx, rebind("SELECT "+taskColumns+" FROM todo WHERE id = ?"+owner), args...)
+	t, err := scanTask(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storage.Task{}, fmt.Errorf("%s: id=%d: %w", op, id, storage.ErrTaskNotFound)
+		}
+		return storage.Task{}, fmt.Errorf("%s: QueryRow: %w", op, err)
+	}
+	return t, nil
+}
+
+// UpdateTask replaces the editable fields of the task t.ID and returns the stored task
+func (s *Storage) UpdateTask(ctx context.Context, scope storage.Scope, t storage.Task) (storage.Task, error) {
+	const op = "storage.postgres.UpdateTask"
+
+	if t.DueAt != nil {
+		due := storage.NormalizeTime(*t.DueAt)
+		t.DueAt = &due
+	}
+	owner, ownerArgs := ownerFilter(scope, t.ID)
+	args := append([]any{t.Title, t.Description, t.Completed, t.Priority, t.DueAt, storage.Now()}, ownerArgs...)
+	row := s.db.QueryRowContext(ctx, rebind(`
+	UPDATE todo SET task = ?, description = ?, completed = ?, priority = ?, due_at = ?, updated_at = ?
+	WHERE id = ?`+owner+`
+	RETURNING `+taskColumns), args...)
+	updated, err := scanTask(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storage.Task{}, fmt.Errorf("%s: id=%d: %w", op, t.ID, storage.ErrTaskNotFound)
+		}
+		return storage.Task{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return updated, nil
+}
+
+func (s *Storage) DeleteTaskByID(ctx context.Context, scope storage.Scope, id int64) error {
+	const op = "storage.postgres.DeleteTaskByID"
+
+	owner, args := ownerFilter(scope, id)
+	res, err := s.db.ExecContext(ctx, rebind("DELETE FROM todo WHERE id = ?"+owner), args...)
+	if err != nil {
+		return fmt.Errorf("%s: failed to delete task: %w", op, err)
+	}
+	if err := storage.CheckTaskAffected(res); err != nil {
+		return fmt.Errorf("%s: id=%d: %w", op, id, err)
+	}
+	return nil
+}
+
+func (s *Storage) MarkTaskTrue(ctx context.Context, scope storage.Scope, id int64) error {
+	const op = "storage.postgres.MarkTaskTrue"
+
+	owner, args := ownerFilter(scope, id)
+	res, err := s.db.ExecContext(ctx, rebind("UPDATE todo SET completed = TRUE, updated_at = ? WHERE id = ?"+owner),
+		append([]any{storage.Now()}, args...)...)
+	if err != nil {
+		return fmt.Errorf("%s: failed to mark task completed: %w", op, err)
+	}
+	if err := storage.CheckTaskAffected(res); err != nil {
+		return fmt.Errorf("%s: id=%d: %w", op, id, err)
+	}
+	return nil
+}
+
+func (s *Storage) MarkTaskFalse(ctx context.Context, scope storage.Scope, id int64) error {
+	const op = "storage.postgres.MarkTaskFalse"
+
+	owner, args := ownerFilter(scope, id)
+	res, err := s.db.ExecContext(ctx, rebind("UPDATE todo SET completed = FALSE, updated_at = ? WHERE id = ?"+owner),
+		append([]any{storage.Now()}, args...)...)
+	if err != nil {
+		return fmt.Errorf("%s: failed to mark task uncompleted: %w", op, err)
+	}
+	if err := storage.CheckTaskAffected(res); err != nil {
+		return fmt.Errorf("%s: id=%d: %w", op, id, err)
+	}
+	return nil
+}
+
+// ListTasks returns one page of tasks matching opts together with the total
+// number of matching tasks and a cursor for the next page.
+func (s *Storage) ListTasks(ctx context.Context, scope storage.Scope, opts storage.ListOptions) (storage.TaskPage, error) {
+	const op = "storage.postgres.ListTasks"
+
+	opts = opts.Normalize()
+	column := sortColumns[opts.Sort]
+
+	where, args := filterConditions(scope, opts)
+
+	var page storage.TaskPage
+	countQuery := rebind("SELECT COUNT(*) FROM todo" + whereClause(where))
+	if err := s.db.QueryRowContext(ctx, countQuery, args...).Scan(&page.Total); err != nil {
+		return page, fmt.Errorf("%s: count: %w", op, err)
+	}
+
+	cmp, dir := ">", "ASC"
+	if opts.Desc {
+		cmp, dir = "<", "DESC"
+	}
+
+	if opts.Cursor != "" {
+		c, err := storage.DecodeCursor(opts.Cursor, opts.Sort)
+		if err != nil {
+			return page, fmt.Errorf("%s: %w", op, err)
+		}
+		if opts.Sort == storage.SortByID {
+			where = append(where, "id "+cmp+" ?")
+			args = append(args, c.ID)
+		} else {
+			// row comparison is what keyset pagination needs and can use the index
+			where = append(where, fmt.Sprintf("(%s, id) %s (?, ?)", column, cmp))
+			args = append(args, c.Value, c.ID)
+		}
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM todo%s ORDER BY %s %s, id %s LIMIT ?",
+		taskColumns, whereClause(where), column, dir, dir)
+	// fetch one extra row to know whether there is a next page
+	args = append(args, opts.Limit+1)
+
+	rows, err := s.db.QueryContext(ctx, rebind(query), args...)
+	if err != nil {
+		return page, fmt.Errorf("%s: Query: %w", op, err)
+	}
+	defer rows.Close()
+
+	page.Tasks = make([]storage.Task, 0, opts.Limit)
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return page, fmt.Errorf("%s: Scan: %w", op, err)
+		}
+		page.Tasks = append(page.Tasks, t)
+	}
+	if err := rows.Err(); err != nil {
+		return page, fmt.Errorf("%s: rows: %w", op, err)
+	}
+
+	if len(page.Tasks) > opts.Limit {
+		page.Tasks = page.Tasks[:opts.Limit]
+		page.NextCursor = storage.NextCursor(page.Tasks[opts.Limit-1], opts.Sort)
+	}
+
+	return page, nil
+}
+
+// SearchTasks returns tasks matching query, best matches first, with the
+// matching words of each title wrapped in <mark></mark> and the rest escaped.
+func (s *Storage) SearchTasks(ctx context.Context, scope storage.Scope, query string, limit int) ([]storage.SearchResult, error) {
+	const op = "storage.postgres.SearchTasks"
+
+	if limit <= 0 {
+		limit = storage.DefaultSearchLimit
+	}
+	if limit > storage.MaxSearchLimit {
+		limit = storage.MaxSearchLimit
+	}
+
+	tsquery := tsQuery(query)
+	if tsquery == "" {
+		return []storage.SearchResult{}, nil
+	}
+
+	// ts_rank grows with relevance, negate it so lower is better like bm25 in sqlite
+	headline := fmt.Sprintf("StartSel=%s, StopSel=%s, HighlightAll=true", storage.SnippetStart, storage.SnippetEnd)
+	where := []string{"search @@ q"}
+	args := []any{headline, tsquery}
+	if !scope.AnyOwner {
+		where = append(where, "owner_key_id = ?")
+		args = append(args, scope.OwnerKeyID)
+	}
+	rows, err := s.db.QueryContext(ctx, rebind(`
+	SELECT `+taskColumns+`, ts_headline('simple', task, q, ?), -ts_rank(search, q) AS rank
+	FROM todo, to_tsquery('simple', ?) q`+whereClause(where)+`
+	ORDER BY rank, id
+	LIMIT ?`), append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: Query: %w", op, err)
+	}
+	defer rows.Close()
+
+	results := []storage.SearchResult{}
+	for rows.Next() {
+		var r storage.SearchResult
+		r.Task, err = scanTask(rows, &r.Snippet, &r.Rank)
+		if err != nil {
+			return nil, fmt.Errorf("%s: Scan: %w", op, err)
+		}
+		r.Snippet = storage.EscapeSnippet(r.Snippet)
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: rows: %w", op, err)
+	}
+
+	return results, nil
+}
+
+// tsQuery turns free text into a tsquery: every word is quoted so that
+// operators in user input are matched literally, words are ANDed and the
+// last one is matched as a prefix to support search-as-you-type.
+func tsQuery(query string) string {
+	words := strings.Fields(query)
+	for i, w := range words {
+		words[i] = "'" + strings.ReplaceAll(strings.ReplaceAll(w, `\`, `\\`), "'", "''") + "'"
+	}
+	if len(words) > 0 {
+		words[len(words)-1] += ":*"
+	}
+	return strings.Join(words, " & ")
+}
+
+// ownerFilter returns the condition to append to "WHERE id = ?" so that only
+// tasks in scope match, and the arguments of the whole WHERE clause
+func ownerFilter(scope storage.Scope, id int64) (string, []any) {
+	if scope.AnyOwner {
+		return "", []any{id}
+	}
+	return " AND owner_key_id = ?", []any{id, scope.OwnerKeyID}
+}
+
+// rebind turns ? placeholders into $n
+func rebind(query string) string {
+	return storage.DialectPostgres.Rebind(query)
+}
+
+func whereClause(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conds, " AND ")
+}
+
+// filterConditions translates the filters of opts into WHERE conditions with ? placeholders
+func filterConditions(scope storage.Scope, opts storage.ListOptions) ([]string, []any) {
+	var where []string
+	var args []any
+	if !scope.AnyOwner {
+		where = append(where, "owner_key_id = ?")
+		args = append(args, scope.OwnerKeyID)
+	}
+	if opts.Completed != nil {
+		where = append(where, "completed = ?")
+		args = append(args, *opts.Completed)
+	}
+	if len(opts.Priorities) > 0 {
+		where = append(where, "priority IN (?"+strings.Repeat(", ?", len(opts.Priorities)-1)+")")
+		for _, p := range opts.Priorities {
+			args = append(args, p)
+		}
+	}
+	if opts.DueBefore != nil {
+		where = append(where, "due_at < ?")
+		args = append(args, *opts.DueBefore)
+	}
+	if opts.DueAfter != nil {
+		where = append(where, "due_at >= ?")
+		args = append(args, *opts.DueAfter)
+	}
+	return where, args
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanTask reads a row selected with taskColumns, extra receives the columns that follow
+func scanTask(row scanner, extra ...any) (storage.Task, error) {
+	var t storage.Task
+	var dueAt sql.NullTime
+	var owner sql.NullInt64
+
+	dest := append([]any{&t.ID, &t.Title, &t.Description, &t.Completed, &t.Priority, &dueAt, &t.CreatedAt, &t.UpdatedAt, &owner}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return storage.Task{}, err
+	}
+
+	if dueAt.Valid {
+		due := dueAt.Time.UTC()
+		t.DueAt = &due
+	}
+	if owner.Valid {
+		t.OwnerKeyID = &owner.Int64
+	}
+	t.CreatedAt = t.CreatedAt.UTC()
+	t.UpdatedAt = t.UpdatedAt.UTC()
+	return t, nil
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"html"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	HighlightStart = "<mark>"
+	HighlightEnd   = "</mark>"
+)
+
+// SnippetStart and SnippetEnd delimit matches in snippets built by the
+// database, EscapeSnippet turns them into <mark></mark> once the text around
+// them is escaped. They are private use runes, a title holding one of them
+// gains a stray <mark> tag at worst, never other markup.
+const (
+	SnippetStart = "\uE000"
+	SnippetEnd   = "\uE001"
+)
+
+var snippetMarks = strings.NewReplacer(SnippetStart, HighlightStart, SnippetEnd, HighlightEnd)
+
+// EscapeSnippet HTML-escapes a snippet delimited with SnippetStart and
+// SnippetEnd, the only markup in the result are the <mark></mark> tags.
+func EscapeSnippet(snippet string) string {
+	return snippetMarks.Replace(html.EscapeString(snippet))
+}
+
+// Highlight HTML-escapes text and wraps case-insensitive occurrences of query
+// with <mark></mark>.
+func Highlight(text, query string) string {
+	if query == "" {
+		return html.EscapeString(text)
+	}
+
+	lowerText, lowerQuery := strings.ToLower(text), strings.ToLower(query)
+	// lowercasing may change byte lengths of some runes; don't risk slicing mid-rune
+	if len(lowerText) != len(text) || !utf8.ValidString(text) {
+		return html.EscapeString(text)
+	}
+
+	var b strings.Builder
+	for {
+		i := strings.Index(lowerText, lowerQuery)
+		if i < 0 {
+			b.WriteString(html.EscapeString(text))
+			return b.String()
+		}
+		end := i + len(lowerQuery)
+		b.WriteString(html.EscapeString(text[:i]))
+		b.WriteString(HighlightStart)
+		b.WriteString(html.EscapeString(text[i:end]))
+		b.WriteString(HighlightEnd)
+		text, lowerText = text[end:], lowerText[end:]
+	}
+}
@@ -0,0 +1,27 @@
+package storage
+
+import "testing"
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		text, query, want string
+	}{
+		{"Buy milk", "MILK", "Buy <mark>milk</mark>"},
+		{"milk & <b>milk</b>", "milk", "<mark>milk</mark> &amp; &lt;b&gt;<mark>milk</mark>&lt;/b&gt;"},
+		{`<script>alert("x")</script>`, "", "&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;"},
+		{"no match <i>", "cheese", "no match &lt;i&gt;"},
+	}
+	for _, tt := range tests {
+		if got := Highlight(tt.text, tt.query); got != tt.want {
+			t.Errorf("Highlight(%q, %q) = %q, want %q", tt.text, tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestEscapeSnippet(t *testing.T) {
+	snippet := "<img onerror=x> " + SnippetStart + "milk" + SnippetEnd + " & <mark>"
+	want := "&lt;img onerror=x&gt; <mark>milk</mark> &amp; &lt;mark&gt;"
+	if got := EscapeSnippet(snippet); got != want {
+		t.Errorf("EscapeSnippet(%q) = %q, want %q", snippet, got, want)
+	}
+}
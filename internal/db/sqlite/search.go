@@ -0,0 +1,167 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	storage "rest_api/internal/db"
+)
+
+// ErrNoFTS5 is returned by New when go-sqlite3 was built without FTS5
+var ErrNoFTS5 = errors.New("sqlite is built without FTS5, build with -tags sqlite_fts5 or enable search_fallback")
+
+// initSearch creates the todo_fts index and the triggers keeping it in sync with todo.
+// The FTS5 module is only compiled into go-sqlite3 with the sqlite_fts5 build tag;
+// without it search falls back to substring matching if fallback allows it.
+func (s *Storage) initSearch(fallback bool) error {
+	const op = "storage.sqlite.initSearch"
+
+	var enabled bool
+	if err := s.db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if !enabled {
+		if !fallback {
+			return fmt.Errorf("%s: %w", op, ErrNoFTS5)
+		}
+		// the database may have been indexed by a build with FTS5; its triggers
+		// would make every write to todo fail here
+		_, err := s.db.Exec(`
+		DROP TRIGGER IF EXISTS todo_fts_ai;
+		DROP TRIGGER IF EXISTS todo_fts_ad;
+		DROP TRIGGER IF EXISTS todo_fts_au;
+		`)
+		if err != nil {
+			return fmt.Errorf("%s: drop triggers: %w", op, err)
+		}
+		return nil
+	}
+
+	// without the triggers the index is missing or stale and has to be rebuilt
+	var synced bool
+	err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'trigger' AND name = 'todo_fts_ai')").Scan(&synced)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = s.db.Exec(`
+	CREATE VIRTUAL TABLE IF NOT EXISTS todo_fts USING fts5(task, content='todo', content_rowid='id');
+
+	CREATE TRIGGER IF NOT EXISTS todo_fts_ai AFTER INSERT ON todo BEGIN
+		INSERT INTO todo_fts(rowid, task) VALUES (new.id, new.task);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS todo_fts_ad AFTER DELETE ON todo BEGIN
+		INSERT INTO todo_fts(todo_fts, rowid, task) VALUES ('delete', old.id, old.task);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS todo_fts_au AFTER UPDATE OF task ON todo BEGIN
+		INSERT INTO todo_fts(todo_fts, rowid, task) VALUES ('delete', old.id, old.task);
+		INSERT INTO todo_fts(rowid, task) VALUES (new.id, new.task);
+	END;
+	`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !synced {
+		if _, err := s.db.Exec("INSERT INTO todo_fts(todo_fts) VALUES ('rebuild')"); err != nil {
+			return fmt.Errorf("%s: rebuild: %w", op, err)
+		}
+	}
+
+	s.fts = true
+	return nil
+}
+
+// FullTextSearch reports whether SearchTasks uses the FTS5 index
+func (s *Storage) FullTextSearch() bool {
+	return s.fts
+}
+
+// SearchTasks returns tasks matching query, best matches first, with the
+// matching words of each title wrapped in <mark></mark> and the rest escaped.
+func (s *Storage) SearchTasks(ctx context.Context, scope storage.Scope, query string, limit int) ([]storage.SearchResult, error) {
+	const op = "storage.sqlite.SearchTasks"
+
+	if limit <= 0 {
+		limit = storage.DefaultSearchLimit
+	}
+	if limit > storage.MaxSearchLimit {
+		limit = storage.MaxSearchLimit
+	}
+
+	var where []string
+	var args []any
+	if !scope.AnyOwner {
+		where = append(where, "owner_key_id = ?")
+		args = append(args, scope.OwnerKeyID)
+	}
+
+	var (
+		rows *sql.Rows
+		err  error
+	)
+	if s.fts {
+		args = append([]any{storage.SnippetStart, storage.SnippetEnd, ftsQuery(query)}, args...)
+		rows, err = s.db.QueryContext(ctx, `
+		SELECT `+taskColumns+`, m.snippet, m.rank
+		FROM todo
+		JOIN (
+			SELECT rowid, snippet(todo_fts, 0, ?, ?, '…', 16) AS snippet, bm25(todo_fts) AS rank
+			FROM todo_fts
+			WHERE todo_fts MATCH ?
+		) m ON m.rowid = todo.id`+whereClause(where)+`
+		ORDER BY m.rank, todo.id
+		LIMIT ?`, append(args, limit)...)
+	} else {
+		where = append([]string{"instr(lower(task), lower(?)) > 0"}, where...)
+		args = append([]any{query, query}, args...)
+		rows, err = s.db.QueryContext(ctx, `
+		SELECT `+taskColumns+`, task, instr(lower(task), lower(?))
+		FROM todo`+whereClause(where)+`
+		ORDER BY instr(lower(task), lower(?)), length(task), id
+		LIMIT ?`, append(args, query, limit)...)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: Query: %w", op, err)
+	}
+	defer rows.Close()
+
+	results := []storage.SearchResult{}
+	for rows.Next() {
+		var r storage.SearchResult
+		r.Task, err = scanTask(rows, &r.Snippet, &r.Rank)
+		if err != nil {
+			return nil, fmt.Errorf("%s: Scan: %w", op, err)
+		}
+		if s.fts {
+			r.Snippet = storage.EscapeSnippet(r.Snippet)
+		} else {
+			r.Snippet = storage.Highlight(r.Title, query)
+		}
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: rows: %w", op, err)
+	}
+
+	return results, nil
+}
+
+// ftsQuery turns free text into an FTS5 query: every word is quoted so that
+// FTS5 operators in user input are matched literally, and the last word is
+// matched as a prefix to support search-as-you-type.
+func ftsQuery(query string) string {
+	words := strings.Fields(query)
+	for i, w := range words {
+		words[i] = `"` + strings.ReplaceAll(w, `"`, `""`) + `"`
+	}
+	if len(words) > 0 {
+		words[len(words)-1] += "*"
+	}
+	return strings.Join(words, " ")
+}
@@ -1,40 +1,71 @@
 package sqlite
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3" // SQLite driver
+
+	storage "rest_api/internal/db"
 )
 
+var _ storage.TaskRepository = (*Storage)(nil)
+
+// timeLayout is how timestamps are stored: fixed width text in UTC, so they sort as strings
+const timeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// sortColumns maps sort fields to SQL expressions, a missing due date sorts as storage.MaxTime
+var sortColumns = map[storage.SortField]string{
+	storage.SortByID:        "id",
+	storage.SortByTitle:     "task",
+	storage.SortByCompleted: "completed",
+	storage.SortByPriority:  "priority",
+	storage.SortByDueAt:     "COALESCE(due_at, '" + formatTime(storage.MaxTime) + "')",
+	storage.SortByCreatedAt: "created_at",
+	storage.SortByUpdatedAt: "updated_at",
+}
+
+const taskColumns = "id, task, description, completed, priority, due_at, created_at, updated_at, owner_key_id"
+
 type Storage struct {
-	db *sql.DB
+	db  *sql.DB
+	fts bool // todo_fts index is available
 }
 
-// New creates a new SQLite storage and initializes the todo table.
-func New(storagePath string) (*Storage, error) {
-	const op = "storage.sqlite.New"
+// Open opens the SQLite database file. Tables are created by migrations (see internal/db/migrate).
+func Open(storagePath string) (*sql.DB, error) {
+	const op = "storage.sqlite.Open"
 
-	db, err := sql.Open("sqlite3", storagePath)
+	// sqlite leaves foreign keys unchecked unless every connection turns them on
+	dsn := storagePath
+	if strings.Contains(dsn, "?") {
+		dsn += "&_foreign_keys=on"
+	} else {
+		dsn += "?_foreign_keys=on"
+	}
+
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
+	return db, nil
+}
 
-	// Create table for tasks only
-	_, err = db.Exec(`
-	CREATE TABLE IF NOT EXISTS todo(
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		task TEXT NOT NULL,
-		completed BOOLEAN DEFAULT FALSE
-	);
+// New creates a new SQLite storage on a migrated database and sets up the search index.
+// Without FTS5 it fails with ErrNoFTS5 unless searchFallback allows substring matching.
+func New(db *sql.DB, searchFallback bool) (*Storage, error) {
+	const op = "storage.sqlite.New"
 
-	CREATE INDEX IF NOT EXISTS idx_task ON todo(task);
-	`)
-	if err != nil {
+	s := &Storage{db: db}
+	if err := s.initSearch(searchFallback); err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return &Storage{db: db}, nil
+	return s, nil
 }
 
 // DB exposes raw *sql.DB for other packages (e.g. auth)
@@ -42,69 +73,334 @@ func (s *Storage) DB() *sql.DB {
 	return s.db
 }
 
-// AddTask adds a new task and returns its ID
-func (s *Storage) AddTask(task string) (int64, error) {
+// Close closes the underlying database
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+// AddTask stores a new task and returns it with ID and timestamps set
+func (s *Storage) AddTask(ctx context.Context, t storage.Task) (storage.Task, error) {
 	const op = "storage.sqlite.AddTask"
 
-	stmt, err := s.db.Prepare("INSERT INTO todo(task) VALUES(?)")
+	t, err := addTask(ctx, s.db, t)
 	if err != nil {
-		return 0, fmt.Errorf("%s: Prepare: %w", op, err)
+		return storage.Task{}, fmt.Errorf("%s: %w", op, err)
 	}
-	defer stmt.Close()
+	return t, nil
+}
+
+// AddTasks stores tasks in one transaction and returns them with IDs and timestamps set
+func (s *Storage) AddTasks(ctx context.Context, tasks []storage.Task) ([]storage.Task, error) {
+	const op = "storage.sqlite.AddTasks"
 
-	result, err := stmt.Exec(task)
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return 0, fmt.Errorf("%s: Exec: %w", op, err)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	added := make([]storage.Task, 0, len(tasks))
+	for i, t := range tasks {
+		t, err := addTask(ctx, tx, t)
+		if err != nil {
+			return nil, fmt.Errorf("%s: task %d: %w", op, i, err)
+		}
+		added = append(added, t)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return added, nil
+}
+
+// execer is a *sql.DB or a *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func addTask(ctx context.Context, db execer, t storage.Task) (storage.Task, error) {
+	t.CreatedAt = storage.Now()
+	t.UpdatedAt = t.CreatedAt
+	if t.DueAt != nil {
+		due := storage.NormalizeTime(*t.DueAt)
+		t.DueAt = &due
 	}
 
-	id, err := result.LastInsertId()
+	result, err := db.ExecContext(ctx, `
+	INSERT INTO todo(task, description, completed, priority, due_at, created_at, updated_at, owner_key_id)
+	VALUES(?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.Title, t.Description, t.Completed, t.Priority,
+		formatNullTime(t.DueAt), formatTime(t.CreatedAt), formatTime(t.UpdatedAt), t.OwnerKeyID)
 	if err != nil {
-		return 0, fmt.Errorf("%s: lastInsertId: %w", op, err)
+		return storage.Task{}, fmt.Errorf("Exec: %w", err)
 	}
 
-	return id, nil
+	t.ID, err = result.LastInsertId()
+	if err != nil {
+		return storage.Task{}, fmt.Errorf("lastInsertId: %w", err)
+	}
+	return t, nil
 }
 
-// GetTaskByID returns task text and completed status by ID
-func (s *Storage) GetTaskByID(id int64) (string, bool, error) {
+// GetTaskByID returns the task by ID or storage.ErrTaskNotFound
+func (s *Storage) GetTaskByID(ctx context.Context, scope storage.Scope, id int64) (storage.Task, error) {
 	const op = "storage.sqlite.GetTaskByID"
 
-	var task string
-	var completed bool
+	owner, args := ownerFilter(scope, id)
+	row := s.db.QueryRowContext(ctx, "SELECT "+taskColumns+" FROM todo WHERE id = ?"+owner, args...)
+	t, err := scanTask(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storage.Task{}, fmt.Errorf("%s: id=%d: %w", op, id, storage.ErrTaskNotFound)
+		}
+		return storage.Task{}, fmt.Errorf("%s: QueryRow: %w", op, err)
+	}
+
+	return t, nil
+}
 
-	err := s.db.QueryRow("SELECT task, completed FROM todo WHERE id = ?", id).
-		Scan(&task, &completed)
+// UpdateTask replaces the editable fields of the task t.ID and returns the stored task
+func (s *Storage) UpdateTask(ctx context.Context, scope storage.Scope, t storage.Task) (storage.Task, error) {
+	const op = "storage.sqlite.UpdateTask"
 
+	t.UpdatedAt = storage.Now()
+	if t.DueAt != nil {
+		due := storage.NormalizeTime(*t.DueAt)
+		t.DueAt = &due
+	}
+
+	owner, ownerArgs := ownerFilter(scope, t.ID)
+	args := append([]any{t.Title, t.Description, t.Completed, t.Priority, formatNullTime(t.DueAt), formatTime(t.UpdatedAt)}, ownerArgs...)
+	res, err := s.db.ExecContext(ctx, `
+	UPDATE todo SET task = ?, description = ?, completed = ?, priority = ?, due_at = ?, updated_at = ?
+	WHERE id = ?`+owner, args...)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return "", false, fmt.Errorf("No task with id=%d: %w", id, sql.ErrNoRows)
-		}
-		return "", false, fmt.Errorf("%s: QueryRow: %w", op, err)
+		return storage.Task{}, fmt.Errorf("%s: Exec: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return storage.Task{}, fmt.Errorf("%s: RowsAffected: %w", op, err)
+	} else if n == 0 {
+		return storage.Task{}, fmt.Errorf("%s: id=%d: %w", op, t.ID, storage.ErrTaskNotFound)
 	}
 
-	return task, completed, nil
+	return s.GetTaskByID(ctx, scope, t.ID)
 }
 
-func (s *Storage) DeleteTaskByID(id int64) error {
-	_, err := s.db.Exec(`DELETE FROM todo WHERE id = ?`, id)
+func (s *Storage) DeleteTaskByID(ctx context.Context, scope storage.Scope, id int64) error {
+	const op = "storage.sqlite.DeleteTaskByID"
+
+	owner, args := ownerFilter(scope, id)
+	res, err := s.db.ExecContext(ctx, `DELETE FROM todo WHERE id = ?`+owner, args...)
 	if err != nil {
-		return fmt.Errorf("failed to delete task: %w", err)
+		return fmt.Errorf("%s: failed to delete task: %w", op, err)
+	}
+	if err := storage.CheckTaskAffected(res); err != nil {
+		return fmt.Errorf("%s: id=%d: %w", op, id, err)
 	}
 	return nil
 }
 
-func (s *Storage) MarkTaskTrue(id int64) error {
-	_, err := s.db.Exec(`UPDATE todo SET completed = 1 WHERE id = ?`, id)
+func (s *Storage) MarkTaskTrue(ctx context.Context, scope storage.Scope, id int64) error {
+	const op = "storage.sqlite.MarkTaskTrue"
+
+	owner, args := ownerFilter(scope, id)
+	res, err := s.db.ExecContext(ctx, `UPDATE todo SET completed = 1, updated_at = ? WHERE id = ?`+owner,
+		append([]any{formatTime(storage.Now())}, args...)...)
 	if err != nil {
-		return fmt.Errorf("failed to mark task completed: %w", err)
+		return fmt.Errorf("%s: failed to mark task completed: %w", op, err)
+	}
+	if err := storage.CheckTaskAffected(res); err != nil {
+		return fmt.Errorf("%s: id=%d: %w", op, id, err)
 	}
 	return nil
 }
 
-func (s *Storage) MarkTaskFalse(id int64) error {
-	_, err := s.db.Exec(`UPDATE todo SET completed = 0 WHERE id = ?`, id)
+func (s *Storage) MarkTaskFalse(ctx context.Context, scope storage.Scope, id int64) error {
+	const op = "storage.sqlite.MarkTaskFalse"
+
+	owner, args := ownerFilter(scope, id)
+	res, err := s.db.ExecContext(ctx, `UPDATE todo SET completed = 0, updated_at = ? WHERE id = ?`+owner,
+		append([]any{formatTime(storage.Now())}, args...)...)
 	if err != nil {
-		return fmt.Errorf("failed to mark task uncompleted: %w", err)
+		return fmt.Errorf("%s: failed to mark task uncompleted: %w", op, err)
+	}
+	if err := storage.CheckTaskAffected(res); err != nil {
+		return fmt.Errorf("%s: id=%d: %w", op, id, err)
 	}
 	return nil
 }
+
+// ListTasks returns one page of tasks matching opts together with the total
+// number of matching tasks and a cursor for the next page.
+func (s *Storage) ListTasks(ctx context.Context, scope storage.Scope, opts storage.ListOptions) (storage.TaskPage, error) {
+	const op = "storage.sqlite.ListTasks"
+
+	opts = opts.Normalize()
+	column := sortColumns[opts.Sort]
+
+	where, args := filterConditions(scope, opts)
+
+	var page storage.TaskPage
+	countQuery := "SELECT COUNT(*) FROM todo" + whereClause(where)
+	if err := s.db.QueryRowContext(ctx, countQuery, args...).Scan(&page.Total); err != nil {
+		return page, fmt.Errorf("%s: count: %w", op, err)
+	}
+
+	cmp, dir := ">", "ASC"
+	if opts.Desc {
+		cmp, dir = "<", "DESC"
+	}
+
+	if opts.Cursor != "" {
+		c, err := storage.DecodeCursor(opts.Cursor, opts.Sort)
+		if err != nil {
+			return page, fmt.Errorf("%s: %w", op, err)
+		}
+		if opts.Sort == storage.SortByID {
+			where = append(where, "id "+cmp+" ?")
+			args = append(args, c.ID)
+		} else {
+			value := sqlValue(c.Value)
+			where = append(where, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", column, cmp))
+			args = append(args, value, value, c.ID)
+		}
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM todo%s ORDER BY %s %s, id %s LIMIT ?",
+		taskColumns, whereClause(where), column, dir, dir)
+	// fetch one extra row to know whether there is a next page
+	args = append(args, opts.Limit+1)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return page, fmt.Errorf("%s: Query: %w", op, err)
+	}
+	defer rows.Close()
+
+	page.Tasks = make([]storage.Task, 0, opts.Limit)
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return page, fmt.Errorf("%s: Scan: %w", op, err)
+		}
+		page.Tasks = append(page.Tasks, t)
+	}
+	if err := rows.Err(); err != nil {
+		return page, fmt.Errorf("%s: rows: %w", op, err)
+	}
+
+	if len(page.Tasks) > opts.Limit {
+		page.Tasks = page.Tasks[:opts.Limit]
+		page.NextCursor = storage.NextCursor(page.Tasks[opts.Limit-1], opts.Sort)
+	}
+
+	return page, nil
+}
+
+func whereClause(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conds, " AND ")
+}
+
+// filterConditions translates the filters of opts into WHERE conditions
+func filterConditions(scope storage.Scope, opts storage.ListOptions) ([]string, []any) {
+	var where []string
+	var args []any
+	if !scope.AnyOwner {
+		where = append(where, "owner_key_id = ?")
+		args = append(args, scope.OwnerKeyID)
+	}
+	if opts.Completed != nil {
+		where = append(where, "completed = ?")
+		args = append(args, *opts.Completed)
+	}
+	if len(opts.Priorities) > 0 {
+		where = append(where, "priority IN (?"+strings.Repeat(", ?", len(opts.Priorities)-1)+")")
+		for _, p := range opts.Priorities {
+			args = append(args, p)
+		}
+	}
+	if opts.DueBefore != nil {
+		where = append(where, "due_at < ?")
+		args = append(args, formatTime(*opts.DueBefore))
+	}
+	if opts.DueAfter != nil {
+		where = append(where, "due_at >= ?")
+		args = append(args, formatTime(*opts.DueAfter))
+	}
+	return where, args
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanTask reads a row selected with taskColumns, extra receives the columns that follow
+func scanTask(row scanner, extra ...any) (storage.Task, error) {
+	var t storage.Task
+	var dueAt sql.NullString
+	var createdAt, updatedAt string
+	var owner sql.NullInt64
+
+	dest := append([]any{&t.ID, &t.Title, &t.Description, &t.Completed, &t.Priority, &dueAt, &createdAt, &updatedAt, &owner}, extra...)
+	err := row.Scan(dest...)
+	if err != nil {
+		return storage.Task{}, err
+	}
+
+	if dueAt.Valid {
+		due, err := parseTime(dueAt.String)
+		if err != nil {
+			return storage.Task{}, err
+		}
+		t.DueAt = &due
+	}
+	if owner.Valid {
+		t.OwnerKeyID = &owner.Int64
+	}
+	if t.CreatedAt, err = parseTime(createdAt); err != nil {
+		return storage.Task{}, err
+	}
+	if t.UpdatedAt, err = parseTime(updatedAt); err != nil {
+		return storage.Task{}, err
+	}
+	return t, nil
+}
+
+// ownerFilter returns the condition to append to "WHERE id = ?" so that only
+// tasks in scope match, and the arguments of the whole WHERE clause
+func ownerFilter(scope storage.Scope, id int64) (string, []any) {
+	if scope.AnyOwner {
+		return "", []any{id}
+	}
+	return " AND owner_key_id = ?", []any{id, scope.OwnerKeyID}
+}
+
+// sqlValue converts a cursor value into the form it's stored in
+func sqlValue(v any) any {
+	if t, ok := v.(time.Time); ok {
+		return formatTime(t)
+	}
+	return v
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeLayout)
+}
+
+func formatNullTime(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return formatTime(*t)
+}
+
+func parseTime(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad timestamp %q: %w", s, err)
+	}
+	return t.UTC(), nil
+}
@@ -0,0 +1,29 @@
+package sqlite_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	storage "rest_api/internal/db"
+	"rest_api/internal/db/dbtest"
+	"rest_api/internal/db/sqlite"
+)
+
+// TestRepositoryContract runs with FTS5 search under -tags sqlite_fts5 and
+// with the substring fallback without it.
+func TestRepositoryContract(t *testing.T) {
+	dbtest.RunRepositoryContract(t, func(t *testing.T) dbtest.Backend {
+		db, err := sqlite.Open(filepath.Join(t.TempDir(), "storage.db"))
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		dbtest.Migrate(t, db, storage.DialectSQLite)
+		alice, bob := dbtest.AddKeys(t, db, storage.DialectSQLite)
+
+		repo, err := sqlite.New(db, true)
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		return dbtest.Backend{Repo: repo, Alice: alice, Bob: bob, ForeignKeys: true}
+	})
+}
@@ -1,9 +1,71 @@
 package storage
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 )
 
 var (
-	ErrTaskNotFound = errors.New("Task not found")
-)
\ No newline at end of file
+	ErrTaskNotFound    = errors.New("Task not found")
+	ErrInvalidCursor   = errors.New("invalid cursor")
+	ErrUnknownPriority = errors.New("unknown priority")
+)
+
+// TaskRepository is implemented by every task storage backend.
+// Every call except AddTask only sees the tasks allowed by its scope.
+type TaskRepository interface {
+	// AddTask stores a new task and returns it with ID and timestamps set
+	AddTask(ctx context.Context, t Task) (Task, error)
+	// AddTasks stores all of tasks like AddTask or, on error, none of them
+	AddTasks(ctx context.Context, tasks []Task) ([]Task, error)
+	// GetTaskByID returns the task or ErrTaskNotFound
+	GetTaskByID(ctx context.Context, scope Scope, id int64) (Task, error)
+	// ListTasks returns one page of tasks matching opts
+	ListTasks(ctx context.Context, scope Scope, opts ListOptions) (TaskPage, error)
+	// SearchTasks returns tasks matching query, best matches first
+	SearchTasks(ctx context.Context, scope Scope, query string, limit int) ([]SearchResult, error)
+	// UpdateTask replaces the editable fields of the task t.ID and returns
+	// the stored task, or ErrTaskNotFound
+	UpdateTask(ctx context.Context, scope Scope, t Task) (Task, error)
+	// DeleteTaskByID, MarkTaskTrue and MarkTaskFalse return ErrTaskNotFound
+	// when no task in scope has the id
+	DeleteTaskByID(ctx context.Context, scope Scope, id int64) error
+	MarkTaskTrue(ctx context.Context, scope Scope, id int64) error
+	MarkTaskFalse(ctx context.Context, scope Scope, id int64) error
+	Close() error
+}
+
+// Scope limits which tasks a repository call can see and change.
+type Scope struct {
+	OwnerKeyID int64 // tasks created with this API key
+	AnyOwner   bool  // every task, for admin keys
+}
+
+// OwnedBy is the scope of the tasks created with the API key keyID.
+func OwnedBy(keyID int64) Scope {
+	return Scope{OwnerKeyID: keyID}
+}
+
+// AnyOwner is the scope of all tasks.
+func AnyOwner() Scope {
+	return Scope{AnyOwner: true}
+}
+
+// Allows reports whether t is visible in the scope.
+func (s Scope) Allows(t Task) bool {
+	return s.AnyOwner || (t.OwnerKeyID != nil && *t.OwnerKeyID == s.OwnerKeyID)
+}
+
+// CheckTaskAffected returns ErrTaskNotFound if the statement behind res
+// matched no task
+func CheckTaskAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrTaskNotFound
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	DefaultListLimit = 20
+	MaxListLimit     = 100
+
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+// MaxTime stands in for a missing due date when ordering, so tasks without one come last.
+var MaxTime = time.Date(9999, 12, 31, 23, 59, 59, 999_000_000, time.UTC)
+
+// Priority of a task. It's stored as a number so it sorts by urgency,
+// and travels as its name in JSON.
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+	PriorityUrgent
+)
+
+var priorityNames = []string{"low", "normal", "high", "urgent"}
+
+// ParsePriority converts a priority name into a Priority.
+func ParsePriority(name string) (Priority, error) {
+	for i, n := range priorityNames {
+		if n == name {
+			return Priority(i), nil
+		}
+	}
+	return 0, fmt.Errorf("%w %q, must be one of low, normal, high, urgent", ErrUnknownPriority, name)
+}
+
+func (p Priority) Valid() bool {
+	return p >= PriorityLow && p <= PriorityUrgent
+}
+
+func (p Priority) String() string {
+	if !p.Valid() {
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+	return priorityNames[p]
+}
+
+func (p Priority) MarshalJSON() ([]byte, error) {
+	if !p.Valid() {
+		return nil, fmt.Errorf("invalid priority %d", int(p))
+	}
+	return json.Marshal(p.String())
+}
+
+func (p *Priority) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return fmt.Errorf("priority must be a string: %w", err)
+	}
+	parsed, err := ParsePriority(name)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// Value stores priorities as their number.
+func (p Priority) Value() (driver.Value, error) {
+	return int64(p), nil
+}
+
+// Task is a single todo item.
+type Task struct {
+	ID          int64      `json:"id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Completed   bool       `json:"completed"`
+	Priority    Priority   `json:"priority"`
+	DueAt       *time.Time `json:"due_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	OwnerKeyID  *int64     `json:"owner_key_id"` // API key that created the task, nil for tasks older than ownership
+}
+
+// DueOrMax returns the due date or MaxTime when the task has none.
+func (t Task) DueOrMax() time.Time {
+	if t.DueAt == nil {
+		return MaxTime
+	}
+	return *t.DueAt
+}
+
+// SortField is a task attribute tasks can be ordered by.
+type SortField string
+
+const (
+	SortByID        SortField = "id"
+	SortByTitle     SortField = "title"
+	SortByCompleted SortField = "completed"
+	SortByPriority  SortField = "priority"
+	SortByDueAt     SortField = "due_at"
+	SortByCreatedAt SortField = "created_at"
+	SortByUpdatedAt SortField = "updated_at"
+)
+
+// ValidSortField reports whether tasks can be sorted by f.
+func ValidSortField(f SortField) bool {
+	switch f {
+	case SortByID, SortByTitle, SortByCompleted, SortByPriority, SortByDueAt, SortByCreatedAt, SortByUpdatedAt:
+		return true
+	}
+	return false
+}
+
+// ListOptions describes which page of tasks ListTasks should return.
+type ListOptions struct {
+	Limit      int
+	Cursor     string     // opaque value returned as NextCursor by the previous call
+	Completed  *bool      // nil means both completed and uncompleted tasks
+	Priorities []Priority // empty means any priority
+	DueBefore  *time.Time // only tasks due strictly before
+	DueAfter   *time.Time // only tasks due at or after
+	Sort       SortField
+	Desc       bool
+}
+
+// Normalize replaces missing or out of range options with defaults.
+func (o ListOptions) Normalize() ListOptions {
+	if o.Limit <= 0 {
+		o.Limit = DefaultListLimit
+	}
+	if o.Limit > MaxListLimit {
+		o.Limit = MaxListLimit
+	}
+	if !ValidSortField(o.Sort) {
+		o.Sort = SortByID
+	}
+	return o
+}
+
+// Match reports whether t passes the filters of o. SQL backends express the
+// same conditions in their WHERE clause.
+func (o ListOptions) Match(t Task) bool {
+	if o.Completed != nil && t.Completed != *o.Completed {
+		return false
+	}
+	if len(o.Priorities) > 0 {
+		found := false
+		for _, p := range o.Priorities {
+			found = found || p == t.Priority
+		}
+		if !found {
+			return false
+		}
+	}
+	if o.DueBefore != nil && (t.DueAt == nil || !t.DueAt.Before(*o.DueBefore)) {
+		return false
+	}
+	if o.DueAfter != nil && (t.DueAt == nil || t.DueAt.Before(*o.DueAfter)) {
+		return false
+	}
+	return true
+}
+
+// TaskPage is one page of a task listing.
+type TaskPage struct {
+	Tasks      []Task
+	Total      int    // number of tasks matching the filter across all pages
+	NextCursor string // "" on the last page
+}
+
+// SearchResult is a task matching a full-text query.
+type SearchResult struct {
+	Task
+	Snippet string  `json:"snippet"`
+	Rank    float64 `json:"rank"` // lower is a better match
+}
+
+// SortValue returns the value of the attribute f of the task, missing due
+// dates are reported as MaxTime.
+func (t Task) SortValue(f SortField) any {
+	switch f {
+	case SortByTitle:
+		return t.Title
+	case SortByCompleted:
+		return t.Completed
+	case SortByPriority:
+		return t.Priority
+	case SortByDueAt:
+		return t.DueOrMax()
+	case SortByCreatedAt:
+		return t.CreatedAt
+	case SortByUpdatedAt:
+		return t.UpdatedAt
+	default:
+		return t.ID
+	}
+}
+
+// NormalizeTime converts t to the form every backend stores timestamps in:
+// UTC with millisecond precision.
+func NormalizeTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Millisecond)
+}
+
+// Now returns the current time normalized with NormalizeTime.
+func Now() time.Time {
+	return NormalizeTime(time.Now())
+}
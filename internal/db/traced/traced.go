@@ -0,0 +1,129 @@
+// Package traced wraps a task repository so that every call is recorded
+// as an OpenTelemetry span.
+package traced
+
+import (
+	"context"
+	"errors"
+
+	storage "rest_api/internal/db"
+	"rest_api/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var _ storage.TaskRepository = (*Storage)(nil)
+
+type Storage struct {
+	next   storage.TaskRepository
+	system attribute.KeyValue
+}
+
+// New wraps repo, system names the backend (sqlite, postgres or memory)
+func New(repo storage.TaskRepository, system string) *Storage {
+	return &Storage{next: repo, system: attribute.String("db.system", system)}
+}
+
+// start opens a client span named storage.<method>
+func (s *Storage) start(ctx context.Context, method string, scope storage.Scope, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, s.system, attribute.Bool("scope.any_owner", scope.AnyOwner))
+	if !scope.AnyOwner {
+		attrs = append(attrs, attribute.Int64("scope.owner_key_id", scope.OwnerKeyID))
+	}
+	return tracing.Tracer().Start(ctx, "storage."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// end records err on the span, a missing task is an expected outcome, not an error
+func end(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, storage.ErrTaskNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (s *Storage) AddTask(ctx context.Context, t storage.Task) (storage.Task, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "storage.AddTask",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(s.system),
+	)
+	t, err := s.next.AddTask(ctx, t)
+	span.SetAttributes(attribute.Int64("task.id", t.ID))
+	end(span, err)
+	return t, err
+}
+
+func (s *Storage) AddTasks(ctx context.Context, tasks []storage.Task) ([]storage.Task, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "storage.AddTasks",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(s.system, attribute.Int("task.count", len(tasks))),
+	)
+	added, err := s.next.AddTasks(ctx, tasks)
+	end(span, err)
+	return added, err
+}
+
+func (s *Storage) GetTaskByID(ctx context.Context, scope storage.Scope, id int64) (storage.Task, error) {
+	ctx, span := s.start(ctx, "GetTaskByID", scope, attribute.Int64("task.id", id))
+	t, err := s.next.GetTaskByID(ctx, scope, id)
+	end(span, err)
+	return t, err
+}
+
+func (s *Storage) ListTasks(ctx context.Context, scope storage.Scope, opts storage.ListOptions) (storage.TaskPage, error) {
+	ctx, span := s.start(ctx, "ListTasks", scope,
+		attribute.Int("list.limit", opts.Limit),
+		attribute.String("list.sort", string(opts.Sort)),
+		attribute.Bool("list.desc", opts.Desc),
+		attribute.Bool("list.cursor", opts.Cursor != ""),
+	)
+	page, err := s.next.ListTasks(ctx, scope, opts)
+	span.SetAttributes(attribute.Int("list.returned", len(page.Tasks)), attribute.Int("list.total", page.Total))
+	end(span, err)
+	return page, err
+}
+
+func (s *Storage) SearchTasks(ctx context.Context, scope storage.Scope, query string, limit int) ([]storage.SearchResult, error) {
+	ctx, span := s.start(ctx, "SearchTasks", scope, attribute.Int("search.limit", limit))
+	results, err := s.next.SearchTasks(ctx, scope, query, limit)
+	span.SetAttributes(attribute.Int("search.returned", len(results)))
+	end(span, err)
+	return results, err
+}
+
+func (s *Storage) UpdateTask(ctx context.Context, scope storage.Scope, t storage.Task) (storage.Task, error) {
+	ctx, span := s.start(ctx, "UpdateTask", scope, attribute.Int64("task.id", t.ID))
+	t, err := s.next.UpdateTask(ctx, scope, t)
+	end(span, err)
+	return t, err
+}
+
+func (s *Storage) DeleteTaskByID(ctx context.Context, scope storage.Scope, id int64) error {
+	ctx, span := s.start(ctx, "DeleteTaskByID", scope, attribute.Int64("task.id", id))
+	err := s.next.DeleteTaskByID(ctx, scope, id)
+	end(span, err)
+	return err
+}
+
+func (s *Storage) MarkTaskTrue(ctx context.Context, scope storage.Scope, id int64) error {
+	ctx, span := s.start(ctx, "MarkTaskTrue", scope, attribute.Int64("task.id", id))
+	err := s.next.MarkTaskTrue(ctx, scope, id)
+	end(span, err)
+	return err
+}
+
+func (s *Storage) MarkTaskFalse(ctx context.Context, scope storage.Scope, id int64) error {
+	ctx, span := s.start(ctx, "MarkTaskFalse", scope, attribute.Int64("task.id", id))
+	err := s.next.MarkTaskFalse(ctx, scope, id)
+	end(span, err)
+	return err
+}
+
+func (s *Storage) Close() error {
+	return s.next.Close()
+}
@@ -1,10 +1,15 @@
 package handler
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"time"
 
 	"rest_api/internal/auth"
+	"rest_api/internal/metrics"
 
 	"github.com/gin-gonic/gin"
 	"log/slog"
@@ -12,7 +17,7 @@ import (
 
 type AuthHandler struct {
 	service *auth.Service
-	log *slog.Logger
+	log     *slog.Logger
 }
 
 func NewAuthorization(service *auth.Service, log *slog.Logger) *AuthHandler {
@@ -23,33 +28,47 @@ func NewAuthorization(service *auth.Service, log *slog.Logger) *AuthHandler {
 func (h *AuthHandler) ListKeys(c *gin.Context) {
 	keys, err := h.service.ListKeys()
 	if err != nil {
-		h.log.Error("failed to list keys", slog.String("err", err.Error()))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not list keys"})
+		requestLog(c, h.log).Error("failed to list keys", slog.String("err", err.Error()))
+		abort(c, err)
 		return
 	}
-	h.log.Info("Api key listed successfully", slog.Int("count", len(keys)))
+	requestLog(c, h.log).Info("Api key listed successfully", slog.Int("count", len(keys)))
 	c.JSON(http.StatusOK, gin.H{"keys": keys})
 }
 
 // POST /register
 func (h *AuthHandler) Register(c *gin.Context) {
 	var req struct {
-		Owner string `json:"owner" binding:"required"`
+		Owner      string `json:"owner" binding:"required"`
+		InviteCode string `json:"invite_code"` // required in invite mode
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		h.log.Error("invalid register request", slog.String("err", err.Error()))
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		requestLog(c, h.log).Error("invalid register request", slog.String("err", err.Error()))
+		abort(c, invalidRequest(err))
 		return
 	}
 
-	key, err := h.service.RegisterAPIKey(req.Owner)
+	key, status, err := h.service.RegisterAPIKey(req.Owner, req.InviteCode)
 	if err != nil {
-		h.log.Error("failed to register api key", slog.String("err", err.Error()))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not list keys"})
+		if internal(err) {
+			requestLog(c, h.log).Error("failed to register api key", slog.String("err", err.Error()))
+		} else {
+			requestLog(c, h.log).Warn("api key registration refused", slog.String("owner", req.Owner), slog.String("err", err.Error()))
+		}
+		abort(c, err)
 		return
 	}
-	h.log.Info("API key registered successfully", slog.String("owner", req.Owner))
-	c.JSON(http.StatusOK, gin.H{"api_key": key})
+	metrics.KeyRegistered()
+	requestLog(c, h.log).Info("API key registered successfully", slog.String("owner", req.Owner), slog.String("status", status))
+	if status == auth.KeyPending {
+		c.JSON(http.StatusAccepted, gin.H{
+			"api_key": key,
+			"status":  status,
+			"message": "the key works once an admin approves the registration",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"api_key": key, "status": status})
 }
 
 // POST /admin/permission
@@ -58,20 +77,22 @@ func (h *AuthHandler) CreatePermission(c *gin.Context) {
 		Name string `json:"name" binding:"required"`
 	}
 
-	h.log.Debug("Processing CreatePermission request")
+	requestLog(c, h.log).Debug("Processing CreatePermission request")
 	if err := c.ShouldBindJSON(&req); err != nil {
-		h.log.Warn("Invalid create permission request", slog.String("error", err.Error()))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		requestLog(c, h.log).Warn("Invalid create permission request", slog.String("error", err.Error()))
+		abort(c, invalidRequest(err))
 		return
 	}
 
 	if err := h.service.CreatePermission(req.Name); err != nil {
-		h.log.Error("failed to create permission", slog.String("err", err.Error()))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not create permission"})
+		if internal(err) {
+			requestLog(c, h.log).Error("failed to create permission", slog.String("err", err.Error()))
+		}
+		abort(c, err)
 		return
 	}
-	
-	h.log.Info("Permission created successfully", slog.String("name", req.Name))
+
+	requestLog(c, h.log).Info("Permission created successfully", slog.String("name", req.Name))
 	c.JSON(http.StatusCreated, gin.H{"message": "permission created"})
 }
 
@@ -79,24 +100,26 @@ func (h *AuthHandler) CreatePermission(c *gin.Context) {
 func (h *AuthHandler) GrantPermission(c *gin.Context) {
 	keyID, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		h.log.Warn("Invalid key ID", slog.String("error", err.Error()))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key id"})
+		requestLog(c, h.log).Warn("Invalid key ID", slog.String("error", err.Error()))
+		abort(c, errInvalidKeyID)
 		return
 	}
 
 	var req struct {
 		PermissionID int64 `json:"permission" binding:"required"`
 	}
-	h.log.Debug("Processing GrantPermission request", slog.Int64("key_id", keyID))
+	requestLog(c, h.log).Debug("Processing GrantPermission request", slog.Int64("key_id", keyID))
 	if err := c.ShouldBindJSON(&req); err != nil {
-		h.log.Warn("Invalid grant permission request", slog.String("error", err.Error()))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		requestLog(c, h.log).Warn("Invalid grant permission request", slog.String("error", err.Error()))
+		abort(c, invalidRequest(err))
 		return
 	}
 
 	if err := h.service.GrantPermission(keyID, req.PermissionID); err != nil {
-		h.log.Error("failed to grand permission", slog.String("err", err.Error()))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not grant permission"})
+		if internal(err) {
+			requestLog(c, h.log).Error("failed to grand permission", slog.String("err", err.Error()))
+		}
+		abort(c, err)
 		return
 	}
 
@@ -105,13 +128,268 @@ func (h *AuthHandler) GrantPermission(c *gin.Context) {
 
 // GET /admin/permission
 func (h *AuthHandler) ListPermissions(c *gin.Context) {
-	h.log.Debug("Processing ListPermissions request")
-    perms, err := h.service.ListPermissions()
-    if err != nil {
-        h.log.Error("failed to list permissions", slog.String("err", err.Error()))
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "could not list permissions"})
-        return
-    }
-    h.log.Info("Permissions listed successfully", slog.Int("count", len(perms)))
-    c.JSON(http.StatusOK, gin.H{"permissions": perms})
-}
\ No newline at end of file
+	requestLog(c, h.log).Debug("Processing ListPermissions request")
+	perms, err := h.service.ListPermissions()
+	if err != nil {
+		requestLog(c, h.log).Error("failed to list permissions", slog.String("err", err.Error()))
+		abort(c, err)
+		return
+	}
+	requestLog(c, h.log).Info("Permissions listed successfully", slog.Int("count", len(perms)))
+	c.JSON(http.StatusOK, gin.H{"permissions": perms})
+}
+
+// POST /admin/keys/:id/revoke
+func (h *AuthHandler) RevokeKey(c *gin.Context) {
+	keyID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		requestLog(c, h.log).Warn("Invalid key ID", slog.String("error", err.Error()))
+		abort(c, errInvalidKeyID)
+		return
+	}
+
+	if err := h.service.RevokeKey(keyID); err != nil {
+		if internal(err) {
+			requestLog(c, h.log).Error("failed to revoke key", slog.Int64("key_id", keyID), slog.String("err", err.Error()))
+		}
+		abort(c, err)
+		return
+	}
+
+	requestLog(c, h.log).Info("API key revoked", slog.Int64("key_id", keyID))
+	c.JSON(http.StatusOK, gin.H{"message": "api key revoked"})
+}
+
+// POST /admin/keys/:id/rotate
+func (h *AuthHandler) RotateKey(c *gin.Context) {
+	keyID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		requestLog(c, h.log).Warn("Invalid key ID", slog.String("error", err.Error()))
+		abort(c, errInvalidKeyID)
+		return
+	}
+	h.rotateKey(c, keyID)
+}
+
+// rotates the key the request was authenticated with. The old secret of an
+// earlier rotation can't do it, or a leaked one could take the key over.
+// POST /keys/rotate
+func (h *AuthHandler) RotateOwnKey(c *gin.Context) {
+	keyID, ok := auth.KeyID(c)
+	if !ok {
+		abort(c, auth.ErrMissingCredentials)
+		return
+	}
+	if auth.UsedGraceSecret(c) {
+		requestLog(c, h.log).Warn("self-rotation with the old secret refused", slog.Int64("key_id", keyID))
+		abort(c, auth.ErrGraceSecret)
+		return
+	}
+	h.rotateKey(c, keyID)
+}
+
+// rotateKey reads the optional grace period ({"grace_period": "1h"}) and issues a new secret.
+// Non-admin keys can't keep their old secret working longer than auth.MaxSelfRotationGrace.
+func (h *AuthHandler) rotateKey(c *gin.Context, keyID int64) {
+	var req struct {
+		GracePeriod *string `json:"grace_period"` // Go duration, 24h by default, "0s" ends the old secret now
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		requestLog(c, h.log).Warn("Invalid rotate request", slog.String("error", err.Error()))
+		abort(c, invalidRequest(err))
+		return
+	}
+
+	grace := auth.DefaultRotationGrace
+	if req.GracePeriod != nil {
+		d, err := time.ParseDuration(*req.GracePeriod)
+		if err != nil || d < 0 {
+			abort(c, NewError(http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("grace_period %q must be a non-negative duration like 1h", *req.GracePeriod)))
+			return
+		}
+		grace = d
+	}
+	if !auth.IsAdmin(c) && grace > auth.MaxSelfRotationGrace {
+		grace = auth.MaxSelfRotationGrace
+	}
+
+	key, graceUntil, err := h.service.RotateKey(keyID, grace)
+	if err != nil {
+		if internal(err) {
+			requestLog(c, h.log).Error("failed to rotate key", slog.Int64("key_id", keyID), slog.String("err", err.Error()))
+		}
+		abort(c, err)
+		return
+	}
+
+	requestLog(c, h.log).Info("API key rotated", slog.Int64("key_id", keyID), slog.Time("old_key_valid_until", graceUntil))
+	c.JSON(http.StatusOK, gin.H{"api_key": key, "old_key_valid_until": graceUntil})
+}
+
+// sets or clears ({"expires_at": null}) the expiry of a key
+// PUT /admin/keys/:id/expiry
+func (h *AuthHandler) SetKeyExpiry(c *gin.Context) {
+	keyID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		requestLog(c, h.log).Warn("Invalid key ID", slog.String("error", err.Error()))
+		abort(c, errInvalidKeyID)
+		return
+	}
+
+	var req struct {
+		ExpiresAt *time.Time `json:"expires_at"` // RFC 3339
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		requestLog(c, h.log).Warn("Invalid expiry request", slog.String("error", err.Error()))
+		abort(c, invalidRequest(err))
+		return
+	}
+
+	if err := h.service.SetKeyExpiry(keyID, req.ExpiresAt); err != nil {
+		if internal(err) {
+			requestLog(c, h.log).Error("failed to set key expiry", slog.Int64("key_id", keyID), slog.String("err", err.Error()))
+		}
+		abort(c, err)
+		return
+	}
+
+	requestLog(c, h.log).Info("API key expiry set", slog.Int64("key_id", keyID))
+	c.JSON(http.StatusOK, gin.H{"message": "api key expiry set", "expires_at": req.ExpiresAt})
+}
+
+// GET /admin/keys/:id/permissions
+func (h *AuthHandler) ListKeyPermissions(c *gin.Context) {
+	keyID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		requestLog(c, h.log).Warn("Invalid key ID", slog.String("error", err.Error()))
+		abort(c, errInvalidKeyID)
+		return
+	}
+
+	perms, err := h.service.KeyPermissions(keyID)
+	if err != nil {
+		if internal(err) {
+			requestLog(c, h.log).Error("failed to list key permissions", slog.Int64("key_id", keyID), slog.String("err", err.Error()))
+		}
+		abort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"permissions": perms})
+}
+
+// DELETE /admin/keys/:id/permissions/:name
+func (h *AuthHandler) RevokePermission(c *gin.Context) {
+	keyID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		requestLog(c, h.log).Warn("Invalid key ID", slog.String("error", err.Error()))
+		abort(c, errInvalidKeyID)
+		return
+	}
+	name := c.Param("name")
+
+	if err := h.service.RevokePermission(keyID, name); err != nil {
+		if internal(err) {
+			requestLog(c, h.log).Error("failed to revoke permission", slog.Int64("key_id", keyID), slog.String("permission", name), slog.String("err", err.Error()))
+		}
+		abort(c, err)
+		return
+	}
+
+	requestLog(c, h.log).Info("Permission revoked", slog.Int64("key_id", keyID), slog.String("permission", name))
+	c.JSON(http.StatusOK, gin.H{"message": "permission revoked"})
+}
+
+// deletes the permission and revokes it from every key
+// DELETE /admin/permissions/:name
+func (h *AuthHandler) DeletePermission(c *gin.Context) {
+	name := c.Param("name")
+
+	revoked, err := h.service.DeletePermission(name)
+	if err != nil {
+		if internal(err) {
+			requestLog(c, h.log).Error("failed to delete permission", slog.String("permission", name), slog.String("err", err.Error()))
+		}
+		abort(c, err)
+		return
+	}
+
+	requestLog(c, h.log).Info("Permission deleted", slog.String("permission", name), slog.Int64("revoked_from_keys", revoked))
+	c.JSON(http.StatusOK, gin.H{"message": "permission deleted", "revoked_from_keys": revoked})
+}
+
+// explains why the key has or hasn't the permission
+// GET /admin/keys/:id/explain?permission=task.delete
+func (h *AuthHandler) ExplainPermission(c *gin.Context) {
+	keyID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		requestLog(c, h.log).Warn("Invalid key ID", slog.String("error", err.Error()))
+		abort(c, errInvalidKeyID)
+		return
+	}
+	permission := c.Query("permission")
+	if permission == "" {
+		abort(c, invalidParameter("query parameter 'permission' is required"))
+		return
+	}
+
+	explanation, err := h.service.ExplainPermission(keyID, permission)
+	if err != nil {
+		if internal(err) {
+			requestLog(c, h.log).Error("failed to explain permission", slog.Int64("key_id", keyID), slog.String("permission", permission), slog.String("err", err.Error()))
+		}
+		abort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, explanation)
+}
+
+// the limits set on the key and the ones in effect with its roles,
+// null falls back to the config defaults
+// GET /admin/keys/:id/limits
+func (h *AuthHandler) GetKeyLimits(c *gin.Context) {
+	keyID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		requestLog(c, h.log).Warn("Invalid key ID", slog.String("error", err.Error()))
+		abort(c, errInvalidKeyID)
+		return
+	}
+
+	own, effective, err := h.service.KeyLimits(c.Request.Context(), keyID)
+	if err != nil {
+		if internal(err) {
+			requestLog(c, h.log).Error("failed to get key limits", slog.Int64("key_id", keyID), slog.String("err", err.Error()))
+		}
+		abort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"limits": own, "effective": effective})
+}
+
+// replaces the limits of the key, omitted or null fields fall back to its roles
+// PUT /admin/keys/:id/limits
+func (h *AuthHandler) SetKeyLimits(c *gin.Context) {
+	keyID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		requestLog(c, h.log).Warn("Invalid key ID", slog.String("error", err.Error()))
+		abort(c, errInvalidKeyID)
+		return
+	}
+
+	var req auth.Limits
+	if err := c.ShouldBindJSON(&req); err != nil {
+		requestLog(c, h.log).Warn("Invalid limits request", slog.String("error", err.Error()))
+		abort(c, invalidRequest(err))
+		return
+	}
+
+	if err := h.service.SetKeyLimits(keyID, req); err != nil {
+		if internal(err) {
+			requestLog(c, h.log).Error("failed to set key limits", slog.Int64("key_id", keyID), slog.String("err", err.Error()))
+		}
+		abort(c, err)
+		return
+	}
+
+	requestLog(c, h.log).Info("API key limits set", slog.Int64("key_id", keyID))
+	c.JSON(http.StatusOK, gin.H{"message": "api key limits set", "limits": req})
+}
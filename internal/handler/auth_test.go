@@ -0,0 +1,231 @@
+package handler_test
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"rest_api/internal/auth"
+	"rest_api/internal/handler"
+)
+
+func TestCreatePermission(t *testing.T) {
+	s := newTestServer(t)
+
+	if w := s.do(t, http.MethodPost, "/admin/permissions", s.admin, `{"name":"report.read"}`); w.Code != http.StatusCreated {
+		t.Fatalf("POST /admin/permissions = %d %s", w.Code, w.Body)
+	}
+	w := s.do(t, http.MethodPost, "/admin/permissions", s.admin, `{"name":"report.read"}`)
+	expectProblem(t, w, http.StatusConflict, handler.CodePermissionExists)
+
+	w = s.do(t, http.MethodPost, "/admin/permissions", s.admin, `{"name":"report..read"}`)
+	expectProblem(t, w, http.StatusBadRequest, handler.CodeInvalidPermission)
+
+	w = s.do(t, http.MethodPost, "/admin/permissions", s.alice, `{"name":"report.write"}`)
+	expectProblem(t, w, http.StatusForbidden, handler.CodeForbidden)
+}
+
+func TestListPermissions(t *testing.T) {
+	s := newTestServer(t)
+
+	var resp struct {
+		Permissions []struct {
+			ID   int64  `json:"id"`
+			Name string `json:"name"`
+		} `json:"permissions"`
+	}
+	w := s.do(t, http.MethodGet, "/admin/permissions", s.admin, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /admin/permissions = %d %s", w.Code, w.Body)
+	}
+	decode(t, w, &resp)
+
+	var names []string
+	for _, p := range resp.Permissions {
+		if p.ID == 0 {
+			t.Errorf("permission %q without id in %s", p.Name, w.Body)
+		}
+		names = append(names, p.Name)
+	}
+	for _, seed := range auth.SeedPermissions {
+		found := false
+		for _, name := range names {
+			found = found || name == seed
+		}
+		if !found {
+			t.Errorf("permissions = %v, want %s listed", names, seed)
+		}
+	}
+	for i := 1; i < len(names); i++ {
+		if names[i] < names[i-1] {
+			t.Errorf("permissions = %v, want them ordered by name", names)
+			break
+		}
+	}
+}
+
+func TestListKeysHidesHashes(t *testing.T) {
+	s := newTestServer(t)
+
+	w := s.do(t, http.MethodGet, "/admin/keys", s.admin, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /admin/keys = %d %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Keys []map[string]any `json:"keys"`
+	}
+	decode(t, w, &resp)
+	if len(resp.Keys) != 4 {
+		t.Fatalf("GET /admin/keys = %d keys, want 4", len(resp.Keys))
+	}
+
+	hashes, err := s.auth.ListAPIKeys()
+	if err != nil {
+		t.Fatalf("list keys: %v", err)
+	}
+	for _, k := range hashes {
+		if strings.Contains(w.Body.String(), k.Key) {
+			t.Errorf("GET /admin/keys leaks the hash of key %d", k.ID)
+		}
+	}
+	for _, field := range []string{"id", "prefix", "owner", "revoked", "expires_at", "status"} {
+		if _, ok := resp.Keys[0][field]; !ok {
+			t.Errorf("key %v lacks %s", resp.Keys[0], field)
+		}
+	}
+	for field := range resp.Keys[0] {
+		if strings.ToLower(field) != field {
+			t.Errorf("key field %s, want snake_case", field)
+		}
+	}
+}
+
+func TestRotateOwnKey(t *testing.T) {
+	s := newTestServer(t)
+
+	var resp struct {
+		APIKey           string    `json:"api_key"`
+		OldKeyValidUntil time.Time `json:"old_key_valid_until"`
+	}
+	w := s.do(t, http.MethodPost, "/keys/rotate", s.alice, `{"grace_period":"720h"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /keys/rotate = %d %s", w.Code, w.Body)
+	}
+	decode(t, w, &resp)
+	// keys without admin keep their old secret for MaxSelfRotationGrace at most
+	if d := time.Until(resp.OldKeyValidUntil); d > auth.MaxSelfRotationGrace || d < auth.MaxSelfRotationGrace-time.Minute {
+		t.Errorf("old_key_valid_until in %v, want %v", d, auth.MaxSelfRotationGrace)
+	}
+
+	if w := s.do(t, http.MethodGet, "/task", s.alice, ""); w.Code != http.StatusOK {
+		t.Errorf("GET /task with the old secret during the grace period = %d %s", w.Code, w.Body)
+	}
+	if w := s.do(t, http.MethodGet, "/task", resp.APIKey, ""); w.Code != http.StatusOK {
+		t.Errorf("GET /task with the new secret = %d %s", w.Code, w.Body)
+	}
+
+	// a leaked old secret can't take the key over
+	w = s.do(t, http.MethodPost, "/keys/rotate", s.alice, "")
+	expectProblem(t, w, http.StatusForbidden, handler.CodeRotatedSecret)
+
+	if w := s.do(t, http.MethodPost, "/keys/rotate", resp.APIKey, `{"grace_period":"0s"}`); w.Code != http.StatusOK {
+		t.Fatalf("POST /keys/rotate with the new secret = %d %s", w.Code, w.Body)
+	}
+	w = s.do(t, http.MethodGet, "/task", resp.APIKey, "")
+	expectProblem(t, w, http.StatusUnauthorized, handler.CodeInvalidAPIKey)
+}
+
+func TestRevokeKey(t *testing.T) {
+	s := newTestServer(t)
+
+	key, err := s.auth.LookupKey(context.Background(), s.alice)
+	if err != nil || key == nil {
+		t.Fatalf("LookupKey: %v, %v", key, err)
+	}
+	path := "/admin/keys/" + strconv.FormatInt(key.ID, 10) + "/revoke"
+
+	w := s.do(t, http.MethodPost, path, s.bob, "")
+	expectProblem(t, w, http.StatusForbidden, handler.CodeForbidden)
+
+	if w := s.do(t, http.MethodPost, path, s.admin, ""); w.Code != http.StatusOK {
+		t.Fatalf("POST %s = %d %s", path, w.Code, w.Body)
+	}
+	w = s.do(t, http.MethodGet, "/task", s.alice, "")
+	expectProblem(t, w, http.StatusUnauthorized, handler.CodeInvalidAPIKey)
+
+	w = s.do(t, http.MethodPost, "/admin/keys/999/revoke", s.admin, "")
+	expectProblem(t, w, http.StatusNotFound, handler.CodeKeyNotFound)
+}
+
+func TestDeletePermission(t *testing.T) {
+	s := newTestServer(t)
+
+	if w := s.do(t, http.MethodPost, "/admin/permissions", s.admin, `{"name":"report.read"}`); w.Code != http.StatusCreated {
+		t.Fatalf("POST /admin/permissions = %d %s", w.Code, w.Body)
+	}
+	key, err := s.auth.LookupKey(context.Background(), s.alice)
+	if err != nil || key == nil {
+		t.Fatalf("LookupKey: %v, %v", key, err)
+	}
+	if err := s.auth.GrantPermission(strconv.FormatInt(key.ID, 10), "report.read"); err != nil {
+		t.Fatalf("grant: %v", err)
+	}
+
+	var resp struct {
+		RevokedFromKeys int64 `json:"revoked_from_keys"`
+	}
+	w := s.do(t, http.MethodDelete, "/admin/permissions/report.read", s.admin, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("DELETE /admin/permissions/report.read = %d %s", w.Code, w.Body)
+	}
+	decode(t, w, &resp)
+	if resp.RevokedFromKeys != 1 {
+		t.Errorf("revoked_from_keys = %d, want 1", resp.RevokedFromKeys)
+	}
+
+	w = s.do(t, http.MethodDelete, "/admin/permissions/report.read", s.admin, "")
+	expectProblem(t, w, http.StatusNotFound, handler.CodePermissionNotFound)
+	w = s.do(t, http.MethodDelete, "/admin/permissions/task.read", s.admin, "")
+	expectProblem(t, w, http.StatusConflict, handler.CodePermissionProtected)
+
+	// the seed permission still works
+	if w := s.do(t, http.MethodGet, "/task", s.reader, ""); w.Code != http.StatusOK {
+		t.Errorf("GET /task with task.read = %d %s", w.Code, w.Body)
+	}
+}
+
+func TestRevokeKeyPermission(t *testing.T) {
+	s := newTestServer(t)
+
+	key, err := s.auth.LookupKey(context.Background(), s.reader)
+	if err != nil || key == nil {
+		t.Fatalf("LookupKey: %v, %v", key, err)
+	}
+	path := "/admin/keys/" + strconv.FormatInt(key.ID, 10) + "/permissions"
+
+	var resp struct {
+		Permissions []auth.Permission `json:"permissions"`
+	}
+	w := s.do(t, http.MethodGet, path, s.admin, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d %s", path, w.Code, w.Body)
+	}
+	decode(t, w, &resp)
+	if len(resp.Permissions) != 1 || resp.Permissions[0].Name != "task.read" {
+		t.Errorf("permissions = %+v, want task.read", resp.Permissions)
+	}
+
+	if w := s.do(t, http.MethodDelete, path+"/task.read", s.admin, ""); w.Code != http.StatusOK {
+		t.Fatalf("DELETE %s/task.read = %d %s", path, w.Code, w.Body)
+	}
+	w = s.do(t, http.MethodGet, "/task", s.reader, "")
+	expectProblem(t, w, http.StatusForbidden, handler.CodeForbidden)
+
+	w = s.do(t, http.MethodDelete, path+"/task.read", s.admin, "")
+	expectProblem(t, w, http.StatusNotFound, handler.CodePermissionNotGranted)
+	w = s.do(t, http.MethodGet, "/admin/keys/999/permissions", s.admin, "")
+	expectProblem(t, w, http.StatusNotFound, handler.CodeKeyNotFound)
+}
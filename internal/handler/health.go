@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"rest_api/internal/auth"
+	"rest_api/internal/db/migrate"
+	"rest_api/internal/lib/buildinfo"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readyTimeout bounds the checks of one /readyz call
+const readyTimeout = 2 * time.Second
+
+// The HealthHandler answers the liveness, readiness and version probes.
+type HealthHandler struct {
+	db       *sql.DB
+	auth     *auth.Storage
+	migrator *migrate.Migrator
+	log      *slog.Logger
+}
+
+func NewHealthHandler(db *sql.DB, auth *auth.Storage, migrator *migrate.Migrator, log *slog.Logger) *HealthHandler {
+	return &HealthHandler{db: db, auth: auth, migrator: migrator, log: log}
+}
+
+// the process is up and serving requests
+// GET (/healthz)
+func (h *HealthHandler) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// the database is reachable and the auth tables exist
+// GET (/readyz)
+func (h *HealthHandler) Readyz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readyTimeout)
+	defer cancel()
+
+	ready := true
+	checks := gin.H{}
+	check := func(name string, err error) {
+		if err != nil {
+			// the error may name hosts or the DSN, it's logged only
+			requestLog(c, h.log).Warn("Readiness check failed", slog.String("check", name), slog.Any("error", err))
+			checks[name] = "failed"
+			ready = false
+			return
+		}
+		checks[name] = "ok"
+	}
+
+	check("database", h.db.PingContext(ctx))
+	check("auth_tables", h.auth.CheckTables(ctx))
+
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "checks": checks})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready", "checks": checks})
+}
+
+// build version, commit and schema migration level
+// GET (/version)
+func (h *HealthHandler) Version(c *gin.Context) {
+	schema := gin.H{"latest": h.migrator.Latest()}
+	version, err := h.migrator.Version(c.Request.Context())
+	if err != nil {
+		requestLog(c, h.log).Error("Failed to read schema version", slog.Any("error", err))
+		schema["error"] = "could not read schema version"
+	} else {
+		schema["version"] = version
+	}
+
+	c.JSON(http.StatusOK, gin.H{"build": buildinfo.Get(), "schema": schema})
+}
@@ -0,0 +1,73 @@
+package handler_test
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"rest_api/internal/auth"
+	storage "rest_api/internal/db"
+	"rest_api/internal/db/dbtest"
+	"rest_api/internal/db/migrate"
+	"rest_api/internal/db/sqlite"
+	"rest_api/internal/handler"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHealthProbes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, err := sqlite.Open(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	defer db.Close()
+	dbtest.Migrate(t, db, storage.DialectSQLite)
+	migrator, err := migrate.New(db, storage.DialectSQLite)
+	if err != nil {
+		t.Fatalf("migrate.New: %v", err)
+	}
+
+	h := handler.NewHealthHandler(db, auth.NewStorage(db, storage.DialectSQLite), migrator, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	r := gin.New()
+	r.GET("/readyz", h.Readyz)
+	r.GET("/version", h.Version)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	if w := get("/readyz"); w.Code != http.StatusOK {
+		t.Errorf("GET /readyz = %d %s", w.Code, w.Body)
+	}
+	var version struct {
+		Schema struct {
+			Version int `json:"version"`
+			Latest  int `json:"latest"`
+		} `json:"schema"`
+	}
+	w := get("/version")
+	decode(t, w, &version)
+	if version.Schema.Version != migrator.Latest() || version.Schema.Latest != migrator.Latest() {
+		t.Errorf("GET /version = %s, want schema version %d", w.Body, migrator.Latest())
+	}
+
+	// a failing check says so without the error of the driver
+	db.Close()
+	w = get("/readyz")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /readyz with a closed database = %d, want 503", w.Code)
+	}
+	var ready struct {
+		Checks map[string]string `json:"checks"`
+	}
+	decode(t, w, &ready)
+	if ready.Checks["database"] != "failed" || strings.Contains(w.Body.String(), "sql:") {
+		t.Errorf("GET /readyz = %s, want the database check failed without details", w.Body)
+	}
+}
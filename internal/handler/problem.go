@@ -0,0 +1,271 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"rest_api/internal/auth"
+	storage "rest_api/internal/db"
+	"rest_api/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// ProblemContentType is the media type of every error response (RFC 7807)
+const ProblemContentType = "application/problem+json"
+
+// problemTypePrefix + code is the type URI of a problem
+const problemTypePrefix = "urn:todo-api:problem:"
+
+// ErrorCode is a stable, machine-readable error identifier. Clients branch
+// on it, so existing codes must never change meaning.
+type ErrorCode string
+
+const (
+	CodeInvalidRequest       ErrorCode = "invalid_request"
+	CodeInvalidParameter     ErrorCode = "invalid_parameter"
+	CodeInvalidID            ErrorCode = "invalid_id"
+	CodeInvalidCursor        ErrorCode = "invalid_cursor"
+	CodeInvalidPatch         ErrorCode = "invalid_patch"
+	CodeInvalidTask          ErrorCode = "invalid_task"
+	CodeUnsupportedMediaType ErrorCode = "unsupported_media_type"
+	CodeUnauthorized         ErrorCode = "unauthorized"
+	CodeInvalidAPIKey        ErrorCode = "invalid_api_key"
+	CodeForbidden            ErrorCode = "forbidden"
+	CodeRouteNotFound        ErrorCode = "route_not_found"
+	CodeTaskNotFound         ErrorCode = "task_not_found"
+	CodeKeyNotFound          ErrorCode = "api_key_not_found"
+	CodeInvalidPermission    ErrorCode = "invalid_permission"
+	CodePermissionNotFound   ErrorCode = "permission_not_found"
+	CodePermissionExists     ErrorCode = "permission_exists"
+	CodePermissionNotGranted ErrorCode = "permission_not_granted"
+	CodePermissionProtected  ErrorCode = "permission_protected"
+	CodeRoleNotFound         ErrorCode = "role_not_found"
+	CodeRoleExists           ErrorCode = "role_exists"
+	CodeRoleProtected        ErrorCode = "role_protected"
+	CodeRoleNotAssigned      ErrorCode = "role_not_assigned"
+	CodeInvalidLimits        ErrorCode = "invalid_limits"
+	CodeRateLimited          ErrorCode = "rate_limited"
+	CodeQuotaExceeded        ErrorCode = "quota_exceeded"
+	CodeRotatedSecret        ErrorCode = "rotated_secret"
+	CodeOwnerReserved        ErrorCode = "owner_reserved"
+	CodeInviteRequired       ErrorCode = "invite_required"
+	CodeInvalidInvite        ErrorCode = "invalid_invite"
+	CodeInviteNotFound       ErrorCode = "invite_not_found"
+	CodeNotPending           ErrorCode = "registration_not_pending"
+	CodeInternal             ErrorCode = "internal"
+)
+
+// Error is an error with the HTTP status, code and client-facing detail it
+// is rendered with. The wrapped cause is logged but never sent.
+type Error struct {
+	Status int
+	Code   ErrorCode
+	Detail string
+	Err    error
+}
+
+func NewError(status int, code ErrorCode, detail string) *Error {
+	return &Error{Status: status, Code: code, Detail: detail}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Detail, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Detail)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Problem is the application/problem+json body of an error response
+type Problem struct {
+	Type      string    `json:"type"`
+	Title     string    `json:"title"`
+	Status    int       `json:"status"`
+	Detail    string    `json:"detail,omitempty"`
+	Instance  string    `json:"instance,omitempty"`
+	Code      ErrorCode `json:"code"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+var (
+	errInvalidID     = NewError(http.StatusBadRequest, CodeInvalidID, "id must be an integer")
+	errInvalidKeyID  = NewError(http.StatusBadRequest, CodeInvalidID, "key id must be an integer")
+	errRouteNotFound = NewError(http.StatusNotFound, CodeRouteNotFound, "no such route")
+)
+
+// problemErrors translates the errors of the storage and auth layers
+var problemErrors = []struct {
+	err error
+	*Error
+}{
+	{storage.ErrTaskNotFound, NewError(http.StatusNotFound, CodeTaskNotFound, "task not found")},
+	{storage.ErrInvalidCursor, NewError(http.StatusBadRequest, CodeInvalidCursor, "invalid cursor")},
+	{auth.ErrMissingCredentials, NewError(http.StatusUnauthorized, CodeUnauthorized, "missing api key, send it as Authorization: Bearer <key> or X-API-Key: <key>")},
+	{auth.ErrInvalidKey, NewError(http.StatusUnauthorized, CodeInvalidAPIKey, "invalid api key")},
+	{auth.ErrForbidden, NewError(http.StatusForbidden, CodeForbidden, "the api key lacks the permission for this request")},
+	{auth.ErrGraceSecret, NewError(http.StatusForbidden, CodeRotatedSecret, "the old secret of a rotated key can't rotate it, use the current secret")},
+	{auth.ErrKeyNotFound, NewError(http.StatusNotFound, CodeKeyNotFound, "api key not found")},
+	{auth.ErrInvalidPermission, NewError(http.StatusBadRequest, CodeInvalidPermission, "invalid permission name, use dotted names like task.create, a wildcard is only allowed as the last segment (task.*)")},
+	{auth.ErrPermissionNotFound, NewError(http.StatusNotFound, CodePermissionNotFound, "permission not found")},
+	{auth.ErrPermissionExists, NewError(http.StatusConflict, CodePermissionExists, "permission already exists")},
+	{auth.ErrPermissionNotGranted, NewError(http.StatusNotFound, CodePermissionNotGranted, "permission not granted")},
+	{auth.ErrPermissionProtected, NewError(http.StatusConflict, CodePermissionProtected, "permission is built in and can't be deleted")},
+	{auth.ErrRoleNotFound, NewError(http.StatusNotFound, CodeRoleNotFound, "role not found")},
+	{auth.ErrRoleExists, NewError(http.StatusConflict, CodeRoleExists, "role already exists")},
+	{auth.ErrRoleProtected, NewError(http.StatusConflict, CodeRoleProtected, "role is built in and can't be deleted")},
+	{auth.ErrRoleNotAssigned, NewError(http.StatusNotFound, CodeRoleNotAssigned, "role not assigned to the key")},
+	{auth.ErrInvalidLimits, NewError(http.StatusBadRequest, CodeInvalidLimits, "limits must not be negative, 0 is unlimited")},
+	{auth.ErrOwnerReserved, NewError(http.StatusForbidden, CodeOwnerReserved, "this owner name is reserved")},
+	{auth.ErrInviteRequired, NewError(http.StatusForbidden, CodeInviteRequired, "registration is invite-only, an invite_code is required")},
+	{auth.ErrInvalidInvite, NewError(http.StatusForbidden, CodeInvalidInvite, "invite code is invalid, already used or expired")},
+	{auth.ErrInviteNotFound, NewError(http.StatusNotFound, CodeInviteNotFound, "invite not found or already used")},
+	{auth.ErrNotPending, NewError(http.StatusConflict, CodeNotPending, "registration is not pending")},
+	{middleware.ErrRateLimited, NewError(http.StatusTooManyRequests, CodeRateLimited, "rate limit exceeded, retry after the Retry-After seconds")},
+	{middleware.ErrQuotaExceeded, NewError(http.StatusTooManyRequests, CodeQuotaExceeded, "daily task quota exceeded, it resets at midnight UTC")},
+}
+
+// problemFor returns the Error err is rendered as, anything unknown is an
+// internal error
+func problemFor(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	for _, p := range problemErrors {
+		if errors.Is(err, p.err) {
+			return &Error{Status: p.Status, Code: p.Code, Detail: p.Detail, Err: err}
+		}
+	}
+	return &Error{Status: http.StatusInternalServerError, Code: CodeInternal, Detail: "internal error", Err: err}
+}
+
+// internal reports whether err is rendered as a server error
+func internal(err error) bool {
+	return problemFor(err).Status >= http.StatusInternalServerError
+}
+
+// abort stops the request, ErrorHandler renders err
+func abort(c *gin.Context, err error) {
+	_ = c.Error(err)
+	c.Abort()
+}
+
+// ErrorHandler renders the last error recorded with c.Error as a problem
+// response, unless a response was written already. It must run before the
+// handlers and the auth middleware that report errors.
+func ErrorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+
+		e := problemFor(c.Errors.Last().Err)
+		c.Header("Content-Type", ProblemContentType)
+		c.JSON(e.Status, Problem{
+			Type:      problemTypePrefix + string(e.Code),
+			Title:     http.StatusText(e.Status),
+			Status:    e.Status,
+			Detail:    e.Detail,
+			Instance:  c.Request.URL.Path,
+			Code:      e.Code,
+			RequestID: middleware.GetRequestID(c),
+		})
+	}
+}
+
+// NoRoute answers requests to unknown routes
+func NoRoute(c *gin.Context) {
+	abort(c, errRouteNotFound)
+}
+
+// invalidParameter is a bad query parameter, detail names it
+func invalidParameter(detail string) *Error {
+	return NewError(http.StatusBadRequest, CodeInvalidParameter, detail)
+}
+
+// invalidRequest describes why a request body couldn't be bound without
+// echoing decoder internals
+func invalidRequest(err error) *Error {
+	e := NewError(http.StatusBadRequest, CodeInvalidRequest, bindingDetail(err))
+	e.Err = err
+	return e
+}
+
+func bindingDetail(err error) string {
+	var (
+		verrs     validator.ValidationErrors
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+		timeErr   *time.ParseError
+	)
+	switch {
+	case errors.As(err, &verrs):
+		fields := make([]string, 0, len(verrs))
+		for _, fe := range verrs {
+			if fe.Tag() == "required" {
+				fields = append(fields, fe.Field()+" is required")
+			} else {
+				fields = append(fields, fmt.Sprintf("%s failed the %s check", fe.Field(), fe.Tag()))
+			}
+		}
+		return strings.Join(fields, ", ")
+	case errors.Is(err, io.EOF):
+		return "request body is empty"
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return "request body is not valid JSON"
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return "request body must be a JSON object"
+		}
+		return fmt.Sprintf("%s must be a %s", typeErr.Field, jsonType(typeErr.Type))
+	case errors.As(err, &timeErr):
+		return "timestamps must be RFC 3339 with a timezone offset"
+	case errors.Is(err, storage.ErrUnknownPriority):
+		return err.Error()
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return strings.TrimPrefix(err.Error(), "json: ")
+	}
+	return "invalid request body"
+}
+
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+	return "object"
+}
+
+// validation errors name fields as they appear in JSON
+func init() {
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(f reflect.StructField) string {
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "" || name == "-" {
+				return f.Name
+			}
+			return name
+		})
+	}
+}
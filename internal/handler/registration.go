@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"rest_api/internal/auth"
+
+	"github.com/gin-gonic/gin"
+	"log/slog"
+)
+
+// issues a single-use invite code, it's shown only in this response
+// POST /admin/invites
+func (h *AuthHandler) CreateInvite(c *gin.Context) {
+	var req struct {
+		ExpiresIn *string `json:"expires_in"` // Go duration, registration.invite_ttl by default
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		requestLog(c, h.log).Warn("Invalid create invite request", slog.String("error", err.Error()))
+		abort(c, invalidRequest(err))
+		return
+	}
+
+	var ttl time.Duration
+	if req.ExpiresIn != nil {
+		d, err := time.ParseDuration(*req.ExpiresIn)
+		if err != nil || d <= 0 {
+			abort(c, NewError(http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("expires_in %q must be a positive duration like 72h", *req.ExpiresIn)))
+			return
+		}
+		ttl = d
+	}
+
+	keyID, ok := auth.KeyID(c)
+	if !ok {
+		abort(c, auth.ErrMissingCredentials)
+		return
+	}
+
+	code, invite, err := h.service.CreateInvite(keyID, ttl)
+	if err != nil {
+		requestLog(c, h.log).Error("failed to create invite", slog.String("err", err.Error()))
+		abort(c, err)
+		return
+	}
+
+	requestLog(c, h.log).Info("Invite created", slog.Int64("invite_id", invite.ID))
+	c.JSON(http.StatusCreated, gin.H{
+		"id":          invite.ID,
+		"invite_code": code,
+		"expires_at":  invite.ExpiresAt,
+	})
+}
+
+// GET /admin/invites
+func (h *AuthHandler) ListInvites(c *gin.Context) {
+	invites, err := h.service.ListInvites()
+	if err != nil {
+		requestLog(c, h.log).Error("failed to list invites", slog.String("err", err.Error()))
+		abort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"invites": invites})
+}
+
+// withdraws an unused invite
+// DELETE /admin/invites/:id
+func (h *AuthHandler) DeleteInvite(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		abort(c, errInvalidID)
+		return
+	}
+
+	if err := h.service.DeleteInvite(id); err != nil {
+		if internal(err) {
+			requestLog(c, h.log).Error("failed to delete invite", slog.Int64("invite_id", id), slog.String("err", err.Error()))
+		}
+		abort(c, err)
+		return
+	}
+
+	requestLog(c, h.log).Info("Invite deleted", slog.Int64("invite_id", id))
+	c.JSON(http.StatusOK, gin.H{"message": "invite deleted"})
+}
+
+// lists the keys waiting for approval
+// GET /admin/registrations
+func (h *AuthHandler) ListRegistrations(c *gin.Context) {
+	keys, err := h.service.PendingRegistrations()
+	if err != nil {
+		requestLog(c, h.log).Error("failed to list registrations", slog.String("err", err.Error()))
+		abort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"registrations": keys})
+}
+
+// POST /admin/registrations/:id/approve
+func (h *AuthHandler) ApproveRegistration(c *gin.Context) {
+	h.decideRegistration(c, "approved", h.service.ApproveRegistration)
+}
+
+// POST /admin/registrations/:id/reject
+func (h *AuthHandler) RejectRegistration(c *gin.Context) {
+	h.decideRegistration(c, "rejected", h.service.RejectRegistration)
+}
+
+func (h *AuthHandler) decideRegistration(c *gin.Context, outcome string, decide func(int64) error) {
+	keyID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		abort(c, errInvalidKeyID)
+		return
+	}
+
+	if err := decide(keyID); err != nil {
+		if internal(err) {
+			requestLog(c, h.log).Error("failed to decide registration", slog.Int64("key_id", keyID), slog.String("err", err.Error()))
+		}
+		abort(c, err)
+		return
+	}
+
+	requestLog(c, h.log).Info("Registration "+outcome, slog.Int64("key_id", keyID))
+	c.JSON(http.StatusOK, gin.H{"message": "registration " + outcome})
+}
@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"rest_api/internal/auth"
+
+	"github.com/gin-gonic/gin"
+	"log/slog"
+)
+
+// GET /admin/roles
+func (h *AuthHandler) ListRoles(c *gin.Context) {
+	roles, err := h.service.ListRoles()
+	if err != nil {
+		requestLog(c, h.log).Error("failed to list roles", slog.String("err", err.Error()))
+		abort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+// POST /admin/roles
+func (h *AuthHandler) CreateRole(c *gin.Context) {
+	var req struct {
+		Name        string   `json:"name" binding:"required"`
+		Permissions []string `json:"permissions"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		requestLog(c, h.log).Warn("Invalid create role request", slog.String("error", err.Error()))
+		abort(c, invalidRequest(err))
+		return
+	}
+
+	role, err := h.service.CreateRole(req.Name, req.Permissions)
+	if err != nil {
+		if errors.Is(err, auth.ErrPermissionNotFound) {
+			err = &Error{Status: http.StatusBadRequest, Code: CodePermissionNotFound, Detail: "permissions must exist before they are added to a role", Err: err}
+		}
+		if internal(err) {
+			requestLog(c, h.log).Error("failed to create role", slog.String("role", req.Name), slog.String("err", err.Error()))
+		}
+		abort(c, err)
+		return
+	}
+
+	requestLog(c, h.log).Info("Role created", slog.String("role", role.Name), slog.Any("permissions", role.Permissions))
+	c.JSON(http.StatusCreated, role)
+}
+
+// deletes the role and unassigns it from every key
+// DELETE /admin/roles/:name
+func (h *AuthHandler) DeleteRole(c *gin.Context) {
+	name := c.Param("name")
+
+	unassigned, err := h.service.DeleteRole(name)
+	if err != nil {
+		if internal(err) {
+			requestLog(c, h.log).Error("failed to delete role", slog.String("role", name), slog.String("err", err.Error()))
+		}
+		abort(c, err)
+		return
+	}
+
+	requestLog(c, h.log).Info("Role deleted", slog.String("role", name), slog.Int64("unassigned_from_keys", unassigned))
+	c.JSON(http.StatusOK, gin.H{"message": "role deleted", "unassigned_from_keys": unassigned})
+}
+
+// POST /admin/roles/:name/permissions
+func (h *AuthHandler) GrantRolePermission(c *gin.Context) {
+	name := c.Param("name")
+
+	var req struct {
+		Permission string `json:"permission" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		requestLog(c, h.log).Warn("Invalid grant role permission request", slog.String("error", err.Error()))
+		abort(c, invalidRequest(err))
+		return
+	}
+
+	if err := h.service.GrantRolePermission(name, req.Permission); err != nil {
+		if internal(err) {
+			requestLog(c, h.log).Error("failed to grant role permission", slog.String("role", name), slog.String("permission", req.Permission), slog.String("err", err.Error()))
+		}
+		abort(c, err)
+		return
+	}
+
+	requestLog(c, h.log).Info("Role permission granted", slog.String("role", name), slog.String("permission", req.Permission))
+	c.JSON(http.StatusOK, gin.H{"message": "permission granted"})
+}
+
+// DELETE /admin/roles/:name/permissions/:permission
+func (h *AuthHandler) RevokeRolePermission(c *gin.Context) {
+	name, perm := c.Param("name"), c.Param("permission")
+
+	if err := h.service.RevokeRolePermission(name, perm); err != nil {
+		if internal(err) {
+			requestLog(c, h.log).Error("failed to revoke role permission", slog.String("role", name), slog.String("permission", perm), slog.String("err", err.Error()))
+		}
+		abort(c, err)
+		return
+	}
+
+	requestLog(c, h.log).Info("Role permission revoked", slog.String("role", name), slog.String("permission", perm))
+	c.JSON(http.StatusOK, gin.H{"message": "permission revoked"})
+}
+
+// GET /admin/keys/:id/roles
+func (h *AuthHandler) ListKeyRoles(c *gin.Context) {
+	keyID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		requestLog(c, h.log).Warn("Invalid key ID", slog.String("error", err.Error()))
+		abort(c, errInvalidKeyID)
+		return
+	}
+
+	roles, err := h.service.KeyRoles(keyID)
+	if err != nil {
+		if internal(err) {
+			requestLog(c, h.log).Error("failed to list key roles", slog.Int64("key_id", keyID), slog.String("err", err.Error()))
+		}
+		abort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+// POST /admin/keys/:id/roles
+func (h *AuthHandler) AssignRole(c *gin.Context) {
+	keyID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		requestLog(c, h.log).Warn("Invalid key ID", slog.String("error", err.Error()))
+		abort(c, errInvalidKeyID)
+		return
+	}
+
+	var req struct {
+		Role string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		requestLog(c, h.log).Warn("Invalid assign role request", slog.String("error", err.Error()))
+		abort(c, invalidRequest(err))
+		return
+	}
+
+	if err := h.service.AssignRole(keyID, req.Role); err != nil {
+		if internal(err) {
+			requestLog(c, h.log).Error("failed to assign role", slog.Int64("key_id", keyID), slog.String("role", req.Role), slog.String("err", err.Error()))
+		}
+		abort(c, err)
+		return
+	}
+
+	requestLog(c, h.log).Info("Role assigned", slog.Int64("key_id", keyID), slog.String("role", req.Role))
+	c.JSON(http.StatusOK, gin.H{"message": "role assigned"})
+}
+
+// DELETE /admin/keys/:id/roles/:role
+func (h *AuthHandler) UnassignRole(c *gin.Context) {
+	keyID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		requestLog(c, h.log).Warn("Invalid key ID", slog.String("error", err.Error()))
+		abort(c, errInvalidKeyID)
+		return
+	}
+	role := c.Param("role")
+
+	if err := h.service.UnassignRole(keyID, role); err != nil {
+		if internal(err) {
+			requestLog(c, h.log).Error("failed to unassign role", slog.Int64("key_id", keyID), slog.String("role", role), slog.String("err", err.Error()))
+		}
+		abort(c, err)
+		return
+	}
+
+	requestLog(c, h.log).Info("Role unassigned", slog.Int64("key_id", keyID), slog.String("role", role))
+	c.JSON(http.StatusOK, gin.H{"message": "role unassigned"})
+}
+
+// replaces the limits of the role, omitted or null fields fall back to the config
+// PUT /admin/roles/:name/limits
+func (h *AuthHandler) SetRoleLimits(c *gin.Context) {
+	name := c.Param("name")
+
+	var req auth.Limits
+	if err := c.ShouldBindJSON(&req); err != nil {
+		requestLog(c, h.log).Warn("Invalid role limits request", slog.String("error", err.Error()))
+		abort(c, invalidRequest(err))
+		return
+	}
+
+	if err := h.service.SetRoleLimits(name, req); err != nil {
+		if internal(err) {
+			requestLog(c, h.log).Error("failed to set role limits", slog.String("role", name), slog.String("err", err.Error()))
+		}
+		abort(c, err)
+		return
+	}
+
+	requestLog(c, h.log).Info("Role limits set", slog.String("role", name))
+	c.JSON(http.StatusOK, gin.H{"message": "role limits set", "limits": req})
+}
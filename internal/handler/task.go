@@ -1,64 +1,101 @@
 package handler
 
 import (
-	"database/sql"
+	"bytes"
+	"encoding/json"
 	"errors"
-	"net/http"
-	"strconv"
 	"fmt"
+	"io"
 	"log/slog"
-	
-	"rest_api/internal/db/sqlite"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"rest_api/internal/auth"
+	storage "rest_api/internal/db"
+	sl "rest_api/internal/lib/logger/slog"
+	"rest_api/internal/lib/mergepatch"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 )
 
 // The TaskHandler is responsible for processing HTTP requests related to tasks (creating, receiving, updating, deleting).
 type TaskHandler struct {
-	storage *sqlite.Storage
-	log 	*slog.Logger
+	storage storage.TaskRepository
+	log     *slog.Logger
 }
 
-func NewTaskHandler(s *sqlite.Storage, log *slog.Logger) *TaskHandler {
+func NewTaskHandler(s storage.TaskRepository, log *slog.Logger) *TaskHandler {
 	return &TaskHandler{storage: s, log: log}
 }
 
 type NewTask struct {
-	Title 	string 	`json:"title" binding:"required"`
+	Title       string            `json:"title" binding:"required"`
+	Description string            `json:"description"`
+	DueAt       *time.Time        `json:"due_at"`   // RFC 3339 with a timezone offset
+	Priority    *storage.Priority `json:"priority"` // low, normal (default), high or urgent
 }
 
-//get id
+// get id
 func GetID(c *gin.Context) (int64, error) {
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
 		return 0, fmt.Errorf("invalid id")
-	} 
+	}
 	return id, nil
 }
+
+// requestLog returns the request-scoped logger set by middleware.Logger,
+// or fallback outside of a request
+func requestLog(c *gin.Context, fallback *slog.Logger) *slog.Logger {
+	return sl.FromContext(c.Request.Context(), fallback)
+}
+
+// ownerScope limits storage calls to the tasks of the calling API key,
+// admin keys see the tasks of every owner
+func ownerScope(c *gin.Context) storage.Scope {
+	if auth.IsAdmin(c) {
+		return storage.AnyOwner()
+	}
+	keyID, _ := auth.KeyID(c)
+	return storage.OwnedBy(keyID)
+}
+
 // gets the title from json and creates a task in the database. Sends back the task(json)
 // POST (/task)
 func (h *TaskHandler) CreateTask(c *gin.Context) {
 	var req NewTask
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		h.log.Warn("Invalid request", slog.String("error", err.Error()))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		requestLog(c, h.log).Warn("Invalid request", slog.String("error", err.Error()))
+		abort(c, invalidRequest(err))
 		return
 	}
 
-	id, err := h.storage.AddTask(req.Title)
+	task := storage.Task{
+		Title:       req.Title,
+		Description: req.Description,
+		DueAt:       req.DueAt,
+		Priority:    storage.PriorityNormal,
+	}
+	if req.Priority != nil {
+		task.Priority = *req.Priority
+	}
+	if keyID, ok := auth.KeyID(c); ok {
+		task.OwnerKeyID = &keyID
+	}
+
+	task, err := h.storage.AddTask(c.Request.Context(), task)
 	if err != nil {
-		h.log.Error("Failed to create task", slog.String("title", req.Title), slog.Any("error", err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create task: " + err.Error()})
-	 	return
+		requestLog(c, h.log).Error("Failed to create task", slog.String("title", req.Title), slog.Any("error", err))
+		abort(c, err)
+		return
 	}
 
-	h.log.Info("Task created successfully", slog.Int64("id", id), slog.String("title", req.Title))
-	c.JSON(http.StatusCreated, gin.H{
-		"id": id,
-		"title": req.Title,
-		"completed": false,
-	})
+	requestLog(c, h.log).Info("Task created successfully", slog.Int64("id", task.ID), slog.String("title", task.Title))
+	c.JSON(http.StatusCreated, task)
 }
 
 // returns the corresponding task
@@ -66,31 +103,25 @@ func (h *TaskHandler) CreateTask(c *gin.Context) {
 func (h *TaskHandler) GetTaskByID(c *gin.Context) {
 	id, err := GetID(c)
 	if err != nil {
-		h.log.Warn("Invalid task ID", slog.String("error", err.Error()))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+		requestLog(c, h.log).Warn("Invalid task ID", slog.String("error", err.Error()))
+		abort(c, errInvalidID)
 		return
 	}
 
-	h.log.Debug("Fetching task", slog.Int64("id", id))
-	title, completed, err := h.storage.GetTaskByID(id)
+	requestLog(c, h.log).Debug("Fetching task", slog.Int64("id", id))
+	task, err := h.storage.GetTaskByID(c.Request.Context(), ownerScope(c), id)
 	if err != nil {
-	if errors.Is(err, sql.ErrNoRows) {
-		h.log.Warn("Task not found", slog.Int64("id", id))
-		c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
+		if errors.Is(err, storage.ErrTaskNotFound) {
+			requestLog(c, h.log).Warn("Task not found", slog.Int64("id", id))
+		} else {
+			requestLog(c, h.log).Error("Failed to get task", slog.Int64("id", id), slog.Any("error", err))
+		}
+		abort(c, err)
 		return
 	}
 
-	h.log.Error("Failed to get task", slog.Int64("id", id), slog.Any("error", err))
-	c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get task"})
-	return
-	}
-
-	h.log.Info("Task retrieved successfully", slog.Int64("id", id), slog.String("title", title))
-	c.JSON(http.StatusOK, gin.H{
-		"id": id,
-		"title": title,
-		"completed": completed,
-	})
+	requestLog(c, h.log).Info("Task retrieved successfully", slog.Int64("id", id), slog.String("title", task.Title))
+	c.JSON(http.StatusOK, task)
 }
 
 // delete corresponding task in the db
@@ -98,16 +129,20 @@ func (h *TaskHandler) GetTaskByID(c *gin.Context) {
 func (h *TaskHandler) DeleteTaskByID(c *gin.Context) {
 	id, err := GetID(c)
 	if err != nil {
-		h.log.Warn("Invalid task ID", slog.String("error", err.Error()))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+		requestLog(c, h.log).Warn("Invalid task ID", slog.String("error", err.Error()))
+		abort(c, errInvalidID)
 		return
 	}
 
-	h.log.Debug("Deleting task", slog.Int64("id", id))
-	err = h.storage.DeleteTaskByID(id)
+	requestLog(c, h.log).Debug("Deleting task", slog.Int64("id", id))
+	err = h.storage.DeleteTaskByID(c.Request.Context(), ownerScope(c), id)
 	if err != nil {
-		h.log.Error("Failed to delete task", slog.Int64("id", id), slog.Any("error", err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete task"})
+		if errors.Is(err, storage.ErrTaskNotFound) {
+			requestLog(c, h.log).Warn("Task not found", slog.Int64("id", id))
+		} else {
+			requestLog(c, h.log).Error("Failed to delete task", slog.Int64("id", id), slog.Any("error", err))
+		}
+		abort(c, err)
 		return
 	}
 
@@ -119,39 +154,326 @@ func (h *TaskHandler) DeleteTaskByID(c *gin.Context) {
 func (h *TaskHandler) CompletedTask(c *gin.Context) {
 	id, err := GetID(c)
 	if err != nil {
-		h.log.Warn("Invalid task ID", slog.String("error", err.Error()))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		requestLog(c, h.log).Warn("Invalid task ID", slog.String("error", err.Error()))
+		abort(c, errInvalidID)
 		return
 	}
 
-	h.log.Debug("Marking task as completed", slog.Int64("id", id))
-	err = h.storage.MarkTaskTrue(id)
+	requestLog(c, h.log).Debug("Marking task as completed", slog.Int64("id", id))
+	err = h.storage.MarkTaskTrue(c.Request.Context(), ownerScope(c), id)
 	if err != nil {
-		h.log.Error("Failed to mark task completed", slog.Int64("id", id), slog.Any("error", err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update task"})
+		if errors.Is(err, storage.ErrTaskNotFound) {
+			requestLog(c, h.log).Warn("Task not found", slog.Int64("id", id))
+		} else {
+			requestLog(c, h.log).Error("Failed to mark task completed", slog.Int64("id", id), slog.Any("error", err))
+		}
+		abort(c, err)
 		return
 	}
 
-	h.log.Info("Task marked as completed", slog.Int64("id", id))
+	requestLog(c, h.log).Info("Task marked as completed", slog.Int64("id", id))
 	c.JSON(http.StatusOK, gin.H{"message": "task updated"})
 }
 
 func (h *TaskHandler) UncompletedTask(c *gin.Context) {
 	id, err := GetID(c)
 	if err != nil {
-		h.log.Warn("Invalid task ID", slog.String("error", err.Error()))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		requestLog(c, h.log).Warn("Invalid task ID", slog.String("error", err.Error()))
+		abort(c, errInvalidID)
 		return
 	}
 
-	h.log.Debug("Marking task as uncompleted", slog.Int64("id", id))
-	err = h.storage.MarkTaskFalse(id)
+	requestLog(c, h.log).Debug("Marking task as uncompleted", slog.Int64("id", id))
+	err = h.storage.MarkTaskFalse(c.Request.Context(), ownerScope(c), id)
 	if err != nil {
-		h.log.Error("Failed to mark task uncompleted", slog.Int64("id", id), slog.Any("error", err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update task"})
+		if errors.Is(err, storage.ErrTaskNotFound) {
+			requestLog(c, h.log).Warn("Task not found", slog.Int64("id", id))
+		} else {
+			requestLog(c, h.log).Error("Failed to mark task uncompleted", slog.Int64("id", id), slog.Any("error", err))
+		}
+		abort(c, err)
 		return
 	}
 
-	h.log.Info("Task marked as uncompleted", slog.Int64("id", id))
+	requestLog(c, h.log).Info("Task marked as uncompleted", slog.Int64("id", id))
 	c.JSON(http.StatusOK, gin.H{"message": "task updated"})
-}
\ No newline at end of file
+}
+
+// returns a page of tasks, optionally filtered by completed state and sorted
+// GET (/task?limit=&cursor=&completed=&priority=&due_before=&due_after=&sort=&order=)
+func (h *TaskHandler) ListTasks(c *gin.Context) {
+	opts, err := parseListOptions(c)
+	if err != nil {
+		requestLog(c, h.log).Warn("Invalid list request", slog.String("error", err.Error()))
+		abort(c, invalidParameter(err.Error()))
+		return
+	}
+
+	requestLog(c, h.log).Debug("Listing tasks", slog.Int("limit", opts.Limit), slog.String("sort", string(opts.Sort)))
+	page, err := h.storage.ListTasks(c.Request.Context(), ownerScope(c), opts)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidCursor) {
+			requestLog(c, h.log).Warn("Invalid cursor", slog.String("cursor", opts.Cursor))
+		} else {
+			requestLog(c, h.log).Error("Failed to list tasks", slog.Any("error", err))
+		}
+		abort(c, err)
+		return
+	}
+
+	resp := gin.H{
+		"tasks": page.Tasks,
+		"total": page.Total,
+	}
+	if page.NextCursor != "" {
+		resp["next_cursor"] = page.NextCursor
+	}
+
+	requestLog(c, h.log).Info("Tasks listed successfully", slog.Int("count", len(page.Tasks)), slog.Int("total", page.Total))
+	c.JSON(http.StatusOK, resp)
+}
+
+// parseListOptions reads pagination, filter and sort parameters from the query string
+func parseListOptions(c *gin.Context) (storage.ListOptions, error) {
+	opts := storage.ListOptions{
+		Cursor: c.Query("cursor"),
+		Sort:   storage.SortByID,
+	}
+
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > storage.MaxListLimit {
+			return opts, fmt.Errorf("limit must be between 1 and %d", storage.MaxListLimit)
+		}
+		opts.Limit = limit
+	}
+
+	if v := c.Query("completed"); v != "" {
+		completed, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("completed must be true or false")
+		}
+		opts.Completed = &completed
+	}
+
+	if v := c.Query("priority"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			p, err := storage.ParsePriority(strings.TrimSpace(name))
+			if err != nil {
+				return opts, err
+			}
+			opts.Priorities = append(opts.Priorities, p)
+		}
+	}
+
+	for param, dst := range map[string]**time.Time{"due_before": &opts.DueBefore, "due_after": &opts.DueAfter} {
+		if v := c.Query(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return opts, fmt.Errorf("%s must be an RFC 3339 timestamp", param)
+			}
+			*dst = &t
+		}
+	}
+
+	if v := c.Query("sort"); v != "" {
+		if !storage.ValidSortField(storage.SortField(v)) {
+			return opts, fmt.Errorf("unsupported sort field %q", v)
+		}
+		opts.Sort = storage.SortField(v)
+	}
+
+	switch c.DefaultQuery("order", "asc") {
+	case "asc":
+	case "desc":
+		opts.Desc = true
+	default:
+		return opts, fmt.Errorf("order must be asc or desc")
+	}
+
+	return opts, nil
+}
+
+// returns tasks matching the full-text query, best matches first
+// GET (/task/search?q=&limit=)
+func (h *TaskHandler) SearchTasks(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		requestLog(c, h.log).Warn("Empty search query")
+		abort(c, invalidParameter("q is required"))
+		return
+	}
+
+	limit := storage.DefaultSearchLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > storage.MaxSearchLimit {
+			requestLog(c, h.log).Warn("Invalid search limit", slog.String("limit", v))
+			abort(c, invalidParameter(fmt.Sprintf("limit must be between 1 and %d", storage.MaxSearchLimit)))
+			return
+		}
+		limit = n
+	}
+
+	requestLog(c, h.log).Debug("Searching tasks", slog.String("query", query))
+	results, err := h.storage.SearchTasks(c.Request.Context(), ownerScope(c), query, limit)
+	if err != nil {
+		requestLog(c, h.log).Error("Failed to search tasks", slog.String("query", query), slog.Any("error", err))
+		abort(c, err)
+		return
+	}
+
+	requestLog(c, h.log).Info("Tasks searched successfully", slog.String("query", query), slog.Int("count", len(results)))
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// TaskReplacement is the full editable state of a task: the body of PUT
+// and the document a PATCH is applied to.
+type TaskReplacement struct {
+	Title       string            `json:"title" binding:"required"`
+	Description string            `json:"description"`
+	Completed   bool              `json:"completed"`
+	DueAt       *time.Time        `json:"due_at"`
+	Priority    *storage.Priority `json:"priority"` // null means normal
+}
+
+func replacementOf(t storage.Task) TaskReplacement {
+	return TaskReplacement{
+		Title:       t.Title,
+		Description: t.Description,
+		Completed:   t.Completed,
+		DueAt:       t.DueAt,
+		Priority:    &t.Priority,
+	}
+}
+
+func (r TaskReplacement) task(id int64) storage.Task {
+	t := storage.Task{
+		ID:          id,
+		Title:       r.Title,
+		Description: r.Description,
+		Completed:   r.Completed,
+		DueAt:       r.DueAt,
+		Priority:    storage.PriorityNormal,
+	}
+	if r.Priority != nil {
+		t.Priority = *r.Priority
+	}
+	return t
+}
+
+// decodeReplacement strictly decodes a task document and validates it against the task model
+func decodeReplacement(data []byte) (TaskReplacement, error) {
+	var r TaskReplacement
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&r); err != nil {
+		return r, err
+	}
+	if err := binding.Validator.ValidateStruct(&r); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+// replaces all editable fields of the task, omitted ones are reset to defaults
+// PUT (/task/:id)
+func (h *TaskHandler) ReplaceTask(c *gin.Context) {
+	id, err := GetID(c)
+	if err != nil {
+		requestLog(c, h.log).Warn("Invalid task ID", slog.String("error", err.Error()))
+		abort(c, errInvalidID)
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		requestLog(c, h.log).Warn("Failed to read request body", slog.Any("error", err))
+		abort(c, invalidRequest(err))
+		return
+	}
+
+	req, err := decodeReplacement(body)
+	if err != nil {
+		requestLog(c, h.log).Warn("Invalid request", slog.String("error", err.Error()))
+		abort(c, invalidRequest(err))
+		return
+	}
+
+	h.updateTask(c, req.task(id))
+}
+
+// applies a JSON Merge Patch (RFC 7396) to the task
+// PATCH (/task/:id)
+func (h *TaskHandler) PatchTask(c *gin.Context) {
+	id, err := GetID(c)
+	if err != nil {
+		requestLog(c, h.log).Warn("Invalid task ID", slog.String("error", err.Error()))
+		abort(c, errInvalidID)
+		return
+	}
+
+	if ct := c.ContentType(); ct != mergepatch.ContentType && ct != binding.MIMEJSON {
+		requestLog(c, h.log).Warn("Unsupported patch content type", slog.String("content_type", ct))
+		abort(c, NewError(http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "content type must be "+mergepatch.ContentType))
+		return
+	}
+
+	patch, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		requestLog(c, h.log).Warn("Failed to read request body", slog.Any("error", err))
+		abort(c, invalidRequest(err))
+		return
+	}
+
+	current, err := h.storage.GetTaskByID(c.Request.Context(), ownerScope(c), id)
+	if err != nil {
+		if errors.Is(err, storage.ErrTaskNotFound) {
+			requestLog(c, h.log).Warn("Task not found", slog.Int64("id", id))
+		} else {
+			requestLog(c, h.log).Error("Failed to get task", slog.Int64("id", id), slog.Any("error", err))
+		}
+		abort(c, err)
+		return
+	}
+
+	original, err := json.Marshal(replacementOf(current))
+	if err != nil {
+		requestLog(c, h.log).Error("Failed to encode task", slog.Int64("id", id), slog.Any("error", err))
+		abort(c, err)
+		return
+	}
+
+	patched, err := mergepatch.Apply(original, patch)
+	if err != nil {
+		requestLog(c, h.log).Warn("Invalid merge patch", slog.String("error", err.Error()))
+		abort(c, &Error{Status: http.StatusBadRequest, Code: CodeInvalidPatch, Detail: "merge patch must be a JSON document", Err: err})
+		return
+	}
+
+	req, err := decodeReplacement(patched)
+	if err != nil {
+		requestLog(c, h.log).Warn("Patched task is invalid", slog.String("error", err.Error()))
+		abort(c, &Error{Status: http.StatusUnprocessableEntity, Code: CodeInvalidTask, Detail: "patched task is invalid: " + bindingDetail(err), Err: err})
+		return
+	}
+
+	h.updateTask(c, req.task(id))
+}
+
+func (h *TaskHandler) updateTask(c *gin.Context, task storage.Task) {
+	requestLog(c, h.log).Debug("Updating task", slog.Int64("id", task.ID))
+	updated, err := h.storage.UpdateTask(c.Request.Context(), ownerScope(c), task)
+	if err != nil {
+		if errors.Is(err, storage.ErrTaskNotFound) {
+			requestLog(c, h.log).Warn("Task not found", slog.Int64("id", task.ID))
+		} else {
+			requestLog(c, h.log).Error("Failed to update task", slog.Int64("id", task.ID), slog.Any("error", err))
+		}
+		abort(c, err)
+		return
+	}
+
+	requestLog(c, h.log).Info("Task updated successfully", slog.Int64("id", updated.ID))
+	c.JSON(http.StatusOK, updated)
+}
@@ -0,0 +1,420 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"rest_api/internal/auth"
+	"rest_api/internal/config"
+	storage "rest_api/internal/db"
+	"rest_api/internal/db/dbtest"
+	"rest_api/internal/db/memory"
+	"rest_api/internal/db/sqlite"
+	"rest_api/internal/handler"
+	"rest_api/internal/lib/mergepatch"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testServer serves the task and admin routes on the memory backend. Keys
+// live in a sqlite database of the test, like with the memory storage driver.
+type testServer struct {
+	router *gin.Engine
+	auth   *auth.Storage
+	admin  string // holds every permission
+	alice  string // task.*
+	bob    string // task.*
+	reader string // task.read only
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	db, err := sqlite.Open(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	dbtest.Migrate(t, db, storage.DialectSQLite)
+
+	authStorage := auth.NewStorage(db, storage.DialectSQLite)
+	if err := authStorage.SyncRoles(config.DefaultPolicy().Roles); err != nil {
+		t.Fatalf("sync roles: %v", err)
+	}
+	for _, perm := range auth.SeedPermissions {
+		if err := authStorage.CreatePermission(perm); err != nil {
+			t.Fatalf("create permission %s: %v", perm, err)
+		}
+	}
+
+	s := &testServer{auth: authStorage}
+	if s.admin, err = authStorage.EnsureAdminSetup(log, ""); err != nil {
+		t.Fatalf("admin key: %v", err)
+	}
+	for _, k := range []struct {
+		key   *string
+		owner string
+		perm  string
+	}{{&s.alice, "alice", "task.*"}, {&s.bob, "bob", "task.*"}, {&s.reader, "reader", "task.read"}} {
+		if *k.key, _, err = authStorage.CreateKey(k.owner, []string{k.perm}); err != nil {
+			t.Fatalf("create key of %s: %v", k.owner, err)
+		}
+	}
+
+	tasks := handler.NewTaskHandler(memory.New(), log)
+	admin := handler.NewAuthorization(auth.NewService(authStorage, "", config.Registration{Mode: config.RegistrationOpen}), log)
+	perm := func(name string) gin.HandlerFunc { return auth.RequirePermission(authStorage, name, log) }
+
+	r := gin.New()
+	r.Use(handler.ErrorHandler())
+	r.NoRoute(handler.NoRoute)
+	adminGroup := r.Group("/admin", auth.AuthMiddleware(authStorage, log), perm("admin"))
+	adminGroup.GET("/keys", admin.ListKeys)
+	adminGroup.GET("/permissions", admin.ListPermissions)
+	adminGroup.POST("/permissions", admin.CreatePermission)
+	adminGroup.DELETE("/permissions/:name", admin.DeletePermission)
+	adminGroup.GET("/keys/:id/permissions", admin.ListKeyPermissions)
+	adminGroup.DELETE("/keys/:id/permissions/:name", admin.RevokePermission)
+	adminGroup.POST("/keys/:id/revoke", admin.RevokeKey)
+	authorized := r.Group("/", auth.AuthMiddleware(authStorage, log))
+	authorized.POST("/keys/rotate", admin.RotateOwnKey)
+	authorized.GET("/task", perm("task.read"), tasks.ListTasks)
+	authorized.GET("/task/search", perm("task.read"), tasks.SearchTasks)
+	authorized.GET("/task/:id", perm("task.read"), tasks.GetTaskByID)
+	authorized.POST("/task", perm("task.create"), tasks.CreateTask)
+	authorized.DELETE("/task/:id", perm("task.delete"), tasks.DeleteTaskByID)
+	authorized.PUT("/task/:id", perm("task.update"), tasks.ReplaceTask)
+	authorized.PATCH("/task/:id", perm("task.update"), tasks.PatchTask)
+	authorized.PATCH("/task/:id/completed", perm("task.update"), tasks.CompletedTask)
+	authorized.PATCH("/task/:id/uncompleted", perm("task.update"), tasks.UncompletedTask)
+	s.router = r
+	return s
+}
+
+// do sends a request with the api key key, body is sent as JSON unless
+// contentType says otherwise
+func (s *testServer) do(t *testing.T, method, path, key, body string, contentType ...string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType[0])
+	}
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+// createTask creates a task with the key and returns it
+func (s *testServer) createTask(t *testing.T, key, body string) storage.Task {
+	t.Helper()
+
+	w := s.do(t, http.MethodPost, "/task", key, body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /task %s = %d %s", body, w.Code, w.Body)
+	}
+	var task storage.Task
+	decode(t, w, &task)
+	return task
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder, v any) {
+	t.Helper()
+
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode %s: %v", w.Body, err)
+	}
+}
+
+// expectProblem checks that w is a problem response with status and code
+func expectProblem(t *testing.T, w *httptest.ResponseRecorder, status int, code handler.ErrorCode) {
+	t.Helper()
+
+	if w.Code != status {
+		t.Errorf("status = %d, want %d: %s", w.Code, status, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, handler.ProblemContentType) {
+		t.Errorf("content type = %q, want %s", ct, handler.ProblemContentType)
+	}
+	var p handler.Problem
+	decode(t, w, &p)
+	if p.Code != code || p.Status != status {
+		t.Errorf("problem = %s %d, want %s %d", p.Code, p.Status, code, status)
+	}
+}
+
+func TestCreateAndGetTask(t *testing.T) {
+	s := newTestServer(t)
+
+	task := s.createTask(t, s.alice, `{"title":"buy milk","description":"2 liters","priority":"high","due_at":"2030-01-02T15:04:05+02:00"}`)
+	if task.ID == 0 || task.Title != "buy milk" || task.Description != "2 liters" || task.Priority != storage.PriorityHigh {
+		t.Errorf("created task = %+v", task)
+	}
+	if task.DueAt == nil || task.DueAt.Format("2006-01-02T15:04:05Z07:00") != "2030-01-02T13:04:05Z" {
+		t.Errorf("due_at = %v, want it in UTC", task.DueAt)
+	}
+	if task.OwnerKeyID == nil {
+		t.Error("created task has no owner")
+	}
+
+	w := s.do(t, http.MethodGet, fmt.Sprintf("/task/%d", task.ID), s.alice, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET = %d %s", w.Code, w.Body)
+	}
+	var got storage.Task
+	decode(t, w, &got)
+	if got.ID != task.ID || got.Title != task.Title || !got.CreatedAt.Equal(task.CreatedAt) {
+		t.Errorf("GET = %+v, want %+v", got, task)
+	}
+
+	if task := s.createTask(t, s.alice, `{"title":"defaults"}`); task.Priority != storage.PriorityNormal || task.DueAt != nil {
+		t.Errorf("task without priority and due date = %+v", task)
+	}
+}
+
+func TestCreateTaskInvalid(t *testing.T) {
+	s := newTestServer(t)
+
+	for _, body := range []string{`{`, `{"description":"no title"}`, `{"title":"x","priority":"asap"}`, `{"title":"x","due_at":"tomorrow"}`} {
+		w := s.do(t, http.MethodPost, "/task", s.alice, body)
+		expectProblem(t, w, http.StatusBadRequest, handler.CodeInvalidRequest)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	s := newTestServer(t)
+
+	expectProblem(t, s.do(t, http.MethodGet, "/task", "", ""), http.StatusUnauthorized, handler.CodeUnauthorized)
+	expectProblem(t, s.do(t, http.MethodGet, "/task", "not-a-key", ""), http.StatusUnauthorized, handler.CodeInvalidAPIKey)
+	expectProblem(t, s.do(t, http.MethodGet, "/task", s.alice[:len(s.alice)-1], ""), http.StatusUnauthorized, handler.CodeInvalidAPIKey)
+	expectProblem(t, s.do(t, http.MethodPost, "/task", s.reader, `{"title":"x"}`), http.StatusForbidden, handler.CodeForbidden)
+	expectProblem(t, s.do(t, http.MethodGet, "/nothing", s.alice, ""), http.StatusNotFound, handler.CodeRouteNotFound)
+
+	if w := s.do(t, http.MethodGet, "/task", s.reader, ""); w.Code != http.StatusOK {
+		t.Errorf("GET /task with task.read = %d %s", w.Code, w.Body)
+	}
+}
+
+func TestTaskOwnership(t *testing.T) {
+	s := newTestServer(t)
+	task := s.createTask(t, s.alice, `{"title":"alice's"}`)
+	path := fmt.Sprintf("/task/%d", task.ID)
+
+	expectProblem(t, s.do(t, http.MethodGet, path, s.bob, ""), http.StatusNotFound, handler.CodeTaskNotFound)
+	expectProblem(t, s.do(t, http.MethodPut, path, s.bob, `{"title":"bob's"}`), http.StatusNotFound, handler.CodeTaskNotFound)
+	expectProblem(t, s.do(t, http.MethodPatch, path+"/completed", s.bob, ""), http.StatusNotFound, handler.CodeTaskNotFound)
+	expectProblem(t, s.do(t, http.MethodDelete, path, s.bob, ""), http.StatusNotFound, handler.CodeTaskNotFound)
+
+	var page struct {
+		Tasks []storage.Task `json:"tasks"`
+		Total int            `json:"total"`
+	}
+	decode(t, s.do(t, http.MethodGet, "/task", s.bob, ""), &page)
+	if page.Total != 0 {
+		t.Errorf("bob lists %d tasks of alice", page.Total)
+	}
+
+	// admin keys see the tasks of every owner
+	if w := s.do(t, http.MethodGet, path, s.admin, ""); w.Code != http.StatusOK {
+		t.Errorf("GET by admin = %d %s", w.Code, w.Body)
+	}
+	if w := s.do(t, http.MethodDelete, path, s.alice, ""); w.Code != http.StatusOK {
+		t.Errorf("DELETE by owner = %d %s", w.Code, w.Body)
+	}
+	expectProblem(t, s.do(t, http.MethodGet, path, s.alice, ""), http.StatusNotFound, handler.CodeTaskNotFound)
+}
+
+func TestInvalidID(t *testing.T) {
+	s := newTestServer(t)
+
+	for _, method := range []string{http.MethodGet, http.MethodDelete, http.MethodPut, http.MethodPatch} {
+		w := s.do(t, method, "/task/abc", s.alice, `{"title":"x"}`)
+		expectProblem(t, w, http.StatusBadRequest, handler.CodeInvalidID)
+	}
+}
+
+func TestListTasksPages(t *testing.T) {
+	s := newTestServer(t)
+	var want []int64
+	for i := 0; i < 5; i++ {
+		want = append(want, s.createTask(t, s.alice, fmt.Sprintf(`{"title":"task %d"}`, i)).ID)
+	}
+	s.createTask(t, s.bob, `{"title":"bob's"}`)
+
+	var got []int64
+	path := "/task?limit=2"
+	for pages := 0; pages < 5; pages++ {
+		w := s.do(t, http.MethodGet, path, s.alice, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d %s", path, w.Code, w.Body)
+		}
+		var page struct {
+			Tasks      []storage.Task `json:"tasks"`
+			Total      int            `json:"total"`
+			NextCursor string         `json:"next_cursor"`
+		}
+		decode(t, w, &page)
+		if page.Total != 5 {
+			t.Errorf("total = %d, want 5", page.Total)
+		}
+		for _, task := range page.Tasks {
+			got = append(got, task.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		path = "/task?limit=2&cursor=" + page.NextCursor
+	}
+
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("paged ids = %v, want %v", got, want)
+	}
+}
+
+func TestListTasksFilters(t *testing.T) {
+	s := newTestServer(t)
+	s.createTask(t, s.alice, `{"title":"low","priority":"low"}`)
+	s.createTask(t, s.alice, `{"title":"urgent","priority":"urgent","due_at":"2030-01-01T00:00:00Z"}`)
+	done := s.createTask(t, s.alice, `{"title":"done"}`)
+	if w := s.do(t, http.MethodPatch, fmt.Sprintf("/task/%d/completed", done.ID), s.alice, ""); w.Code != http.StatusOK {
+		t.Fatalf("PATCH completed = %d %s", w.Code, w.Body)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"completed=true", []string{"done"}},
+		{"completed=false&sort=priority&order=desc", []string{"urgent", "low"}},
+		{"priority=urgent,normal", []string{"urgent", "done"}},
+		{"due_before=2031-01-01T00:00:00Z", []string{"urgent"}},
+		{"sort=title", []string{"done", "low", "urgent"}},
+	}
+	for _, tt := range tests {
+		w := s.do(t, http.MethodGet, "/task?"+tt.query, s.alice, "")
+		if w.Code != http.StatusOK {
+			t.Errorf("GET /task?%s = %d %s", tt.query, w.Code, w.Body)
+			continue
+		}
+		var page struct {
+			Tasks []storage.Task `json:"tasks"`
+		}
+		decode(t, w, &page)
+		var titles []string
+		for _, task := range page.Tasks {
+			titles = append(titles, task.Title)
+		}
+		if fmt.Sprint(titles) != fmt.Sprint(tt.want) {
+			t.Errorf("GET /task?%s = %v, want %v", tt.query, titles, tt.want)
+		}
+	}
+}
+
+func TestListTasksInvalidParameters(t *testing.T) {
+	s := newTestServer(t)
+
+	for _, query := range []string{"limit=0", "limit=101", "limit=x", "completed=maybe", "priority=asap", "due_before=tomorrow", "sort=owner", "order=up"} {
+		expectProblem(t, s.do(t, http.MethodGet, "/task?"+query, s.alice, ""), http.StatusBadRequest, handler.CodeInvalidParameter)
+	}
+	expectProblem(t, s.do(t, http.MethodGet, "/task?cursor=garbage", s.alice, ""), http.StatusBadRequest, handler.CodeInvalidCursor)
+}
+
+func TestCompletedTask(t *testing.T) {
+	s := newTestServer(t)
+	task := s.createTask(t, s.alice, `{"title":"toggle"}`)
+	path := fmt.Sprintf("/task/%d", task.ID)
+
+	for _, state := range []string{"completed", "uncompleted"} {
+		if w := s.do(t, http.MethodPatch, path+"/"+state, s.alice, ""); w.Code != http.StatusOK {
+			t.Fatalf("PATCH %s = %d %s", state, w.Code, w.Body)
+		}
+		var got storage.Task
+		decode(t, s.do(t, http.MethodGet, path, s.alice, ""), &got)
+		if got.Completed != (state == "completed") {
+			t.Errorf("after PATCH %s completed = %v", state, got.Completed)
+		}
+	}
+}
+
+func TestReplaceTask(t *testing.T) {
+	s := newTestServer(t)
+	task := s.createTask(t, s.alice, `{"title":"draft","description":"notes","priority":"high","due_at":"2030-01-01T00:00:00Z"}`)
+	path := fmt.Sprintf("/task/%d", task.ID)
+
+	// omitted fields are reset
+	w := s.do(t, http.MethodPut, path, s.alice, `{"title":"final","completed":true}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT = %d %s", w.Code, w.Body)
+	}
+	var got storage.Task
+	decode(t, w, &got)
+	if got.Title != "final" || !got.Completed || got.Description != "" || got.Priority != storage.PriorityNormal || got.DueAt != nil {
+		t.Errorf("PUT = %+v, want omitted fields reset", got)
+	}
+
+	expectProblem(t, s.do(t, http.MethodPut, path, s.alice, `{"description":"no title"}`), http.StatusBadRequest, handler.CodeInvalidRequest)
+	expectProblem(t, s.do(t, http.MethodPut, "/task/999", s.alice, `{"title":"x"}`), http.StatusNotFound, handler.CodeTaskNotFound)
+}
+
+func TestPatchTask(t *testing.T) {
+	s := newTestServer(t)
+	task := s.createTask(t, s.alice, `{"title":"draft","description":"notes","priority":"high","due_at":"2030-01-01T00:00:00Z"}`)
+	path := fmt.Sprintf("/task/%d", task.ID)
+
+	// fields missing from the patch are kept, null removes them
+	w := s.do(t, http.MethodPatch, path, s.alice, `{"title":"final","due_at":null}`, mergepatch.ContentType)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PATCH = %d %s", w.Code, w.Body)
+	}
+	var got storage.Task
+	decode(t, w, &got)
+	if got.Title != "final" || got.Description != "notes" || got.Priority != storage.PriorityHigh || got.DueAt != nil {
+		t.Errorf("PATCH = %+v", got)
+	}
+
+	expectProblem(t, s.do(t, http.MethodPatch, path, s.alice, `{"title":"x"}`, "text/plain"), http.StatusUnsupportedMediaType, handler.CodeUnsupportedMediaType)
+	expectProblem(t, s.do(t, http.MethodPatch, path, s.alice, `{"title":`, mergepatch.ContentType), http.StatusBadRequest, handler.CodeInvalidPatch)
+	expectProblem(t, s.do(t, http.MethodPatch, path, s.alice, `{"title":null}`, mergepatch.ContentType), http.StatusUnprocessableEntity, handler.CodeInvalidTask)
+	expectProblem(t, s.do(t, http.MethodPatch, path, s.alice, `{"priority":"asap"}`, mergepatch.ContentType), http.StatusUnprocessableEntity, handler.CodeInvalidTask)
+	expectProblem(t, s.do(t, http.MethodPatch, "/task/999", s.alice, `{"title":"x"}`, mergepatch.ContentType), http.StatusNotFound, handler.CodeTaskNotFound)
+}
+
+func TestSearchTasks(t *testing.T) {
+	s := newTestServer(t)
+	s.createTask(t, s.alice, `{"title":"buy milk"}`)
+	s.createTask(t, s.alice, `{"title":"bake bread"}`)
+	s.createTask(t, s.bob, `{"title":"spilt milk"}`)
+
+	w := s.do(t, http.MethodGet, "/task/search?q=milk", s.alice, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("search = %d %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Results []storage.SearchResult `json:"results"`
+	}
+	decode(t, w, &resp)
+	if len(resp.Results) != 1 || resp.Results[0].Title != "buy milk" {
+		t.Fatalf("search = %+v, want alice's milk task", resp.Results)
+	}
+	if want := "buy " + storage.HighlightStart + "milk" + storage.HighlightEnd; resp.Results[0].Snippet != want {
+		t.Errorf("snippet = %q, want %q", resp.Results[0].Snippet, want)
+	}
+
+	expectProblem(t, s.do(t, http.MethodGet, "/task/search?q=+", s.alice, ""), http.StatusBadRequest, handler.CodeInvalidParameter)
+	expectProblem(t, s.do(t, http.MethodGet, "/task/search?q=milk&limit=0", s.alice, ""), http.StatusBadRequest, handler.CodeInvalidParameter)
+}
@@ -0,0 +1,52 @@
+// Package buildinfo describes the running binary.
+//
+// Version and Commit are set at link time:
+//
+//	go build -tags sqlite_fts5 -ldflags "-X rest_api/internal/lib/buildinfo.Version=v1.2.0 -X rest_api/internal/lib/buildinfo.Commit=$(git rev-parse HEAD)" ./cmd/api
+//
+// The sqlite_fts5 tag compiles FTS5 into go-sqlite3, sqlite task search needs it.
+//
+// Without them the commit is taken from the VCS stamp Go embeds in the binary.
+package buildinfo
+
+import "runtime/debug"
+
+var (
+	Version = "dev"
+	Commit  = ""
+)
+
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"` // built from a dirty tree
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build information of the running binary
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit}
+
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.GoVersion = bi.GoVersion
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = s.Value
+			}
+		case "vcs.time":
+			info.BuildTime = s.Value
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	return info
+}
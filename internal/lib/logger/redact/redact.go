@@ -0,0 +1,114 @@
+// Package redact provides a slog.Handler that keeps secrets out of the logs.
+package redact
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Mask replaces every redacted value
+const Mask = "[REDACTED]"
+
+// DefaultKeys are always redacted, whatever value they hold
+var DefaultKeys = []string{"key", "api_key", "apikey", "x-api-key", "token", "authorization", "password", "secret"}
+
+// keyScheme starts generated keys (auth.KeyScheme), todo_live_<id> is public
+const keyScheme = "todo_live_"
+
+// secretPattern matches values that look like API keys: todo_live_ keys, the
+// 64 hex chars of an older key (or a hash) and Authorization header values.
+// redactString keeps the public todo_live_<id> prefix of a key.
+var secretPattern = regexp.MustCompile(`(?i)\bApiKey\.\S+|\bBearer\s+\S+|\btodo_live_[0-9a-z]+_[0-9a-z_]+|\b[0-9a-f]{64}\b`)
+
+// Handler masks the attributes named in its key list and any API key found
+// in the message or a string value, then passes the record on.
+type Handler struct {
+	next slog.Handler
+	keys map[string]struct{}
+}
+
+var _ slog.Handler = (*Handler)(nil)
+
+// NewHandler wraps next, keys are added to DefaultKeys and matched case-insensitively
+func NewHandler(next slog.Handler, keys ...string) *Handler {
+	set := make(map[string]struct{}, len(DefaultKeys)+len(keys))
+	for _, k := range append(DefaultKeys, keys...) {
+		set[strings.ToLower(k)] = struct{}{}
+	}
+	return &Handler{next: next, keys: set}
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, redactString(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(h.attr(a))
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = h.attr(a)
+	}
+	return &Handler{next: h.next.WithAttrs(redacted), keys: h.keys}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{next: h.next.WithGroup(name), keys: h.keys}
+}
+
+// attr returns a with its value masked or scrubbed, groups are walked
+func (h *Handler) attr(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+	if _, ok := h.keys[strings.ToLower(a.Key)]; ok && a.Value.Kind() != slog.KindGroup {
+		return slog.String(a.Key, Mask)
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(redactString(a.Value.String()))
+	case slog.KindGroup:
+		group := a.Value.Group()
+		redacted := make([]slog.Attr, len(group))
+		for i, ga := range group {
+			redacted[i] = h.attr(ga)
+		}
+		a.Value = slog.GroupValue(redacted...)
+	case slog.KindAny:
+		// errors may wrap a query or header that carried a key
+		if err, ok := a.Value.Any().(error); ok {
+			if msg := redactString(err.Error()); msg != err.Error() {
+				a.Value = slog.StringValue(msg)
+			}
+		}
+	}
+	return a
+}
+
+func redactString(s string) string {
+	return secretPattern.ReplaceAllStringFunc(s, func(match string) string {
+		// "Bearer <key>" keeps the scheme word
+		i := strings.LastIndexFunc(match, unicode.IsSpace) + 1
+		return match[:i] + maskKey(match[i:])
+	})
+}
+
+// maskKey masks a secret, todo_live_ keys keep their todo_live_<id>_ prefix
+// so the log still tells which key it was
+func maskKey(secret string) string {
+	if len(secret) > len(keyScheme) && strings.EqualFold(secret[:len(keyScheme)], keyScheme) {
+		if i := strings.IndexByte(secret[len(keyScheme):], '_'); i >= 0 {
+			return secret[:len(keyScheme)+i+1] + Mask
+		}
+	}
+	return Mask
+}
@@ -0,0 +1,64 @@
+package redact
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+const (
+	testKey = "todo_live_AbCd1234_0123456789abcdefghijABCDEFGHIJ0123456789_x1Y2z3"
+	testHex = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+)
+
+func TestRedactString(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"key " + testKey + " rejected", "key todo_live_AbCd1234_" + Mask + " rejected"},
+		{"Authorization: Bearer " + testKey, "Authorization: Bearer todo_live_AbCd1234_" + Mask},
+		{"Authorization: Bearer some-token", "Authorization: Bearer " + Mask},
+		{"hash " + testHex, "hash " + Mask},
+		{"prefix todo_live_AbCd1234 only", "prefix todo_live_AbCd1234 only"},
+		{"nothing secret", "nothing secret"},
+	}
+	for _, tt := range tests {
+		if got := redactString(tt.in); got != tt.want {
+			t.Errorf("redactString(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(NewHandler(slog.NewTextHandler(&buf, nil), "session"))
+
+	log.With(slog.String("token", "t0ps3cret")).Info("login with "+testKey,
+		slog.String("Session", "abc"),
+		slog.Group("req", slog.String("authorization", "Bearer xyz"), slog.String("path", "/task")),
+		slog.Any("error", errors.New("bad key "+testKey)),
+		slog.String("key_prefix", "todo_live_AbCd1234"),
+	)
+	out := buf.String()
+
+	for _, secret := range []string{"t0ps3cret", "abc", "xyz", "0123456789abcdefghij"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("log contains %q: %s", secret, out)
+		}
+	}
+	for _, kept := range []string{"path=/task", "key_prefix=todo_live_AbCd1234", "login with todo_live_AbCd1234_" + Mask} {
+		if !strings.Contains(out, kept) {
+			t.Errorf("log lacks %q: %s", kept, out)
+		}
+	}
+}
+
+func TestHandlerEnabled(t *testing.T) {
+	h := NewHandler(slog.NewTextHandler(&bytes.Buffer{}, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	if h.Enabled(context.Background(), slog.LevelInfo) {
+		t.Error("info is enabled below a warn handler")
+	}
+}
@@ -0,0 +1,149 @@
+package ratelimit
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Limiter clock moved by hand
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLimiter() (*Limiter, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := New()
+	l.now = clock.now
+	return l, clock
+}
+
+func TestAllowBurstThenRefill(t *testing.T) {
+	l, clock := newTestLimiter()
+	limit := Limit{PerMinute: 60, Burst: 3}
+
+	for i := 0; i < 3; i++ {
+		res := l.Allow("k", limit)
+		if !res.Allowed {
+			t.Fatalf("request %d denied within the burst", i+1)
+		}
+		if res.Limit != 3 || res.Remaining != 2-i {
+			t.Errorf("request %d: limit %d, remaining %d", i+1, res.Limit, res.Remaining)
+		}
+	}
+
+	res := l.Allow("k", limit)
+	if res.Allowed {
+		t.Fatal("request beyond the burst allowed")
+	}
+	if res.RetryAfter != time.Second {
+		t.Errorf("RetryAfter = %v, want 1s", res.RetryAfter)
+	}
+	if res.Reset != 3*time.Second {
+		t.Errorf("Reset = %v, want 3s", res.Reset)
+	}
+
+	clock.advance(time.Second)
+	if !l.Allow("k", limit).Allowed {
+		t.Error("request denied after a token refilled")
+	}
+	if l.Allow("k", limit).Allowed {
+		t.Error("second request allowed with one token refilled")
+	}
+
+	clock.advance(time.Hour)
+	if res := l.Allow("k", limit); !res.Allowed || res.Remaining != 2 {
+		t.Errorf("after an hour: allowed %v, remaining %d, want a full bucket", res.Allowed, res.Remaining)
+	}
+}
+
+func TestAllowKeysAreIndependent(t *testing.T) {
+	l, _ := newTestLimiter()
+	limit := Limit{PerMinute: 1, Burst: 1}
+
+	if !l.Allow("a", limit).Allowed {
+		t.Fatal("first request of a denied")
+	}
+	if l.Allow("a", limit).Allowed {
+		t.Error("second request of a allowed")
+	}
+	if !l.Allow("b", limit).Allowed {
+		t.Error("b is limited by the requests of a")
+	}
+}
+
+func TestAllowUnlimited(t *testing.T) {
+	l, _ := newTestLimiter()
+	for i := 0; i < 1000; i++ {
+		if !l.Allow("k", Limit{PerMinute: 0, Burst: 1}).Allowed {
+			t.Fatalf("request %d denied without a limit", i+1)
+		}
+	}
+	if len(l.buckets) != 0 {
+		t.Errorf("unlimited requests created %d buckets", len(l.buckets))
+	}
+}
+
+func TestAllowBurstBelowOne(t *testing.T) {
+	l, _ := newTestLimiter()
+	if !l.Allow("k", Limit{PerMinute: 60}).Allowed {
+		t.Error("a zero burst denies every request")
+	}
+}
+
+func TestAllowChangedLimit(t *testing.T) {
+	l, clock := newTestLimiter()
+	l.Allow("k", Limit{PerMinute: 60, Burst: 1})
+
+	// a bigger bucket refills up to its new size from the next call on
+	clock.advance(5 * time.Second)
+	if res := l.Allow("k", Limit{PerMinute: 60, Burst: 10}); !res.Allowed || res.Remaining != 4 {
+		t.Errorf("allowed %v, remaining %d, want 4", res.Allowed, res.Remaining)
+	}
+}
+
+func TestSweepDropsFullBuckets(t *testing.T) {
+	l, clock := newTestLimiter()
+	limit := Limit{PerMinute: 60, Burst: 2}
+	l.Allow("idle", limit)
+	clock.advance(sweepInterval)
+	l.Allow("busy", limit)
+
+	if _, ok := l.buckets["idle"]; ok {
+		t.Error("the refilled bucket of an idle key wasn't dropped")
+	}
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Error("the bucket in use was dropped")
+	}
+}
+
+func TestAllowConcurrent(t *testing.T) {
+	l, _ := newTestLimiter()
+	limit := Limit{PerMinute: 1, Burst: 50}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if l.Allow("k", limit).Allowed {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != 50 {
+		t.Errorf("%d concurrent requests allowed, want the burst of 50", allowed)
+	}
+}
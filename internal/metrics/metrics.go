@@ -0,0 +1,135 @@
+// Package metrics holds the Prometheus metrics of the service and
+// serves them on /metrics.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "todo"
+
+// Registry holds every metric of the service, plus Go runtime and process metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route template and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method and route template.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	authFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_validation_failures_total",
+		Help:      "Requests rejected by API key validation, by reason.",
+	}, []string{"reason"})
+
+	permissionDenials = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_permission_denials_total",
+		Help:      "Requests rejected for a missing permission, by permission name.",
+	}, []string{"permission"})
+
+	keyRegistrations = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_key_registrations_total",
+		Help:      "API keys registered through POST /register.",
+	})
+
+	rateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Requests rejected with 429, by limit (key, ip or daily_tasks).",
+	}, []string{"limit"})
+)
+
+// Reasons of AuthFailure
+const (
+	ReasonMissingHeader = "missing_header"
+	ReasonInvalidKey    = "invalid_key"
+	ReasonMalformedKey  = "malformed_key"
+	ReasonError         = "error"
+)
+
+// Limits of RateLimited
+const (
+	LimitKey        = "key"
+	LimitIP         = "ip"
+	LimitDailyTasks = "daily_tasks"
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		authFailures,
+		permissionDenials,
+		keyRegistrations,
+		rateLimited,
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// Middleware records the count and latency of requests per route template
+// (/task/:id rather than /task/42), unmatched paths are grouped together.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+		httpRequests.WithLabelValues(method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		httpDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// AuthFailure counts a request rejected by API key validation
+func AuthFailure(reason string) {
+	authFailures.WithLabelValues(reason).Inc()
+}
+
+// PermissionDenied counts a request rejected for lacking permission
+func PermissionDenied(permission string) {
+	permissionDenials.WithLabelValues(permission).Inc()
+}
+
+// KeyRegistered counts a registered API key
+func KeyRegistered() {
+	keyRegistrations.Inc()
+}
+
+// RateLimited counts a request rejected by a rate limit or quota
+func RateLimited(limit string) {
+	rateLimited.WithLabelValues(limit).Inc()
+}
+
+// RegisterDB exports the connection pool stats of db, name is the
+// db_name label (sqlite or postgres)
+func RegisterDB(db *sql.DB, name string) error {
+	return Registry.Register(collectors.NewDBStatsCollector(db, name))
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	storage "rest_api/internal/db"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectTimeout bounds the storage queries of one scrape
+const collectTimeout = 2 * time.Second
+
+var tasksDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "tasks"),
+	"Stored tasks by completion state.",
+	[]string{"completed"}, nil,
+)
+
+// taskCollector counts the tasks of all owners at scrape time
+type taskCollector struct {
+	repo storage.TaskRepository
+}
+
+// RegisterTasks exports the task counts of repo by completion state
+func RegisterTasks(repo storage.TaskRepository) error {
+	return Registry.Register(taskCollector{repo: repo})
+}
+
+func (tc taskCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- tasksDesc
+}
+
+func (tc taskCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+
+	for _, completed := range []bool{false, true} {
+		page, err := tc.repo.ListTasks(ctx, storage.AnyOwner(), storage.ListOptions{Limit: 1, Completed: &completed})
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(tasksDesc, err)
+			continue
+		}
+		label := "false"
+		if completed {
+			label = "true"
+		}
+		ch <- prometheus.MustNewConstMetric(tasksDesc, prometheus.GaugeValue, float64(page.Total), label)
+	}
+}
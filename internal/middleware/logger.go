@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"rest_api/internal/auth"
+	sl "rest_api/internal/lib/logger/slog"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Logger replaces gin's access log. It puts a logger carrying the request ID,
+// route and trace ID into the request context (see sl.FromContext) and logs
+// one line per request with status, latency and the API key ID.
+// It must run after RequestID.
+func Logger(log *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		attrs := []any{
+			slog.String("request_id", GetRequestID(c)),
+			slog.String("method", c.Request.Method),
+			slog.String("route", route),
+		}
+		if sc := trace.SpanContextFromContext(c.Request.Context()); sc.HasTraceID() {
+			attrs = append(attrs, slog.String("trace_id", sc.TraceID().String()))
+		}
+		reqLog := log.With(attrs...)
+		c.Request = c.Request.WithContext(sl.NewContext(c.Request.Context(), reqLog))
+
+		c.Next()
+
+		status := c.Writer.Status()
+		done := []any{
+			slog.Int("status", status),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("path", c.Request.URL.Path),
+			slog.String("client_ip", c.ClientIP()),
+			slog.Int("bytes", c.Writer.Size()),
+		}
+		if keyID, ok := auth.KeyID(c); ok {
+			done = append(done, slog.Int64("key_id", keyID))
+		}
+		if len(c.Errors) > 0 {
+			done = append(done, slog.String("errors", c.Errors.String()))
+		}
+
+		switch {
+		case status >= http.StatusInternalServerError:
+			reqLog.Error("request completed", done...)
+		case status >= http.StatusBadRequest:
+			reqLog.Warn("request completed", done...)
+		default:
+			reqLog.Info("request completed", done...)
+		}
+	}
+}
+
+// Recovery logs a panic with the request logger instead of gin's plain text
+// output and records it as an error, which handler.ErrorHandler renders as a 500.
+func Recovery(log *slog.Logger) gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, recovered any) {
+		sl.FromContext(c.Request.Context(), log).Error("panic recovered",
+			slog.Any("panic", recovered),
+			slog.String("stack", string(debug.Stack())),
+		)
+		c.Error(fmt.Errorf("panic: %v", recovered))
+		c.Abort()
+	})
+}
@@ -0,0 +1,241 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"rest_api/internal/auth"
+	"rest_api/internal/config"
+	storage "rest_api/internal/db"
+	sl "rest_api/internal/lib/logger/slog"
+	"rest_api/internal/lib/ratelimit"
+	"rest_api/internal/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Errors the rate limiter reports with c.Error, handler.ErrorHandler renders them as 429
+var (
+	ErrRateLimited   = errors.New("rate limit exceeded")
+	ErrQuotaExceeded = errors.New("daily task quota exceeded")
+)
+
+// Rate limit response headers (IETF draft RateLimit header fields)
+const (
+	HeaderRateLimitLimit     = "RateLimit-Limit"
+	HeaderRateLimitRemaining = "RateLimit-Remaining"
+	HeaderRateLimitReset     = "RateLimit-Reset"
+	HeaderRetryAfter         = "Retry-After"
+)
+
+// RateLimiter enforces the per key and per client IP rate limits and the
+// daily task quota. The limits of a key come from the auth tables (see
+// auth.Limits) and are cached for cfg.CacheTTL, the config fills in the rest.
+type RateLimiter struct {
+	cfg     config.RateLimit
+	auth    *auth.Storage
+	buckets *ratelimit.Limiter
+	log     *slog.Logger
+
+	mu    sync.Mutex
+	cache map[int64]cachedLimits
+}
+
+type keyLimits struct {
+	rate       ratelimit.Limit
+	dailyTasks int
+}
+
+type cachedLimits struct {
+	keyLimits
+	expires time.Time
+}
+
+func NewRateLimiter(cfg config.RateLimit, authStorage *auth.Storage, log *slog.Logger) *RateLimiter {
+	return &RateLimiter{
+		cfg:     cfg,
+		auth:    authStorage,
+		buckets: ratelimit.New(),
+		log:     log,
+		cache:   map[int64]cachedLimits{},
+	}
+}
+
+// PerKey limits the requests of the API key authenticated by auth.AuthMiddleware,
+// it must run after it
+func (l *RateLimiter) PerKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !l.cfg.Enabled {
+			c.Next()
+			return
+		}
+		keyID, ok := auth.KeyID(c)
+		if !ok {
+			c.Next()
+			return
+		}
+
+		limits, err := l.keyLimits(c.Request.Context(), keyID)
+		if err != nil {
+			sl.FromContext(c.Request.Context(), l.log).Error("failed to read rate limits", slog.Any("error", err))
+			c.Error(err)
+			c.Abort()
+			return
+		}
+		l.take(c, "key:"+strconv.FormatInt(keyID, 10), limits.rate, metrics.LimitKey)
+	}
+}
+
+// PerIP limits anonymous requests by client IP, for public routes. The IP
+// comes from X-Forwarded-For only behind http_server.trusted_proxies.
+func (l *RateLimiter) PerIP() gin.HandlerFunc {
+	limit := ratelimit.Limit{PerMinute: l.cfg.PublicRequestsPerMinute, Burst: l.cfg.PublicBurst}
+	return func(c *gin.Context) {
+		if !l.cfg.Enabled {
+			c.Next()
+			return
+		}
+		l.take(c, "ip:"+c.ClientIP(), limit, metrics.LimitIP)
+	}
+}
+
+// PerClientIP limits requests to key-authenticated routes by client IP. It
+// must run before auth.AuthMiddleware, so requests with missing or invalid
+// keys, which PerKey never sees, are limited too.
+func (l *RateLimiter) PerClientIP() gin.HandlerFunc {
+	limit := ratelimit.Limit{PerMinute: l.cfg.IPRequestsPerMinute, Burst: l.cfg.IPBurst}
+	return func(c *gin.Context) {
+		if !l.cfg.Enabled {
+			c.Next()
+			return
+		}
+		l.take(c, "client:"+c.ClientIP(), limit, metrics.LimitIP)
+	}
+}
+
+// take answers with the RateLimit-* headers and aborts with ErrRateLimited
+// when the bucket of key is empty
+func (l *RateLimiter) take(c *gin.Context, key string, limit ratelimit.Limit, kind string) {
+	if limit.Unlimited() {
+		c.Next()
+		return
+	}
+
+	res := l.buckets.Allow(key, limit)
+	c.Header(HeaderRateLimitLimit, strconv.Itoa(res.Limit))
+	c.Header(HeaderRateLimitRemaining, strconv.Itoa(res.Remaining))
+	c.Header(HeaderRateLimitReset, strconv.Itoa(ceilSeconds(res.Reset)))
+	if !res.Allowed {
+		metrics.RateLimited(kind)
+		sl.FromContext(c.Request.Context(), l.log).Warn("rate limit exceeded", slog.String("limit", kind))
+		c.Header(HeaderRetryAfter, strconv.Itoa(max(1, ceilSeconds(res.RetryAfter))))
+		c.Error(ErrRateLimited)
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// DailyTaskQuota stops a key from creating tasks once it created its daily
+// quota of them since midnight UTC. Every created task counts, deleting it
+// doesn't give the slot back. It must run after auth.AuthMiddleware.
+func (l *RateLimiter) DailyTaskQuota() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !l.cfg.Enabled {
+			c.Next()
+			return
+		}
+		keyID, ok := auth.KeyID(c)
+		if !ok {
+			c.Next()
+			return
+		}
+		log := sl.FromContext(c.Request.Context(), l.log)
+
+		limits, err := l.keyLimits(c.Request.Context(), keyID)
+		if err != nil {
+			log.Error("failed to read task quota", slog.Any("error", err))
+			c.Error(err)
+			c.Abort()
+			return
+		}
+		if limits.dailyTasks == 0 {
+			c.Next()
+			return
+		}
+
+		now := storage.Now().UTC()
+		dayStart := now.Truncate(24 * time.Hour)
+		day := dayStart.Format(time.DateOnly)
+		ok, err = l.auth.TakeDailyTask(c.Request.Context(), keyID, day, limits.dailyTasks)
+		if err != nil {
+			log.Error("failed to count created tasks", slog.Any("error", err))
+			c.Error(err)
+			c.Abort()
+			return
+		}
+		if !ok {
+			metrics.RateLimited(metrics.LimitDailyTasks)
+			log.Warn("daily task quota exceeded", slog.Int("quota", limits.dailyTasks))
+			c.Header(HeaderRetryAfter, strconv.Itoa(ceilSeconds(dayStart.Add(24*time.Hour).Sub(now))))
+			c.Error(ErrQuotaExceeded)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+
+		// the slot was taken before the handler ran, a request that created
+		// no task (invalid body, storage error) gives it back
+		if c.Writer.Status() != http.StatusCreated {
+			if err := l.auth.ReturnDailyTask(context.WithoutCancel(c.Request.Context()), keyID, day); err != nil {
+				log.Error("failed to return task quota slot", slog.Any("error", err))
+			}
+		}
+	}
+}
+
+// keyLimits returns the limits of the key keyID, from the cache when fresh
+func (l *RateLimiter) keyLimits(ctx context.Context, keyID int64) (keyLimits, error) {
+	now := time.Now()
+	l.mu.Lock()
+	cached, ok := l.cache[keyID]
+	l.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.keyLimits, nil
+	}
+
+	set, err := l.auth.EffectiveLimits(ctx, keyID)
+	if err != nil {
+		return keyLimits{}, err
+	}
+	limits := keyLimits{
+		rate: ratelimit.Limit{
+			PerMinute: valueOr(set.RequestsPerMinute, l.cfg.RequestsPerMinute),
+			Burst:     valueOr(set.Burst, l.cfg.Burst),
+		},
+		dailyTasks: valueOr(set.DailyTasks, l.cfg.DailyTasks),
+	}
+
+	l.mu.Lock()
+	l.cache[keyID] = cachedLimits{keyLimits: limits, expires: now.Add(l.cfg.CacheTTL)}
+	l.mu.Unlock()
+	return limits, nil
+}
+
+func valueOr(v *int, fallback int) int {
+	if v == nil {
+		return fallback
+	}
+	return *v
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
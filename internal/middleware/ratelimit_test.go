@@ -0,0 +1,148 @@
+package middleware_test
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"rest_api/internal/auth"
+	"rest_api/internal/config"
+	storage "rest_api/internal/db"
+	"rest_api/internal/db/dbtest"
+	"rest_api/internal/db/sqlite"
+	"rest_api/internal/handler"
+	"rest_api/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newRouter serves GET /ping behind the per key limit and POST /task behind
+// the daily quota, like cmd/api does. POST /task answers with the status of
+// its ?status= parameter, 201 by default. It returns the router and a key
+// with task.create.
+func newRouter(t *testing.T, cfg config.RateLimit) (*gin.Engine, string) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	db, err := sqlite.Open(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	dbtest.Migrate(t, db, storage.DialectSQLite)
+
+	authStorage := auth.NewStorage(db, storage.DialectSQLite)
+	if err := authStorage.CreatePermission("task.create"); err != nil {
+		t.Fatalf("create permission: %v", err)
+	}
+	key, _, err := authStorage.CreateKey("alice", []string{"task.create"})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+
+	limiter := middleware.NewRateLimiter(cfg, authStorage, log)
+	r := gin.New()
+	r.Use(handler.ErrorHandler())
+	authorized := r.Group("/", limiter.PerClientIP(), auth.AuthMiddleware(authStorage, log), limiter.PerKey())
+	authorized.GET("/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+	authorized.POST("/task", limiter.DailyTaskQuota(), func(c *gin.Context) {
+		status := http.StatusCreated
+		if s := c.Query("status"); s != "" {
+			status, _ = strconv.Atoi(s)
+		}
+		c.Status(status)
+	})
+	return r, key
+}
+
+func send(r *gin.Engine, method, path, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestPerKeyHeadersAndRetryAfter(t *testing.T) {
+	r, key := newRouter(t, config.RateLimit{Enabled: true, RequestsPerMinute: 1, Burst: 2, CacheTTL: time.Minute})
+
+	for i, remaining := range []string{"1", "0"} {
+		w := send(r, http.MethodGet, "/ping", key)
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want 200", i, w.Code)
+		}
+		if got := w.Header().Get(middleware.HeaderRateLimitLimit); got != "2" {
+			t.Errorf("request %d: %s = %q, want 2", i, middleware.HeaderRateLimitLimit, got)
+		}
+		if got := w.Header().Get(middleware.HeaderRateLimitRemaining); got != remaining {
+			t.Errorf("request %d: %s = %q, want %s", i, middleware.HeaderRateLimitRemaining, got, remaining)
+		}
+		if reset, err := strconv.Atoi(w.Header().Get(middleware.HeaderRateLimitReset)); err != nil || reset <= 0 {
+			t.Errorf("request %d: %s = %q, want seconds until the bucket is full",
+				i, middleware.HeaderRateLimitReset, w.Header().Get(middleware.HeaderRateLimitReset))
+		}
+	}
+
+	w := send(r, http.MethodGet, "/ping", key)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request past the burst: status = %d, want 429", w.Code)
+	}
+	// one token a minute
+	if retry, err := strconv.Atoi(w.Header().Get(middleware.HeaderRetryAfter)); err != nil || retry < 1 || retry > 60 {
+		t.Errorf("%s = %q, want 1-60 seconds", middleware.HeaderRetryAfter, w.Header().Get(middleware.HeaderRetryAfter))
+	}
+	if got := w.Header().Get(middleware.HeaderRateLimitRemaining); got != "0" {
+		t.Errorf("%s = %q, want 0", middleware.HeaderRateLimitRemaining, got)
+	}
+}
+
+func TestPerClientIPCountsInvalidKeys(t *testing.T) {
+	r, key := newRouter(t, config.RateLimit{Enabled: true, IPRequestsPerMinute: 1, IPBurst: 2, CacheTTL: time.Minute})
+
+	for i := range 2 {
+		if w := send(r, http.MethodGet, "/ping", "todo_live_guessed"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("guess %d: status = %d, want 401", i, w.Code)
+		}
+	}
+	// the guesses used up the bucket of the IP, before any key is looked up
+	if w := send(r, http.MethodGet, "/ping", "todo_live_guessed"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("guess past the burst: status = %d, want 429", w.Code)
+	}
+	if w := send(r, http.MethodGet, "/ping", key); w.Code != http.StatusTooManyRequests {
+		t.Errorf("valid key from the same IP: status = %d, want 429", w.Code)
+	}
+}
+
+func TestDailyTaskQuota(t *testing.T) {
+	r, key := newRouter(t, config.RateLimit{Enabled: true, DailyTasks: 2, CacheTTL: time.Minute})
+
+	if w := send(r, http.MethodPost, "/task", key); w.Code != http.StatusCreated {
+		t.Fatalf("first task: status = %d, want 201", w.Code)
+	}
+	// requests that create no task give their slot back
+	for _, status := range []int{http.StatusBadRequest, http.StatusInternalServerError} {
+		if w := send(r, http.MethodPost, "/task?status="+strconv.Itoa(status), key); w.Code != status {
+			t.Fatalf("failed create: status = %d, want %d", w.Code, status)
+		}
+	}
+	if w := send(r, http.MethodPost, "/task", key); w.Code != http.StatusCreated {
+		t.Fatalf("second task: status = %d, want 201, the failed creates used the quota", w.Code)
+	}
+
+	w := send(r, http.MethodPost, "/task", key)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("task past the quota: status = %d, want 429", w.Code)
+	}
+	// the quota resets at midnight UTC
+	if retry, err := strconv.Atoi(w.Header().Get(middleware.HeaderRetryAfter)); err != nil || retry < 1 || retry > 24*60*60 {
+		t.Errorf("%s = %q, want seconds until midnight UTC", middleware.HeaderRetryAfter, w.Header().Get(middleware.HeaderRetryAfter))
+	}
+}
@@ -0,0 +1,58 @@
+// Package middleware holds the gin middlewares shared by all routes:
+// request IDs, request logging and panic recovery.
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader is read from requests and set on every response
+const RequestIDHeader = "X-Request-ID"
+
+const (
+	ctxRequestID = "request_id"
+
+	maxRequestIDLen = 128
+)
+
+// RequestID keeps the X-Request-ID sent by the client (or a proxy in front
+// of the service) or generates one, and echoes it in the response.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Set(ctxRequestID, id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// GetRequestID returns the ID set by RequestID
+func GetRequestID(c *gin.Context) string {
+	return c.GetString(ctxRequestID)
+}
+
+// validRequestID accepts printable ASCII IDs of a sane length, so a client
+// can't inject log lines or huge values
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
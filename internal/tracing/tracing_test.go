@@ -0,0 +1,152 @@
+package tracing_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"rest_api/internal/config"
+	"rest_api/internal/db/memory"
+	"rest_api/internal/db/traced"
+	"rest_api/internal/handler"
+	"rest_api/internal/tracing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// exportedSpan is the part of a span the stdout exporter writes that the
+// tests look at
+type exportedSpan struct {
+	Name        string
+	SpanKind    trace.SpanKind
+	SpanContext struct {
+		TraceID string
+		SpanID  string
+	}
+	Parent struct {
+		TraceID string
+		SpanID  string
+	}
+	Attributes []struct {
+		Key   string
+		Value struct {
+			Value any
+		}
+	}
+}
+
+func (s exportedSpan) attribute(key string) any {
+	for _, a := range s.Attributes {
+		if a.Key == key {
+			return a.Value.Value
+		}
+	}
+	return nil
+}
+
+// readSpans decodes the spans written by the file exporter
+func readSpans(t *testing.T, path string) []exportedSpan {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open traces: %v", err)
+	}
+	defer f.Close()
+
+	var spans []exportedSpan
+	dec := json.NewDecoder(f)
+	for {
+		var s exportedSpan
+		if err := dec.Decode(&s); errors.Is(err, io.EOF) {
+			return spans
+		} else if err != nil {
+			t.Fatalf("decode span: %v", err)
+		}
+		spans = append(spans, s)
+	}
+}
+
+func TestRequestAndRepositorySpans(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	file := filepath.Join(t.TempDir(), "traces.jsonl")
+	shutdown, err := tracing.Setup(context.Background(), config.Tracing{
+		Exporter:    tracing.ExporterFile,
+		File:        file,
+		SampleRatio: 1,
+		ServiceName: "todo-api-test",
+	})
+	if err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+
+	// the chain of serve without auth, the repository is wrapped like there
+	tasks := handler.NewTaskHandler(traced.New(memory.New(), "memory"), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	r := gin.New()
+	r.Use(otelgin.Middleware("todo-api-test"), handler.ErrorHandler())
+	r.POST("/task", tasks.CreateTask)
+
+	// the caller's trace is continued
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodPost, "/task", strings.NewReader(`{"title":"traced"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /task = %d %s", w.Code, w.Body)
+	}
+
+	// flushes the batcher and closes the file
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	var server, repo *exportedSpan
+	for _, s := range readSpans(t, file) {
+		switch {
+		case s.SpanKind == trace.SpanKindServer:
+			server = &s
+		case s.Name == "storage.AddTask":
+			repo = &s
+		}
+	}
+	if server == nil {
+		t.Fatal("no HTTP server span exported")
+	}
+	if repo == nil {
+		t.Fatal("no storage.AddTask span exported")
+	}
+
+	if server.Name != "/task" {
+		t.Errorf("HTTP span name = %q, want the route /task", server.Name)
+	}
+	if server.SpanContext.TraceID != traceID || server.Parent.SpanID != "00f067aa0ba902b7" {
+		t.Errorf("HTTP span trace %s parent %s, want the traceparent of the request", server.SpanContext.TraceID, server.Parent.SpanID)
+	}
+	if repo.Parent.SpanID != server.SpanContext.SpanID || repo.SpanContext.TraceID != traceID {
+		t.Errorf("storage span parent %s in trace %s, want child of the HTTP span %s", repo.Parent.SpanID, repo.SpanContext.TraceID, server.SpanContext.SpanID)
+	}
+	if repo.SpanKind != trace.SpanKindClient {
+		t.Errorf("storage span kind = %v, want client", repo.SpanKind)
+	}
+	if got := repo.attribute("db.system"); got != "memory" {
+		t.Errorf("db.system = %v, want memory", got)
+	}
+}
+
+func TestSetupUnknownExporter(t *testing.T) {
+	if _, err := tracing.Setup(context.Background(), config.Tracing{Exporter: "jaeger"}); err == nil {
+		t.Error("Setup accepted an unknown exporter")
+	}
+}